@@ -30,11 +30,20 @@ func New(strict bool, onFailure int) Acknowledger {
 	}
 }
 
-// NewFromConfig creates a new Acknowledger from the given configuration.
-func NewFromConfig(cfg *config.Config) Acknowledger {
+// NewFromConfig creates a new Acknowledger from the given configuration. If an exit code mapping is configured, it
+// takes precedence over the strict and default behaviour.
+func NewFromConfig(cfg *config.Config) (Acknowledger, error) {
+	if cfg.HasExitCodeMapping() {
+		m, err := NewMapping(cfg.ExitCodes.Map, cfg.ExitCodes.Default)
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+
 	if cfg.RabbitMq.Stricfailure {
-		return &Strict{}
+		return &Strict{}, nil
 	}
 
-	return &Default{cfg.RabbitMq.Onfailure}
+	return &Default{cfg.RabbitMq.Onfailure}, nil
 }
@@ -0,0 +1,93 @@
+package acknowledger
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+)
+
+// ErrFail is returned when applying ActionFail.
+var ErrFail = errors.New("message processing failed")
+
+// Action is a fixed way to acknowledge a message, independent of any exit code.
+type Action string
+
+// Known actions.
+const (
+	// ActionAck acknowledges the message, removing it from the queue.
+	ActionAck Action = "ack"
+	// ActionRequeue negatively acknowledges the message, putting it back into the queue.
+	ActionRequeue Action = "requeue"
+	// ActionReject rejects the message without requeueing it. The broker discards the message or routes it to the dead
+	// letter exchange of the queue, if there is one.
+	ActionReject Action = "reject"
+	// ActionRejectRequeue rejects the message, putting it back into the queue.
+	ActionRejectRequeue Action = "reject-requeue"
+	// ActionNack negatively acknowledges the message without requeueing it.
+	ActionNack Action = "nack"
+	// ActionNackRequeue negatively acknowledges the message, putting it back into the queue. Same as ActionRequeue.
+	ActionNackRequeue Action = "nack-requeue"
+	// ActionDeadLetter behaves like ActionReject, stating the intent of the message ending up in the dead letter
+	// exchange of the queue.
+	ActionDeadLetter Action = "deadletter"
+	// ActionFail puts the message back into the queue and fails the consumer.
+	ActionFail Action = "fail"
+	// ActionRepublish publishes a copy of the message, enriched with details about the failure, and acknowledges the
+	// original. It requires the delivery to be a Republisher, otherwise the message is rejected.
+	ActionRepublish Action = "republish"
+)
+
+// Republisher is a delivery able to republish itself along with details about its failed processing.
+type Republisher interface {
+	Republish() error
+}
+
+// ParseAction converts the name of an action into an Action.
+func ParseAction(s string) (Action, error) {
+	switch a := Action(s); a {
+	case ActionAck, ActionRequeue, ActionReject, ActionRejectRequeue, ActionNack, ActionNackRequeue, ActionDeadLetter,
+		ActionFail, ActionRepublish:
+		return a, nil
+
+	default:
+		return "", fmt.Errorf("unknown action %q", s)
+	}
+}
+
+// Apply acknowledges the message according to the action. Like with the other acknowledgers, errors reported by the
+// delivery are not passed on. ActionFail results in ErrFail.
+func (a Action) Apply(d delivery.Delivery) error {
+	switch a {
+	case ActionAck:
+		d.Ack()
+
+	case ActionRequeue, ActionNackRequeue:
+		d.Nack(true)
+
+	case ActionReject, ActionDeadLetter:
+		d.Reject(false)
+
+	case ActionRejectRequeue:
+		d.Reject(true)
+
+	case ActionNack:
+		d.Nack(false)
+
+	case ActionFail:
+		d.Nack(true)
+		return ErrFail
+
+	case ActionRepublish:
+		if r, ok := d.(Republisher); ok {
+			r.Republish()
+		} else {
+			d.Reject(false)
+		}
+
+	default:
+		return fmt.Errorf("unknown action %q", string(a))
+	}
+
+	return nil
+}
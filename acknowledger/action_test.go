@@ -0,0 +1,64 @@
+package acknowledger_test
+
+import (
+	"testing"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/acknowledger"
+	"github.com/stretchr/testify/assert"
+)
+
+var actionTests = []struct {
+	name   string
+	method string
+	args   []interface{}
+}{
+	{"ack", "Ack", []interface{}{}},
+	{"requeue", "Nack", []interface{}{true}},
+	{"reject", "Reject", []interface{}{false}},
+	{"reject-requeue", "Reject", []interface{}{true}},
+	{"nack", "Nack", []interface{}{false}},
+	{"nack-requeue", "Nack", []interface{}{true}},
+	{"deadletter", "Reject", []interface{}{false}},
+	{"republish", "Reject", []interface{}{false}},
+}
+
+func TestAction_Apply(t *testing.T) {
+	for _, test := range actionTests {
+		t.Run(test.name, func(t *testing.T) {
+			a, err := acknowledger.ParseAction(test.name)
+			assert.Nil(t, err)
+
+			d := new(TestDelivery)
+			d.On(test.method, test.args...).Return(nil)
+			assert.Nil(t, a.Apply(d))
+			d.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAction_Apply_Fail(t *testing.T) {
+	d := new(TestDelivery)
+	d.On("Nack", true).Return(nil)
+	assert.Equal(t, acknowledger.ErrFail, acknowledger.ActionFail.Apply(d))
+	d.AssertExpectations(t)
+}
+
+func TestParseAction_Unknown(t *testing.T) {
+	_, err := acknowledger.ParseAction("drop")
+	assert.EqualError(t, err, `unknown action "drop"`)
+}
+
+type TestRepublisher struct {
+	TestDelivery
+}
+
+func (d *TestRepublisher) Republish() error {
+	return d.Called().Error(0)
+}
+
+func TestAction_Apply_Republish(t *testing.T) {
+	d := new(TestRepublisher)
+	d.On("Republish").Return(nil)
+	assert.Nil(t, acknowledger.ActionRepublish.Apply(d))
+	d.AssertExpectations(t)
+}
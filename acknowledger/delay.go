@@ -0,0 +1,74 @@
+package acknowledger
+
+import (
+	"sync"
+	"time"
+)
+
+// Delayer keeps track of actions applied once their delay has passed, so they are not lost on shutdown. The zero value
+// is ready to use.
+type Delayer struct {
+	mu      sync.Mutex
+	timers  map[*time.Timer]func()
+	pending int
+	wg      sync.WaitGroup
+}
+
+// Delay applies f once the delay has passed.
+func (d *Delayer) Delay(delay time.Duration, f func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timers == nil {
+		d.timers = make(map[*time.Timer]func())
+	}
+	d.pending++
+	d.wg.Add(1)
+
+	// The timer can not fire before being registered, as its function waits for the lock to be released.
+	var t *time.Timer
+	t = time.AfterFunc(delay, func() {
+		d.mu.Lock()
+		delete(d.timers, t)
+		d.mu.Unlock()
+		d.apply(f)
+	})
+	d.timers[t] = f
+}
+
+// Pending returns the number of actions not applied yet.
+func (d *Delayer) Pending() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.pending
+}
+
+// Flush applies the pending actions right away, without waiting for their delay to pass. It returns once all of them
+// have been applied.
+func (d *Delayer) Flush() {
+	d.mu.Lock()
+	var due []func()
+	for t, f := range d.timers {
+		// A timer failing to stop already fired, its action is applied by the timer itself.
+		if t.Stop() {
+			due = append(due, f)
+		}
+		delete(d.timers, t)
+	}
+	d.mu.Unlock()
+
+	for _, f := range due {
+		d.apply(f)
+	}
+	d.wg.Wait()
+}
+
+func (d *Delayer) apply(f func()) {
+	defer d.wg.Done()
+	f()
+
+	d.mu.Lock()
+	d.pending--
+	d.mu.Unlock()
+}
@@ -0,0 +1,40 @@
+package acknowledger_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/acknowledger"
+	"github.com/stretchr/testify/assert"
+)
+
+var delayerTests = []struct {
+	name    string
+	delay   time.Duration
+	wait    time.Duration
+	pending int
+}{
+	{"pending", time.Hour, 0, 1},
+	{"passed", time.Millisecond, 50 * time.Millisecond, 0},
+}
+
+func TestDelayer(t *testing.T) {
+	for _, test := range delayerTests {
+		t.Run(test.name, func(t *testing.T) {
+			var applied int32
+			d := &acknowledger.Delayer{}
+			d.Delay(test.delay, func() {
+				atomic.AddInt32(&applied, 1)
+			})
+			time.Sleep(test.wait)
+
+			assert.Equal(t, test.pending, d.Pending())
+			assert.Equal(t, int32(1-test.pending), atomic.LoadInt32(&applied))
+
+			d.Flush()
+			assert.Equal(t, 0, d.Pending())
+			assert.Equal(t, int32(1), atomic.LoadInt32(&applied))
+		})
+	}
+}
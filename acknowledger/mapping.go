@@ -0,0 +1,144 @@
+package acknowledger
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+)
+
+// signalCodes is the range of exit codes reported for processes killed by a signal.
+const signalCodes = "signal"
+
+// CodeRange is a range of exit codes.
+type CodeRange struct {
+	From int
+	To   int
+}
+
+// ParseCodeRange parses a single exit code like "3", a range like "10-19" or the keyword "signal", matching processes
+// killed by a signal.
+func ParseCodeRange(s string) (CodeRange, error) {
+	s = strings.TrimSpace(s)
+	if s == signalCodes {
+		return CodeRange{math.MinInt32, -1}, nil
+	}
+
+	from, to := s, s
+	if j := strings.Index(s, "-"); j >= 0 {
+		from, to = s[:j], s[j+1:]
+	}
+
+	var c CodeRange
+	var err error
+	if c.From, err = strconv.Atoi(strings.TrimSpace(from)); err != nil {
+		return CodeRange{}, err
+	}
+	if c.To, err = strconv.Atoi(strings.TrimSpace(to)); err != nil {
+		return CodeRange{}, err
+	}
+	if c.From < 0 || c.To < c.From {
+		return CodeRange{}, fmt.Errorf("invalid range of exit codes")
+	}
+
+	return c, nil
+}
+
+// Contains checks if the exit code is within the range.
+func (c CodeRange) Contains(code int) bool {
+	return code >= c.From && code <= c.To
+}
+
+// Rule maps a range of exit codes to an action.
+type Rule struct {
+	From   int
+	To     int
+	Action Action
+}
+
+// ParseRule parses a rule like "3:reject", "10-19:nack-requeue" or "signal:fail". The keyword "signal" matches
+// processes killed by a signal.
+func ParseRule(s string) (Rule, error) {
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return Rule{}, fmt.Errorf("invalid rule %q: expected <codes>:<action>", s)
+	}
+
+	codes, name := strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+	action, err := ParseAction(name)
+	if err != nil {
+		return Rule{}, fmt.Errorf("invalid rule %q: %v", s, err)
+	}
+
+	c, err := ParseCodeRange(codes)
+	if err != nil {
+		return Rule{}, fmt.Errorf("invalid rule %q: %v", s, err)
+	}
+
+	return Rule{c.From, c.To, action}, nil
+}
+
+// Matches checks if the exit code is covered by the rule.
+func (r Rule) Matches(code int) bool {
+	return code >= r.From && code <= r.To
+}
+
+// Mapping is an Acknowledger implementation looking up the action by the scripts exit code. The first rule matching
+// the exit code wins. Without a matching rule, exit code 0 acknowledges the message and all others are handled by
+// the default action.
+type Mapping struct {
+	Rules   []Rule
+	Default Action
+}
+
+// NewMapping creates a new Mapping from the given rules and the name of the default action. Without a default action,
+// messages are put back into the queue.
+func NewMapping(rules []string, def string) (*Mapping, error) {
+	m := &Mapping{Default: ActionNackRequeue}
+	for _, s := range rules {
+		r, err := ParseRule(s)
+		if err != nil {
+			return nil, err
+		}
+		m.Rules = append(m.Rules, r)
+	}
+
+	if def != "" {
+		a, err := ParseAction(def)
+		if err != nil {
+			return nil, fmt.Errorf("invalid default action: %v", err)
+		}
+		m.Default = a
+	}
+
+	return m, nil
+}
+
+// Ack acknowledges the message according to the action mapped to the scripts exit code. It is an error if the action
+// is ActionFail.
+func (m Mapping) Ack(d delivery.Delivery, code int) error {
+	if err := m.action(code).Apply(d); err != nil {
+		if err == ErrFail {
+			return fmt.Errorf("unexpected exit code %v", code)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (m Mapping) action(code int) Action {
+	for _, r := range m.Rules {
+		if r.Matches(code) {
+			return r.Action
+		}
+	}
+
+	if code == exitAck {
+		return ActionAck
+	}
+
+	return m.Default
+}
@@ -0,0 +1,119 @@
+package acknowledger_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/acknowledger"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var parseRuleTests = []struct {
+	rule string
+	exp  acknowledger.Rule
+	err  string
+}{
+	{"3:reject", acknowledger.Rule{From: 3, To: 3, Action: acknowledger.ActionReject}, ""},
+	{"10-19 : nack-requeue", acknowledger.Rule{From: 10, To: 19, Action: acknowledger.ActionNackRequeue}, ""},
+	{"signal:fail", acknowledger.Rule{From: math.MinInt32, To: -1, Action: acknowledger.ActionFail}, ""},
+	{"3", acknowledger.Rule{}, `invalid rule "3": expected <codes>:<action>`},
+	{"3:drop", acknowledger.Rule{}, `invalid rule "3:drop": unknown action "drop"`},
+	{"x:ack", acknowledger.Rule{}, `invalid rule "x:ack": strconv.Atoi: parsing "x": invalid syntax`},
+	{"19-10:ack", acknowledger.Rule{}, `invalid rule "19-10:ack": invalid range of exit codes`},
+}
+
+func TestParseRule(t *testing.T) {
+	for _, test := range parseRuleTests {
+		t.Run(test.rule, func(t *testing.T) {
+			r, err := acknowledger.ParseRule(test.rule)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+			} else {
+				assert.Nil(t, err)
+			}
+			assert.Equal(t, test.exp, r)
+		})
+	}
+}
+
+var mappingTests = []struct {
+	name   string
+	code   int
+	method string
+	args   []interface{}
+	err    string
+}{
+	{"success", 0, "Ack", []interface{}{}, ""},
+	{"single", 3, "Reject", []interface{}{true}, ""},
+	{"rangeStart", 10, "Nack", []interface{}{false}, ""},
+	{"rangeEnd", 19, "Nack", []interface{}{false}, ""},
+	{"overlapping", 20, "Ack", []interface{}{}, ""},
+	{"signal", -1, "Nack", []interface{}{true}, "unexpected exit code -1"},
+	{"default", 42, "Reject", []interface{}{false}, ""},
+}
+
+func TestMapping(t *testing.T) {
+	a, err := acknowledger.NewMapping([]string{"3:reject-requeue", "10-19:nack", "20:ack", "15-25:fail", "signal:fail"}, "reject")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range mappingTests {
+		t.Run(test.name, func(t *testing.T) {
+			d := new(TestDelivery)
+			d.On(test.method, test.args...).Return(nil)
+			err := a.Ack(d, test.code)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+			} else {
+				assert.Nil(t, err)
+			}
+			d.AssertExpectations(t)
+		})
+	}
+}
+
+var newFromConfigTests = []struct {
+	name   string
+	config string
+	exp    acknowledger.Acknowledger
+	err    string
+}{
+	{"default", "[rabbitmq]\nonfailure = 3", &acknowledger.Default{OnFailure: 3}, ""},
+	{"strict", "[rabbitmq]\nstricfailure = On", &acknowledger.Strict{}, ""},
+	{
+		"mapping",
+		"[rabbitmq]\nstricfailure = On\n[exitcodes]\nmap = 3:reject\nmap = 4-5:ack",
+		&acknowledger.Mapping{
+			Rules: []acknowledger.Rule{
+				{From: 3, To: 3, Action: acknowledger.ActionReject},
+				{From: 4, To: 5, Action: acknowledger.ActionAck},
+			},
+			Default: acknowledger.ActionNackRequeue,
+		},
+		"",
+	},
+	{"defaultOnly", "[exitcodes]\ndefault = fail", &acknowledger.Mapping{Default: acknowledger.ActionFail}, ""},
+	{"invalidDefault", "[exitcodes]\ndefault = drop", nil, `invalid default action: unknown action "drop"`},
+}
+
+func TestNewFromConfig(t *testing.T) {
+	for _, test := range newFromConfigTests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := config.CreateFromString(test.config)
+			if err != nil {
+				t.Fatalf("failed to load config: %v", err)
+			}
+
+			a, err := acknowledger.NewFromConfig(cfg)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				assert.Nil(t, a)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.exp, a)
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package acknowledger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+	"github.com/streadway/amqp"
+)
+
+// Response is the structured acknowledgement written by the executable, e.g.
+//
+//	{"action": "reject", "reason": "invalid address", "headers": {"x-error": "invalid address"}}
+//
+// The delay is either a number of milliseconds or a duration like "30s".
+type Response struct {
+	Action  Action
+	Delay   time.Duration
+	Reason  string
+	Headers amqp.Table
+}
+
+// ParseResponse parses the response written by the executable. If nothing was written, nil is returned.
+func ParseResponse(b []byte) (*Response, error) {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil, nil
+	}
+
+	var raw struct {
+		Action  string                 `json:"action"`
+		Delay   interface{}            `json:"delay"`
+		Reason  string                 `json:"reason"`
+		Headers map[string]interface{} `json:"headers"`
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %v", err)
+	}
+
+	r := &Response{Reason: raw.Reason}
+
+	if raw.Action != "" {
+		a, err := ParseAction(raw.Action)
+		if err != nil {
+			return nil, fmt.Errorf("invalid response: %v", err)
+		}
+		r.Action = a
+	}
+
+	delay, err := parseDelay(raw.Delay)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+	r.Delay = delay
+
+	if len(raw.Headers) > 0 {
+		r.Headers = delivery.NewTable(raw.Headers)
+	}
+
+	return r, nil
+}
+
+func parseDelay(v interface{}) (time.Duration, error) {
+	switch t := v.(type) {
+	case nil:
+		return 0, nil
+
+	case json.Number:
+		ms, err := t.Float64()
+		if err != nil || ms < 0 {
+			return 0, fmt.Errorf("invalid delay %v", t)
+		}
+		return time.Duration(ms * float64(time.Millisecond)), nil
+
+	case string:
+		d, err := time.ParseDuration(t)
+		if err != nil || d < 0 {
+			return 0, fmt.Errorf("invalid delay %q", t)
+		}
+		return d, nil
+
+	default:
+		return 0, fmt.Errorf("invalid delay %v", v)
+	}
+}
+
+// Publisher publishes messages, e.g. to dead letter a message with additional headers.
+type Publisher interface {
+	Publish(exchange, key string, msg amqp.Publishing) error
+}
+
+// ResponseAcknowledger is an Acknowledger able to take the response of the executable into account.
+type ResponseAcknowledger interface {
+	Acknowledger
+	AckResponse(d delivery.Delivery, code int, r *Response) error
+}
+
+// Responding is an Acknowledger implementation acknowledging messages according to the response of the executable.
+// Without a response or if the response does not specify an action, the exit code is handled by the embedded
+// Acknowledger.
+//
+// Messages to be requeued with a delay are acknowledged once the delay has passed, keeping track of them in the Delayer
+// if set. Messages rejected with headers are republished to the dead letter exchange, including the headers, and
+// acknowledged afterwards. This requires the dead letter exchange to be configured. Otherwise or if republishing fails,
+// the message is rejected without the headers.
+type Responding struct {
+	Acknowledger
+	Publisher            Publisher
+	DeadLetterExchange   string
+	DeadLetterRoutingKey string
+	Delayer              *Delayer
+}
+
+// AckResponse acknowledges the message according to the response, falling back to the exit code.
+func (a Responding) AckResponse(d delivery.Delivery, code int, r *Response) error {
+	if r == nil || r.Action == "" {
+		return a.Ack(d, code)
+	}
+
+	if r.Delay > 0 && requeues(r.Action) {
+		apply := func() {
+			r.Action.Apply(d)
+		}
+		if a.Delayer != nil {
+			a.Delayer.Delay(r.Delay, apply)
+		} else {
+			time.AfterFunc(r.Delay, apply)
+		}
+		return nil
+	}
+
+	if len(r.Headers) > 0 && deadLetters(r.Action) && a.deadLetter(d, r.Headers) == nil {
+		return ActionAck.Apply(d)
+	}
+
+	return r.Action.Apply(d)
+}
+
+func (a Responding) deadLetter(d delivery.Delivery, headers amqp.Table) error {
+	if a.Publisher == nil || a.DeadLetterExchange == "" {
+		return fmt.Errorf("no dead letter exchange configured")
+	}
+
+	key := a.DeadLetterRoutingKey
+	if key == "" {
+		key = d.Info().RoutingKey
+	}
+
+	msg := d.Properties().Publishing(d.Body())
+	for k, v := range headers {
+		msg.Headers[k] = v
+	}
+
+	return a.Publisher.Publish(a.DeadLetterExchange, key, msg)
+}
+
+func requeues(a Action) bool {
+	return a == ActionRequeue || a == ActionNackRequeue || a == ActionRejectRequeue
+}
+
+func deadLetters(a Action) bool {
+	return a == ActionReject || a == ActionNack || a == ActionDeadLetter
+}
@@ -0,0 +1,165 @@
+package acknowledger_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/acknowledger"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var parseResponseTests = []struct {
+	name     string
+	response string
+	exp      *acknowledger.Response
+	err      string
+}{
+	{"empty", " \n", nil, ""},
+	{"action", `{"action":"ack"}`, &acknowledger.Response{Action: acknowledger.ActionAck}, ""},
+	{"reason", `{"reason":"done"}`, &acknowledger.Response{Reason: "done"}, ""},
+	{"delayMilliseconds", `{"action":"requeue","delay":1500}`, &acknowledger.Response{Action: acknowledger.ActionRequeue, Delay: 1500 * time.Millisecond}, ""},
+	{"delayDuration", `{"action":"requeue","delay":"1m"}`, &acknowledger.Response{Action: acknowledger.ActionRequeue, Delay: time.Minute}, ""},
+	{
+		"headers",
+		`{"action":"reject","headers":{"x-error":"invalid","x-code":42,"x-score":0.5,"x-details":{"field":"to"},"x-tags":["a",1]}}`,
+		&acknowledger.Response{
+			Action: acknowledger.ActionReject,
+			Headers: amqp.Table{
+				"x-error":   "invalid",
+				"x-code":    int64(42),
+				"x-score":   0.5,
+				"x-details": amqp.Table{"field": "to"},
+				"x-tags":    []interface{}{"a", int64(1)},
+			},
+		},
+		"",
+	},
+	{"invalidJSON", `{"action":`, nil, "failed to parse response: unexpected EOF"},
+	{"unknownAction", `{"action":"drop"}`, nil, `invalid response: unknown action "drop"`},
+	{"negativeDelay", `{"delay":-1}`, nil, "invalid response: invalid delay -1"},
+	{"invalidDelay", `{"delay":true}`, nil, "invalid response: invalid delay true"},
+}
+
+func TestParseResponse(t *testing.T) {
+	for _, test := range parseResponseTests {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := acknowledger.ParseResponse([]byte(test.response))
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+			} else {
+				assert.Nil(t, err)
+			}
+			assert.Equal(t, test.exp, r)
+		})
+	}
+}
+
+type TestPublisher struct {
+	mock.Mock
+}
+
+func (p *TestPublisher) Publish(exchange, key string, msg amqp.Publishing) error {
+	return p.Called(exchange, key, msg).Error(0)
+}
+
+var respondingTests = []struct {
+	name     string
+	response *acknowledger.Response
+	dlx      string
+	setup    func(d *TestDelivery, p *TestPublisher)
+}{
+	{
+		"noResponse",
+		nil,
+		"",
+		func(d *TestDelivery, p *TestPublisher) {
+			d.On("Nack", true).Return(nil)
+		},
+	},
+	{
+		"noAction",
+		&acknowledger.Response{Reason: "done"},
+		"",
+		func(d *TestDelivery, p *TestPublisher) {
+			d.On("Nack", true).Return(nil)
+		},
+	},
+	{
+		"action",
+		&acknowledger.Response{Action: acknowledger.ActionAck},
+		"",
+		func(d *TestDelivery, p *TestPublisher) {
+			d.On("Ack").Return(nil)
+		},
+	},
+	{
+		"delay",
+		&acknowledger.Response{Action: acknowledger.ActionRequeue, Delay: time.Millisecond},
+		"",
+		func(d *TestDelivery, p *TestPublisher) {
+			d.On("Nack", true).Return(nil)
+		},
+	},
+	{
+		"headersWithoutDeadLetterExchange",
+		&acknowledger.Response{Action: acknowledger.ActionReject, Headers: amqp.Table{"x-error": "invalid"}},
+		"",
+		func(d *TestDelivery, p *TestPublisher) {
+			d.On("Reject", false).Return(nil)
+		},
+	},
+	{
+		"headers",
+		&acknowledger.Response{Action: acknowledger.ActionDeadLetter, Headers: amqp.Table{"x-error": "invalid"}},
+		"dlx",
+		func(d *TestDelivery, p *TestPublisher) {
+			d.On("Properties").Return(delivery.Properties{Headers: amqp.Table{"foo": "bar"}})
+			d.On("Info").Return(delivery.Info{RoutingKey: "mail"})
+			d.On("Body").Return([]byte("body"))
+			p.On("Publish", "dlx", "mail", amqp.Publishing{
+				Headers: amqp.Table{"foo": "bar", "x-error": "invalid"},
+				Body:    []byte("body"),
+			}).Return(nil)
+			d.On("Ack").Return(nil)
+		},
+	},
+	{
+		"headersPublishError",
+		&acknowledger.Response{Action: acknowledger.ActionReject, Headers: amqp.Table{"x-error": "invalid"}},
+		"dlx",
+		func(d *TestDelivery, p *TestPublisher) {
+			d.On("Properties").Return(delivery.Properties{})
+			d.On("Info").Return(delivery.Info{RoutingKey: "mail"})
+			d.On("Body").Return([]byte("body"))
+			p.On("Publish", "dlx", "mail", mock.Anything).Return(fmt.Errorf("channel closed"))
+			d.On("Reject", false).Return(nil)
+		},
+	},
+}
+
+func TestResponding_AckResponse(t *testing.T) {
+	for _, test := range respondingTests {
+		t.Run(test.name, func(t *testing.T) {
+			d := new(TestDelivery)
+			p := new(TestPublisher)
+			test.setup(d, p)
+
+			dl := &acknowledger.Delayer{}
+			a := acknowledger.Responding{
+				Acknowledger:       &acknowledger.Default{},
+				Publisher:          p,
+				DeadLetterExchange: test.dlx,
+				Delayer:            dl,
+			}
+
+			assert.Nil(t, a.AckResponse(d, 1, test.response))
+			dl.Flush()
+			d.AssertExpectations(t)
+			p.AssertExpectations(t)
+		})
+	}
+}
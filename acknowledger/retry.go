@@ -0,0 +1,107 @@
+package acknowledger
+
+import (
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+)
+
+// RetryHeader is the message header holding the number of attempts made to process the message before.
+const RetryHeader = "x-retry-attempts"
+
+// Retrying is an Acknowledger implementation retrying failed messages with a delay instead of requeueing them
+// immediately. The decision is made by the embedded Acknowledger. Each message it would requeue is published to the
+// retry queue of the current attempt, from where it gets dead lettered back into the queue once the delay has passed.
+// After the last attempt, the message is published to the parking lot queue instead.
+//
+// The original message is acknowledged once it has been published. If publishing fails, it gets requeued immediately.
+type Retrying struct {
+	Acknowledger
+	Publisher  Publisher
+	Queues     []string
+	ParkingLot string
+}
+
+// Ack acknowledges the message according to the exit code.
+func (a Retrying) Ack(d delivery.Delivery, code int) error {
+	return a.Acknowledger.Ack(a.wrap(d), code)
+}
+
+// AckResponse acknowledges the message according to the response, if supported by the embedded Acknowledger.
+func (a Retrying) AckResponse(d delivery.Delivery, code int, r *Response) error {
+	if ra, ok := a.Acknowledger.(ResponseAcknowledger); ok {
+		return ra.AckResponse(a.wrap(d), code, r)
+	}
+
+	return a.Ack(d, code)
+}
+
+func (a Retrying) wrap(d delivery.Delivery) delivery.Delivery {
+	return &retryDelivery{Delivery: d, retrying: a}
+}
+
+// retry publishes the message to the next retry queue or to the parking lot queue.
+func (a Retrying) retry(d delivery.Delivery) error {
+	attempts := Attempts(d)
+	queue := a.ParkingLot
+	msg := d.Properties().Publishing(d.Body())
+	if attempts < len(a.Queues) {
+		queue = a.Queues[attempts]
+		msg.Headers[RetryHeader] = int64(attempts + 1)
+	}
+
+	if err := a.Publisher.Publish("", queue, msg); err != nil {
+		return d.Nack(true)
+	}
+
+	return d.Ack()
+}
+
+// Attempts returns the number of attempts made to process the message before.
+func Attempts(d delivery.Delivery) int {
+	switch v := d.Properties().Headers[RetryHeader].(type) {
+	case int8:
+		return int(v)
+	case int16:
+		return int(v)
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	default:
+		return 0
+	}
+}
+
+// retryDelivery turns requeueing the message into retrying it.
+type retryDelivery struct {
+	delivery.Delivery
+	retrying Retrying
+}
+
+// Nack negatively acknowledges the message. If it is to be requeued, it gets retried instead.
+func (d *retryDelivery) Nack(requeue bool) error {
+	if requeue {
+		return d.retrying.retry(d.Delivery)
+	}
+
+	return d.Delivery.Nack(false)
+}
+
+// Reject rejects the message. If it is to be requeued, it gets retried instead.
+func (d *retryDelivery) Reject(requeue bool) error {
+	if requeue {
+		return d.retrying.retry(d.Delivery)
+	}
+
+	return d.Delivery.Reject(false)
+}
+
+// Republish republishes the message, if supported by the wrapped delivery. Otherwise the message is rejected.
+func (d *retryDelivery) Republish() error {
+	if r, ok := d.Delivery.(Republisher); ok {
+		return r.Republish()
+	}
+
+	return d.Delivery.Reject(false)
+}
@@ -0,0 +1,123 @@
+package acknowledger_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/acknowledger"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var retryingTests = []struct {
+	name  string
+	code  int
+	setup func(d *TestDelivery, p *TestPublisher)
+}{
+	{
+		"success",
+		0,
+		func(d *TestDelivery, p *TestPublisher) {
+			d.On("Ack").Return(nil)
+		},
+	},
+	{
+		"firstAttempt",
+		6,
+		func(d *TestDelivery, p *TestPublisher) {
+			d.On("Properties").Return(delivery.Properties{Headers: amqp.Table{"foo": "bar"}})
+			d.On("Body").Return([]byte("body"))
+			p.On("Publish", "", "mail.retry.10s", amqp.Publishing{
+				Headers: amqp.Table{"foo": "bar", acknowledger.RetryHeader: int64(1)},
+				Body:    []byte("body"),
+			}).Return(nil)
+			d.On("Ack").Return(nil)
+		},
+	},
+	{
+		"secondAttempt",
+		4,
+		func(d *TestDelivery, p *TestPublisher) {
+			d.On("Properties").Return(delivery.Properties{Headers: amqp.Table{acknowledger.RetryHeader: int32(1)}})
+			d.On("Body").Return([]byte("body"))
+			p.On("Publish", "", "mail.retry.1m", amqp.Publishing{
+				Headers: amqp.Table{acknowledger.RetryHeader: int64(2)},
+				Body:    []byte("body"),
+			}).Return(nil)
+			d.On("Ack").Return(nil)
+		},
+	},
+	{
+		"parkingLot",
+		6,
+		func(d *TestDelivery, p *TestPublisher) {
+			d.On("Properties").Return(delivery.Properties{Headers: amqp.Table{acknowledger.RetryHeader: int64(2)}})
+			d.On("Body").Return([]byte("body"))
+			p.On("Publish", "", "mail.parking-lot", amqp.Publishing{
+				Headers: amqp.Table{acknowledger.RetryHeader: int64(2)},
+				Body:    []byte("body"),
+			}).Return(nil)
+			d.On("Ack").Return(nil)
+		},
+	},
+	{
+		"publishError",
+		6,
+		func(d *TestDelivery, p *TestPublisher) {
+			d.On("Properties").Return(delivery.Properties{})
+			d.On("Body").Return([]byte("body"))
+			p.On("Publish", "", "mail.retry.10s", mock.Anything).Return(fmt.Errorf("channel closed"))
+			d.On("Nack", true).Return(nil)
+		},
+	},
+	{
+		"reject",
+		3,
+		func(d *TestDelivery, p *TestPublisher) {
+			d.On("Reject", false).Return(nil)
+		},
+	},
+}
+
+func TestRetrying_Ack(t *testing.T) {
+	for _, test := range retryingTests {
+		t.Run(test.name, func(t *testing.T) {
+			d := new(TestDelivery)
+			p := new(TestPublisher)
+			test.setup(d, p)
+
+			a := acknowledger.Retrying{
+				Acknowledger: &acknowledger.Strict{},
+				Publisher:    p,
+				Queues:       []string{"mail.retry.10s", "mail.retry.1m"},
+				ParkingLot:   "mail.parking-lot",
+			}
+
+			assert.Nil(t, a.Ack(d, test.code))
+			d.AssertExpectations(t)
+			p.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRetrying_AckResponse(t *testing.T) {
+	d := new(TestDelivery)
+	p := new(TestPublisher)
+	d.On("Properties").Return(delivery.Properties{})
+	d.On("Body").Return([]byte("body"))
+	p.On("Publish", "", "mail.retry.10s", mock.Anything).Return(nil)
+	d.On("Ack").Return(nil)
+
+	a := acknowledger.Retrying{
+		Acknowledger: acknowledger.Responding{Acknowledger: &acknowledger.Default{}},
+		Publisher:    p,
+		Queues:       []string{"mail.retry.10s"},
+		ParkingLot:   "mail.parking-lot",
+	}
+
+	assert.Nil(t, a.AckResponse(d, 0, &acknowledger.Response{Action: acknowledger.ActionRequeue}))
+	d.AssertExpectations(t)
+	p.AssertExpectations(t)
+}
@@ -0,0 +1,45 @@
+package backoff
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Policy describes an exponential backoff with an optional random jitter and a limited number of attempts.
+type Policy struct {
+	// Attempts is the maximum number of attempts. Zero disables retrying, a negative value allows unlimited attempts.
+	Attempts int
+	// Initial is the duration to wait before the first attempt.
+	Initial time.Duration
+	// Max caps the duration to wait in between two attempts. Zero means no limit.
+	Max time.Duration
+	// Multiplier is the factor by which the duration grows with each attempt. Values smaller than one are treated as one.
+	Multiplier float64
+	// Jitter is the fraction by which the duration gets randomised, e.g. 0.2 results in a duration of ±20%.
+	Jitter float64
+}
+
+// Exhausted checks if the given attempt, counting from one, exceeds the maximum number of attempts.
+func (p Policy) Exhausted(attempt int) bool {
+	return p.Attempts >= 0 && attempt > p.Attempts
+}
+
+// Duration returns the time to wait before the given attempt, counting from one.
+func (p Policy) Duration(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	multiplier := math.Max(p.Multiplier, 1)
+	d := float64(p.Initial) * math.Pow(multiplier, float64(attempt-1))
+	if p.Max > 0 {
+		d = math.Min(d, float64(p.Max))
+	}
+
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(d)
+}
@@ -0,0 +1,59 @@
+package backoff_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/backoff"
+	"github.com/stretchr/testify/assert"
+)
+
+var exhaustedTests = []struct {
+	name     string
+	attempts int
+	attempt  int
+	want     bool
+}{
+	{"disabled", 0, 1, true},
+	{"first", 3, 1, false},
+	{"last", 3, 3, false},
+	{"exceeded", 3, 4, true},
+	{"unlimited", -1, 1000, false},
+}
+
+func TestPolicy_Exhausted(t *testing.T) {
+	for _, test := range exhaustedTests {
+		t.Run(test.name, func(t *testing.T) {
+			p := backoff.Policy{Attempts: test.attempts}
+			assert.Equal(t, test.want, p.Exhausted(test.attempt))
+		})
+	}
+}
+
+var durationTests = []struct {
+	name    string
+	policy  backoff.Policy
+	attempt int
+	want    time.Duration
+}{
+	{"constant", backoff.Policy{Initial: time.Second}, 5, time.Second},
+	{"first", backoff.Policy{Initial: time.Second, Multiplier: 2}, 1, time.Second},
+	{"exponential", backoff.Policy{Initial: time.Second, Multiplier: 2}, 4, 8 * time.Second},
+	{"capped", backoff.Policy{Initial: time.Second, Multiplier: 2, Max: 5 * time.Second}, 4, 5 * time.Second},
+	{"zero attempt", backoff.Policy{Initial: time.Second, Multiplier: 2}, 0, time.Second},
+}
+
+func TestPolicy_Duration(t *testing.T) {
+	for _, test := range durationTests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, test.policy.Duration(test.attempt))
+		})
+	}
+	t.Run("jitter", func(t *testing.T) {
+		p := backoff.Policy{Initial: time.Second, Jitter: 0.5}
+		for i := 0; i < 100; i++ {
+			d := p.Duration(1)
+			assert.True(t, d >= 500*time.Millisecond && d <= 1500*time.Millisecond, "duration %v out of range", d)
+		}
+	})
+}
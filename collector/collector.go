@@ -14,24 +14,46 @@ var (
 			Name:      "process_total",
 			Help:      "The total number of processes executed.",
 		},
-		[]string{"exit_code"},
+		[]string{"consumer", "exit_code"},
 	)
 
 	// ProcessDuration is a Prometheus metric describing the time spent by the consumer to process the message.
-	ProcessDuration = prometheus.NewHistogram(
+	ProcessDuration = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: namespace,
 			Name:      "process_duration_seconds",
 			Help:      "The time spent by the consumer to process the message.",
 		},
+		[]string{"consumer"},
+	)
+
+	// ProcessTimeouts is a Prometheus metric describing the total number of processes terminated due to a timeout.
+	ProcessTimeouts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "process_timeouts_total",
+			Help:      "The total number of processes terminated due to a timeout.",
+		},
+		[]string{"consumer"},
 	)
 
 	// MessageDuration is a Prometheus metric describing the time spent from publishing to finished processing the message.
-	MessageDuration = prometheus.NewHistogram(
+	MessageDuration = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: namespace,
 			Name:      "message_duration_seconds",
 			Help:      "The time spent from publishing to finished processing the message.",
 		},
+		[]string{"consumer"},
+	)
+
+	// ConnectedNode is a Prometheus metric describing the broker node currently connected to.
+	ConnectedNode = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "connected_node",
+			Help:      "The broker node currently connected to, set to 1 while connected.",
+		},
+		[]string{"node"},
 	)
 )
@@ -29,6 +29,24 @@ type Builder interface {
 	GetCommand(p delivery.Properties, d delivery.Info, body []byte) (*exec.Cmd, error)
 }
 
+// ResponseBuilder is a Builder whose commands can write a response on an additional file descriptor.
+type ResponseBuilder interface {
+	Builder
+
+	// GetCommandWithResponse gets the executable command like GetCommand. If responses are enabled, the returned
+	// reader yields the response written by the command, otherwise it is nil.
+	GetCommandWithResponse(p delivery.Properties, d delivery.Info, body []byte) (*exec.Cmd, io.ReadCloser, error)
+}
+
+// BatchBuilder is a Builder whose commands process several messages at once.
+type BatchBuilder interface {
+	Builder
+
+	// GetBatchCommand gets the executable command for the given messages. The returned reader yields the results
+	// written by the command, one line per message.
+	GetBatchCommand(ds []delivery.Delivery) (*exec.Cmd, io.ReadCloser, error)
+}
+
 // NewBuilder ensures a builder struct is setup and ready to be used.
 func NewBuilder(b Builder, cmd string, capture bool, l logr.Logger, infoW, errW io.Writer) (Builder, error) {
 	b.SetCommand(cmd)
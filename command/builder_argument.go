@@ -79,7 +79,9 @@ func (b *ArgumentBuilder) GetCommand(p delivery.Properties, d delivery.Info, bod
 		return nil, err
 	}
 
-	cmd := exec.Command(b.cmd, append(b.args, buf.String())...)
+	// Copy the arguments, so concurrent calls do not share the underlying array.
+	args := append(append([]string{}, b.args...), buf.String())
+	cmd := exec.Command(b.cmd, args...)
 	cmd.Env = os.Environ()
 
 	if b.capture {
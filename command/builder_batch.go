@@ -0,0 +1,101 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/bketelsen/logr"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+)
+
+// JSONLinesBuilder passes a batch of messages via STDIN as JSON Lines, one line per message holding its properties,
+// delivery info and body encoded as base64. The command gets fd4 to write one result per message to, as JSON Lines in
+// the order of the messages, like the response in pipe mode.
+type JSONLinesBuilder struct {
+	Builder
+	log          logr.Logger
+	outputWriter io.Writer
+	errorWriter  io.Writer
+	cmd          string
+	args         []string
+	capture      bool
+}
+
+// SetLogger is part of Builder.
+func (b *JSONLinesBuilder) SetLogger(l logr.Logger) {
+	b.log = l
+}
+
+func (b *JSONLinesBuilder) SetOutputWriter(w io.Writer) {
+	b.outputWriter = w
+}
+
+func (b *JSONLinesBuilder) SetErrorWriter(w io.Writer) {
+	b.errorWriter = w
+}
+
+func (b *JSONLinesBuilder) SetCommand(cmd string) {
+	var args []string
+
+	if split := strings.Split(cmd, " "); len(split) > 1 {
+		cmd, args = split[0], split[1:]
+	}
+
+	b.cmd = cmd
+	b.args = args
+}
+
+func (b *JSONLinesBuilder) SetCaptureOutput(capture bool) {
+	b.capture = capture
+}
+
+// GetCommand is part of Builder. Commands of this builder require the results of a batch to be read, hence building a
+// command for a single message is not supported.
+func (b *JSONLinesBuilder) GetCommand(p delivery.Properties, d delivery.Info, body []byte) (*exec.Cmd, error) {
+	return nil, fmt.Errorf("messages must be passed as batch")
+}
+
+// GetBatchCommand is part of BatchBuilder. The write end of the results pipe is passed as fd4 and must be closed by
+// the caller once the command has been started.
+func (b *JSONLinesBuilder) GetBatchCommand(ds []delivery.Delivery) (*exec.Cmd, io.ReadCloser, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, d := range ds {
+		err := enc.Encode(&struct {
+			Properties   delivery.Properties `json:"properties"`
+			DeliveryInfo delivery.Info       `json:"delivery_info"`
+			Body         []byte              `json:"body"`
+		}{
+			Properties:   d.Properties(),
+			DeliveryInfo: d.Info(),
+			Body:         d.Body(),
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to marshall message: %v", err)
+		}
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create results pipe: %v", err)
+	}
+
+	cmd := exec.Command(b.cmd, b.args...)
+
+	cmd.Env = os.Environ()
+	cmd.Stdin = &buf
+	// The metadata is part of the lines, so fd3 is unused and closed in the child.
+	cmd.ExtraFiles = []*os.File{nil, w}
+
+	if b.capture {
+		cmd.Stdout = b.outputWriter
+		cmd.Stderr = b.errorWriter
+	}
+
+	return cmd, r, nil
+}
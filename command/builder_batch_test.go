@@ -0,0 +1,46 @@
+package command_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/command"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONLinesBuilder_GetBatchCommand(t *testing.T) {
+	b, ib, eb := createAndAssertBuilder(t, &command.JSONLinesBuilder{}, "batch --verbose", true)
+	ds := []delivery.Delivery{
+		delivery.New(amqp.Delivery{MessageId: "1", DeliveryTag: 1, Body: []byte("lorem")}),
+		delivery.New(amqp.Delivery{MessageId: "2", DeliveryTag: 2, Body: []byte("ipsum")}),
+	}
+
+	cmd, r, err := b.(command.BatchBuilder).GetBatchCommand(ds)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"batch", "--verbose"}, cmd.Args)
+	assert.Equal(t, os.Environ(), cmd.Env)
+	assertWriter(t, ib, cmd.Stdout, true)
+	assertWriter(t, eb, cmd.Stderr, true)
+
+	input, _ := ioutil.ReadAll(cmd.Stdin)
+	assert.Equal(t, "{\"properties\":{\"application_headers\":null,\"content_type\":\"\",\"content_encoding\":\"\",\"delivery_mode\":0,\"priority\":0,\"correlation_id\":\"\",\"reply_to\":\"\",\"expiration\":\"\",\"message_id\":\"1\",\"timestamp\":\"0001-01-01T00:00:00Z\",\"type\":\"\",\"user_id\":\"\",\"app_id\":\"\"},\"delivery_info\":{\"message_count\":0,\"consumer_tag\":\"\",\"delivery_tag\":1,\"redelivered\":false,\"exchange\":\"\",\"routing_key\":\"\"},\"body\":\"bG9yZW0=\"}\n"+
+		"{\"properties\":{\"application_headers\":null,\"content_type\":\"\",\"content_encoding\":\"\",\"delivery_mode\":0,\"priority\":0,\"correlation_id\":\"\",\"reply_to\":\"\",\"expiration\":\"\",\"message_id\":\"2\",\"timestamp\":\"0001-01-01T00:00:00Z\",\"type\":\"\",\"user_id\":\"\",\"app_id\":\"\"},\"delivery_info\":{\"message_count\":0,\"consumer_tag\":\"\",\"delivery_tag\":2,\"redelivered\":false,\"exchange\":\"\",\"routing_key\":\"\"},\"body\":\"aXBzdW0=\"}\n", string(input))
+
+	// The results are written to fd4, leaving fd3 closed.
+	assert.Len(t, cmd.ExtraFiles, 2)
+	assert.Nil(t, cmd.ExtraFiles[0])
+	cmd.ExtraFiles[1].Write([]byte("{\"action\":\"ack\"}\n"))
+	cmd.ExtraFiles[1].Close()
+	results, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "{\"action\":\"ack\"}\n", string(results))
+	r.Close()
+}
+
+func TestJSONLinesBuilder_GetCommand(t *testing.T) {
+	b, _, _ := createAndAssertBuilder(t, &command.JSONLinesBuilder{}, "batch", false)
+	_, err := b.GetCommand(delivery.Properties{}, delivery.Info{}, []byte("body"))
+	assert.EqualError(t, err, "messages must be passed as batch")
+}
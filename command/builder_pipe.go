@@ -13,8 +13,11 @@ import (
 	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
 )
 
+// PipeBuilder passes the message body via STDIN and its metadata as JSON via fd3. With Response set, the command gets
+// fd4 to write a structured acknowledgement to.
 type PipeBuilder struct {
 	Builder
+	Response     bool
 	log          logr.Logger
 	outputWriter io.Writer
 	errorWriter  io.Writer
@@ -51,6 +54,7 @@ func (b *PipeBuilder) SetCaptureOutput(capture bool) {
 	b.capture = capture
 }
 
+// GetCommand is part of Builder.
 func (b *PipeBuilder) GetCommand(p delivery.Properties, d delivery.Info, body []byte) (*exec.Cmd, error) {
 
 	meta, err := json.Marshal(&struct {
@@ -86,3 +90,21 @@ func (b *PipeBuilder) GetCommand(p delivery.Properties, d delivery.Info, body []
 
 	return cmd, nil
 }
+
+// GetCommandWithResponse is part of ResponseBuilder. The write end of the response pipe is passed as fd4 and must be
+// closed by the caller once the command has been started.
+func (b *PipeBuilder) GetCommandWithResponse(p delivery.Properties, d delivery.Info, body []byte) (*exec.Cmd, io.ReadCloser, error) {
+	cmd, err := b.GetCommand(p, d, body)
+	if err != nil || !b.Response {
+		return cmd, nil, err
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create response pipe: %v", err)
+	}
+
+	cmd.ExtraFiles = append(cmd.ExtraFiles, w)
+
+	return cmd, r, nil
+}
@@ -1,12 +1,14 @@
 package command_test
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"strings"
 	"testing"
 
 	"github.com/corvus-ch/rabbitmq-cli-consumer/command"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -48,3 +50,25 @@ func TestPipeBuilder_GetCommand(t *testing.T) {
 		})
 	}
 }
+
+func TestPipeBuilder_GetCommandWithResponse(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("response %v", enabled), func(t *testing.T) {
+			b, _, _ := createAndAssertBuilder(t, &command.PipeBuilder{Response: enabled}, "default", false)
+			cmd, r, err := b.(command.ResponseBuilder).GetCommandWithResponse(delivery.Properties{}, delivery.Info{}, []byte("body"))
+			assert.Nil(t, err)
+			if !enabled {
+				assert.Nil(t, r)
+				assert.Len(t, cmd.ExtraFiles, 1)
+				return
+			}
+
+			assert.Len(t, cmd.ExtraFiles, 2)
+			cmd.ExtraFiles[1].Write([]byte(`{"action":"ack"}`))
+			cmd.ExtraFiles[1].Close()
+			response, _ := ioutil.ReadAll(r)
+			assert.Equal(t, `{"action":"ack"}`, string(response))
+			r.Close()
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const batchConfig = `[batch]
+size = 20
+
+[consumer "resize"]
+queue = resize
+executable = /usr/bin/resize
+batchsize = 50
+batchtimeout = 250ms
+
+[consumer "mail"]
+queue = mail
+executable = /usr/bin/mail
+`
+
+var batchTests = []struct {
+	name     string
+	config   string
+	consumer string
+	size     int
+	timeout  time.Duration
+}{
+	{"default", "", "", 0, time.Second},
+	{"configured", batchConfig, "", 20, time.Second},
+	{"consumer", batchConfig, "resize", 50, 250 * time.Millisecond},
+	{"inherited", batchConfig, "mail", 20, time.Second},
+}
+
+func TestConfig_Batch(t *testing.T) {
+	for _, test := range batchTests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := loadConsumer(t, test.config, test.consumer)
+			assert.Equal(t, test.size, cfg.BatchSize())
+			assert.Equal(t, test.timeout, cfg.BatchTimeout())
+		})
+	}
+}
@@ -5,20 +5,26 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/corvus-ch/rabbitmq-cli-consumer/backoff"
 	"gopkg.in/gcfg.v1"
 )
 
 type Config struct {
 	RabbitMq struct {
-		AmqpUrl      string
+		AmqpUrl      []string
+		UrlOrder     string
 		Host         string
 		Username     string
 		Password     string
 		Port         string
 		Vhost        string
 		Queue        string
+		Concurrency  int
 		Compression  bool
+		Response     bool
 		Onfailure    int
 		Stricfailure bool
 	}
@@ -44,17 +50,144 @@ type Config struct {
 		Type       string
 		Durable    bool
 	}
+	TLS struct {
+		Enabled            bool
+		CAFile             string
+		CertFile           string
+		KeyFile            string
+		ServerName         string
+		InsecureSkipVerify bool
+		External           bool
+	}
+	Acknowledgement struct {
+		BatchSize     int
+		BatchInterval Duration
+	}
+	Batch struct {
+		Size    int
+		Timeout Duration
+	}
+	Worker struct {
+		Enabled     bool
+		MaxMessages int
+		MaxMemory   int
+		CrashAction string
+	}
+	Webhook struct {
+		Url                string
+		Envelope           bool
+		Timeout            Duration
+		StatusMap          []string
+		CAFile             string
+		CertFile           string
+		KeyFile            string
+		ServerName         string
+		InsecureSkipVerify bool
+	}
+	FastCGI struct {
+		Address   string
+		Script    string
+		Timeout   Duration
+		StatusMap []string
+	}
+	Socket struct {
+		Path                     string
+		Timeout                  Duration
+		ReconnectAttempts        int
+		ReconnectInitialInterval Duration
+		ReconnectMaxInterval     Duration
+	}
+	Gateway struct {
+		Address           string
+		VisibilityTimeout Duration
+	}
+	Rpc struct {
+		Enabled     bool
+		ContentType string
+	}
+	Publish struct {
+		Source string
+	}
+	ExitCodes struct {
+		Map     []string
+		Default string
+	}
+	Retry struct {
+		Delay      []Duration
+		ParkingLot string
+	}
+	Republish struct {
+		Exchange   string
+		RoutingKey string
+	}
+	Poison struct {
+		Threshold   int
+		Exchange    string
+		RoutingKey  string
+		TrackerSize int
+	}
+	LocalRetry struct {
+		Attempts        int
+		InitialInterval Duration
+		MaxInterval     Duration
+		Multiplier      float64
+		Jitter          float64
+		ExitCode        []string
+	}
+	Timeout struct {
+		Execution Duration
+		Grace     Duration
+		Action    string
+	}
+	Shutdown struct {
+		Timeout Duration
+		Drain   bool
+	}
+	Reconnect struct {
+		Attempts        int
+		InitialInterval Duration
+		MaxInterval     Duration
+		Multiplier      float64
+		Jitter          float64
+	}
 	Logs struct {
 		Error      string
 		Info       string
 		NoDateTime bool
 		Verbose    bool
 	}
+	Consumer         map[string]*ConsumerConfig
+	Default_Consumer ConsumerConfig
+
+	name string
 }
 
+const (
+	// UrlOrderSequential makes the consumer try the broker URLs in the configured order.
+	UrlOrderSequential = "sequential"
+	// UrlOrderRandom makes the consumer try the broker URLs in a random order.
+	UrlOrderRandom = "random"
+)
+
+// AmqpUrl returns the first of the broker URLs.
 func (c *Config) AmqpUrl() string {
-	if len(c.RabbitMq.AmqpUrl) > 0 {
-		return c.RabbitMq.AmqpUrl
+	return c.AmqpUrls()[0]
+}
+
+// AmqpUrls returns the URLs of the broker nodes to connect with. Each configured value may hold several comma
+// separated URLs. Without any URL configured, the URL is built from host, port, vhost and credentials.
+func (c Config) AmqpUrls() []string {
+	var urls []string
+	for _, v := range c.RabbitMq.AmqpUrl {
+		for _, u := range strings.Split(v, ",") {
+			if u = strings.TrimSpace(u); u != "" {
+				urls = append(urls, u)
+			}
+		}
+	}
+
+	if len(urls) > 0 {
+		return urls
 	}
 
 	host := c.RabbitMq.Host
@@ -62,8 +195,13 @@ func (c *Config) AmqpUrl() string {
 		host = fmt.Sprintf("%s:%s", host, c.RabbitMq.Port)
 	}
 
+	scheme := "amqp"
+	if c.TLS.Enabled {
+		scheme = "amqps"
+	}
+
 	uri := url.URL{
-		Scheme: "amqp",
+		Scheme: scheme,
 		Host:   host,
 		Path:   c.RabbitMq.Vhost,
 	}
@@ -76,9 +214,12 @@ func (c *Config) AmqpUrl() string {
 		}
 	}
 
-	c.RabbitMq.AmqpUrl = uri.String()
+	return []string{uri.String()}
+}
 
-	return c.RabbitMq.AmqpUrl
+// ShuffleAmqpUrls checks if the broker URLs are to be tried in random order.
+func (c Config) ShuffleAmqpUrls() bool {
+	return c.RabbitMq.UrlOrder == UrlOrderRandom
 }
 
 // QueueName returns the name of toe queue to bind with.
@@ -86,6 +227,21 @@ func (c Config) QueueName() string {
 	return c.RabbitMq.Queue
 }
 
+// Concurrency returns the number of messages to be processed in parallel. With a gateway, each message waiting to be
+// leased or settled takes up one, so the concurrency is the prefetch count, making all prefetched messages available to
+// be leased.
+func (c Config) Concurrency() int {
+	if c.GatewayAddress() != "" {
+		return c.PrefetchCount()
+	}
+
+	if c.RabbitMq.Concurrency < 1 {
+		return 1
+	}
+
+	return c.RabbitMq.Concurrency
+}
+
 // MustDeclareQueue return if the consumer should declare the queue or if the queue is expected to be already declared.
 func (c Config) MustDeclareQueue() bool {
 	return !c.QueueSettings.Nodeclare
@@ -137,6 +293,158 @@ func (c Config) PrefetchIsGlobal() bool {
 	return c.Prefetch.Global
 }
 
+// AckBatchSize returns the number of positive acknowledgements to be coalesced into one. Values smaller than two
+// disable batching.
+func (c Config) AckBatchSize() int {
+	return c.Acknowledgement.BatchSize
+}
+
+// AckBatchInterval returns the maximum time acknowledgements are held back when batching is enabled.
+func (c Config) AckBatchInterval() time.Duration {
+	// Defaults to one second.
+	if c.Acknowledgement.BatchInterval == 0 {
+		return time.Second
+	}
+
+	return time.Duration(c.Acknowledgement.BatchInterval)
+}
+
+// BatchSize returns the maximum number of messages passed to the executable at once. Values smaller than two disable
+// batch processing.
+func (c Config) BatchSize() int {
+	return c.Batch.Size
+}
+
+// BatchTimeout returns the maximum time waited for a batch to be completed.
+func (c Config) BatchTimeout() time.Duration {
+	// Defaults to one second.
+	if c.Batch.Timeout == 0 {
+		return time.Second
+	}
+
+	return time.Duration(c.Batch.Timeout)
+}
+
+// WorkerMode checks if messages are passed to a pool of long-lived workers instead of running the executable for each
+// message.
+func (c Config) WorkerMode() bool {
+	return c.Worker.Enabled
+}
+
+// WorkerMaxMessages returns the number of messages after which a worker gets recycled. Zero disables the limit.
+func (c Config) WorkerMaxMessages() int {
+	return c.Worker.MaxMessages
+}
+
+// WorkerMaxMemory returns the memory usage in bytes above which a worker gets recycled. The limit is configured in
+// megabytes. Zero disables the limit.
+func (c Config) WorkerMaxMemory() int64 {
+	return int64(c.Worker.MaxMemory) * 1024 * 1024
+}
+
+// WorkerCrashAction returns the name of the action used to acknowledge a message whose worker crashed.
+func (c Config) WorkerCrashAction() string {
+	// Defaults to requeue.
+	if c.Worker.CrashAction == "" {
+		return "requeue"
+	}
+
+	return c.Worker.CrashAction
+}
+
+// WebhookURL returns the URL messages are posted to instead of running the executable. An empty string disables the
+// webhook.
+func (c Config) WebhookURL() string {
+	return c.Webhook.Url
+}
+
+// WebhookEnvelope checks if messages are posted as JSON envelope holding properties, delivery info and body, instead
+// of passing the properties and delivery info as headers.
+func (c Config) WebhookEnvelope() bool {
+	return c.Webhook.Envelope
+}
+
+// WebhookTimeout returns the time a request to the webhook may take.
+func (c Config) WebhookTimeout() time.Duration {
+	return c.backendTimeout(c.Webhook.Timeout)
+}
+
+// backendTimeout returns the timeout of a backend replacing the executable. Unless set, it defaults to the execution
+// timeout or, if not set either, to 30 seconds.
+func (c Config) backendTimeout(d Duration) time.Duration {
+	if d > 0 {
+		return time.Duration(d)
+	}
+
+	if c.Timeout.Execution > 0 {
+		return time.Duration(c.Timeout.Execution)
+	}
+
+	return 30 * time.Second
+}
+
+// WebhookStatusMap returns the rules mapping HTTP status codes to actions. They take precedence over the default
+// rules.
+func (c Config) WebhookStatusMap() []string {
+	return c.Webhook.StatusMap
+}
+
+// FastCGIAddress returns the address of the FastCGI responder messages are passed to instead of running the
+// executable, either a TCP address or the path of a unix domain socket. An empty string disables FastCGI.
+func (c Config) FastCGIAddress() string {
+	return c.FastCGI.Address
+}
+
+// FastCGIScript returns the path of the script the FastCGI responder runs for each message.
+func (c Config) FastCGIScript() string {
+	return c.FastCGI.Script
+}
+
+// FastCGITimeout returns the time a request to the FastCGI responder may take.
+func (c Config) FastCGITimeout() time.Duration {
+	return c.backendTimeout(c.FastCGI.Timeout)
+}
+
+// FastCGIStatusMap returns the rules mapping the HTTP status codes of the FastCGI responses to actions. They take
+// precedence over the default rules.
+func (c Config) FastCGIStatusMap() []string {
+	return c.FastCGI.StatusMap
+}
+
+// SocketPath returns the path of the unix domain socket of the daemon messages are passed to instead of running the
+// executable. An empty string disables the socket.
+func (c Config) SocketPath() string {
+	return c.Socket.Path
+}
+
+// GatewayAddress returns the address of the HTTP gateway handing the messages out to clients instead of running the
+// executable. An empty string disables the gateway.
+func (c Config) GatewayAddress() string {
+	return c.Gateway.Address
+}
+
+// GatewayVisibilityTimeout returns the time a client may take to settle a message leased from the gateway.
+func (c Config) GatewayVisibilityTimeout() time.Duration {
+	return c.backendTimeout(c.Gateway.VisibilityTimeout)
+}
+
+// SocketTimeout returns the time the daemon may take to reply to a message.
+func (c Config) SocketTimeout() time.Duration {
+	return c.backendTimeout(c.Socket.Timeout)
+}
+
+// SocketReconnectPolicy returns the backoff policy used to connect to the daemon once a connection is needed but
+// connecting fails, e.g. while the daemon restarts.
+func (c Config) SocketReconnectPolicy() backoff.Policy {
+	return backoff.Policy{
+		Attempts:   c.Socket.ReconnectAttempts,
+		Initial:    time.Duration(c.Socket.ReconnectInitialInterval),
+		Max:        time.Duration(c.Socket.ReconnectMaxInterval),
+		Multiplier: 2,
+		Jitter:     0.2,
+	}
+}
+
 // HasMessageTTL checks if a message TTL is configured.
 func (c Config) HasMessageTTL() bool {
 	return c.QueueSettings.MessageTTL > 0
@@ -186,6 +494,176 @@ func (c Config) Priority() int32 {
 	return int32(c.QueueSettings.Priority)
 }
 
+// ReconnectPolicy returns the backoff policy used to reestablish a lost connection or channel.
+func (c Config) ReconnectPolicy() backoff.Policy {
+	return backoff.Policy{
+		Attempts:   c.Reconnect.Attempts,
+		Initial:    time.Duration(c.Reconnect.InitialInterval),
+		Max:        time.Duration(c.Reconnect.MaxInterval),
+		Multiplier: c.Reconnect.Multiplier,
+		Jitter:     c.Reconnect.Jitter,
+	}
+}
+
+// ShutdownTimeout returns the time to wait for running processes on shutdown before they get terminated. Zero waits
+// forever.
+func (c Config) ShutdownTimeout() time.Duration {
+	return time.Duration(c.Shutdown.Timeout)
+}
+
+// DrainOnShutdown checks if the messages already received are processed on shutdown instead of being requeued.
+func (c Config) DrainOnShutdown() bool {
+	return c.Shutdown.Drain
+}
+
+// IsRpc checks if the output of the executable is published as reply to the request.
+func (c Config) IsRpc() bool {
+	return c.Rpc.Enabled
+}
+
+// ReplyContentType returns the content type of the replies published in RPC mode.
+func (c Config) ReplyContentType() string {
+	if c.Rpc.ContentType == "" {
+		return "text/plain"
+	}
+
+	return c.Rpc.ContentType
+}
+
+// PublishSource returns from where the messages written by the executable are read to be published. An empty value
+// disables publishing.
+func (c Config) PublishSource() string {
+	return c.Publish.Source
+}
+
+// HasExitCodeMapping checks if exit codes are mapped to actions.
+func (c Config) HasExitCodeMapping() bool {
+	return len(c.ExitCodes.Map) > 0 || c.ExitCodes.Default != ""
+}
+
+// HasRetry checks if failed messages are retried using retry queues.
+func (c Config) HasRetry() bool {
+	return len(c.Retry.Delay) > 0
+}
+
+// RetryDelays returns the delays of the retry queues, one per attempt.
+func (c Config) RetryDelays() []time.Duration {
+	delays := make([]time.Duration, len(c.Retry.Delay))
+	for i, d := range c.Retry.Delay {
+		delays[i] = time.Duration(d)
+	}
+
+	return delays
+}
+
+// RetryQueues returns the names of the retry queues, one per attempt. The name is derived from the queue name and the
+// delay, e.g. "mail.retry.10s".
+func (c Config) RetryQueues() []string {
+	queues := make([]string, len(c.Retry.Delay))
+	for i, d := range c.Retry.Delay {
+		queues[i] = fmt.Sprintf("%s.retry.%s", c.QueueName(), formatDelay(time.Duration(d)))
+	}
+
+	return queues
+}
+
+// ParkingLotQueue returns the name of the queue receiving messages which failed on their last attempt. Defaults to the
+// queue name suffixed with ".parking-lot".
+func (c Config) ParkingLotQueue() string {
+	if c.Retry.ParkingLot == "" {
+		return c.QueueName() + ".parking-lot"
+	}
+
+	return c.Retry.ParkingLot
+}
+
+// formatDelay formats a delay using its largest unit without remainder, e.g. "90s" or "10m".
+func formatDelay(d time.Duration) string {
+	switch {
+	case d == 0:
+		return "0s"
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	case d%time.Second == 0:
+		return fmt.Sprintf("%ds", d/time.Second)
+	default:
+		return fmt.Sprintf("%dms", d/time.Millisecond)
+	}
+}
+
+// LocalRetryPolicy returns the backoff policy used to run the executable again for a failed message. Its number of
+// attempts excludes the first run.
+func (c Config) LocalRetryPolicy() backoff.Policy {
+	attempts := c.LocalRetry.Attempts - 1
+	if attempts < 0 {
+		attempts = 0
+	}
+
+	return backoff.Policy{
+		Attempts:   attempts,
+		Initial:    time.Duration(c.LocalRetry.InitialInterval),
+		Max:        time.Duration(c.LocalRetry.MaxInterval),
+		Multiplier: c.LocalRetry.Multiplier,
+		Jitter:     c.LocalRetry.Jitter,
+	}
+}
+
+// LocalRetryExitCodes returns the exit codes and ranges of exit codes for which the executable is run again. Empty
+// means all but zero.
+func (c Config) LocalRetryExitCodes() []string {
+	return c.LocalRetry.ExitCode
+}
+
+// RepublishExchange returns the exchange failed messages are republished to by the republish action.
+func (c Config) RepublishExchange() string {
+	return c.Republish.Exchange
+}
+
+// RepublishRoutingKey returns the routing key used by the republish action. Empty means the routing key of the
+// message.
+func (c Config) RepublishRoutingKey() string {
+	return c.Republish.RoutingKey
+}
+
+// PoisonThreshold returns the number of deliveries after which a message is considered poison. Zero disables the
+// detection of poison messages.
+func (c Config) PoisonThreshold() int {
+	return c.Poison.Threshold
+}
+
+// PoisonExchange returns the exchange poison messages are diverted to.
+func (c Config) PoisonExchange() string {
+	return c.Poison.Exchange
+}
+
+// PoisonRoutingKey returns the routing key used to divert poison messages. Without exchange and routing key, poison
+// messages are rejected instead.
+func (c Config) PoisonRoutingKey() string {
+	return c.Poison.RoutingKey
+}
+
+// PoisonTrackerSize returns the number of messages whose deliveries are tracked locally. Zero disables local tracking.
+func (c Config) PoisonTrackerSize() int {
+	return c.Poison.TrackerSize
+}
+
+// ExecutionTimeout returns the time a process may run before it gets terminated. Zero disables the timeout.
+func (c Config) ExecutionTimeout() time.Duration {
+	return time.Duration(c.Timeout.Execution)
+}
+
+// TimeoutGrace returns the time a process has to exit after SIGTERM was sent, before it gets killed.
+func (c Config) TimeoutGrace() time.Duration {
+	return time.Duration(c.Timeout.Grace)
+}
+
+// TimeoutAction returns the name of the action used to acknowledge a message whose process timed out.
+func (c Config) TimeoutAction() string {
+	return c.Timeout.Action
+}
+
 // IsVerbose checks if verbose logging is enabled.
 func (c Config) IsVerbose() bool {
 	return c.Logs.Verbose
@@ -227,9 +705,73 @@ func (c Config) ConsumerTag() string {
 	if err != nil {
 		host = "unknown"
 	}
+	if c.name != "" {
+		return fmt.Sprintf("ctag-%s-%d-%s@%s", os.Args[0], os.Getpid(), c.name, host)
+	}
+
 	return fmt.Sprintf("ctag-%s-%d@%s", os.Args[0], os.Getpid(), host)
 }
 
+// batchMode is the processing mode of batches.
+const batchMode = "in batch mode"
+
+// Validate checks for settings which can not be combined. Local retries, republishing, RPC and publishing rely on the
+// executable being run for each message and are refused if messages are processed otherwise. Batch processing is
+// refused unless the messages are passed to the executable.
+func (c Config) Validate() error {
+	mode := c.processingMode()
+	if mode == "" {
+		return nil
+	}
+
+	var unsupported []string
+	if c.LocalRetryPolicy().Attempts > 0 {
+		unsupported = append(unsupported, "local retries")
+	}
+	if c.RepublishExchange() != "" || c.RepublishRoutingKey() != "" {
+		unsupported = append(unsupported, "republishing")
+	}
+	if c.IsRpc() {
+		unsupported = append(unsupported, "RPC")
+	}
+	if c.PublishSource() != "" {
+		unsupported = append(unsupported, "publishing")
+	}
+	if c.BatchSize() > 1 && mode != batchMode {
+		unsupported = append(unsupported, "batch processing")
+	}
+
+	switch n := len(unsupported); n {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("%s can not be used %s", unsupported[0], mode)
+	default:
+		return fmt.Errorf("%s and %s can not be used %s", strings.Join(unsupported[:n-1], ", "), unsupported[n-1], mode)
+	}
+}
+
+// processingMode describes how messages are processed, for use in error messages. It is empty if the executable is
+// run for each message.
+func (c Config) processingMode() string {
+	switch {
+	case c.WebhookURL() != "":
+		return "with a webhook"
+	case c.FastCGIAddress() != "":
+		return "with FastCGI"
+	case c.SocketPath() != "":
+		return "with a socket"
+	case c.GatewayAddress() != "":
+		return "with the gateway"
+	case c.WorkerMode():
+		return "in worker mode"
+	case c.BatchSize() > 1:
+		return batchMode
+	default:
+		return ""
+	}
+}
+
 // LoadAndParse creates a new instance of config by parsing the content of teh given file.
 func LoadAndParse(location string) (*Config, error) {
 	if !filepath.IsAbs(location) {
@@ -245,6 +787,12 @@ func LoadAndParse(location string) (*Config, error) {
 	cfg := &Config{}
 
 	SetDefaultQueueDurability(cfg)
+	SetDefaultReconnect(cfg)
+	SetDefaultTimeout(cfg)
+	SetDefaultLocalRetry(cfg)
+	SetDefaultPoison(cfg)
+	SetDefaultSocket(cfg)
+	SetDefaultConsumer(cfg)
 
 	if err := gcfg.ReadFileInto(cfg, location); err != nil {
 		return nil, err
@@ -257,6 +805,12 @@ func CreateFromString(data string) (*Config, error) {
 	cfg := &Config{}
 
 	SetDefaultQueueDurability(cfg)
+	SetDefaultReconnect(cfg)
+	SetDefaultTimeout(cfg)
+	SetDefaultLocalRetry(cfg)
+	SetDefaultPoison(cfg)
+	SetDefaultSocket(cfg)
+	SetDefaultConsumer(cfg)
 
 	if err := gcfg.ReadStringInto(cfg, data); err != nil {
 		return nil, err
@@ -270,6 +824,49 @@ func SetDefaultQueueDurability(cfg *Config) {
 	cfg.QueueSettings.Durable = true
 }
 
+// SetDefaultReconnect sets the reconnect backoff to retry forever, starting with one second, doubling up to one minute
+// with a jitter of 20%.
+func SetDefaultReconnect(cfg *Config) {
+	cfg.Reconnect.Attempts = -1
+	cfg.Reconnect.InitialInterval = Duration(time.Second)
+	cfg.Reconnect.MaxInterval = Duration(time.Minute)
+	cfg.Reconnect.Multiplier = 2
+	cfg.Reconnect.Jitter = 0.2
+}
+
+// SetDefaultTimeout sets the grace period to ten seconds and requeues messages whose process timed out. The timeout
+// itself stays disabled unless configured.
+func SetDefaultTimeout(cfg *Config) {
+	cfg.Timeout.Grace = Duration(10 * time.Second)
+	cfg.Timeout.Action = "requeue"
+}
+
+// SetDefaultLocalRetry sets the backoff in between two runs of the executable to start with 100ms, doubling up to ten
+// seconds with a jitter of 20%. Running the executable again stays disabled unless the number of attempts is
+// configured.
+func SetDefaultLocalRetry(cfg *Config) {
+	cfg.LocalRetry.Attempts = 1
+	cfg.LocalRetry.InitialInterval = Duration(100 * time.Millisecond)
+	cfg.LocalRetry.MaxInterval = Duration(10 * time.Second)
+	cfg.LocalRetry.Multiplier = 2
+	cfg.LocalRetry.Jitter = 0.2
+}
+
+// SetDefaultPoison sets the number of messages whose deliveries are tracked locally to 10000. The detection of poison
+// messages stays disabled unless the threshold is configured.
+func SetDefaultPoison(cfg *Config) {
+	cfg.Poison.TrackerSize = 10000
+}
+
+// SetDefaultSocket sets the backoff connecting to the daemon of the socket to ten attempts, starting with 100ms and
+// doubling up to five seconds with a jitter of 20%. This spans a restart of the daemon taking up to about half a
+// minute.
+func SetDefaultSocket(cfg *Config) {
+	cfg.Socket.ReconnectAttempts = 10
+	cfg.Socket.ReconnectInitialInterval = Duration(100 * time.Millisecond)
+	cfg.Socket.ReconnectMaxInterval = Duration(5 * time.Second)
+}
+
 func transformToStringValue(val string) string {
 	if val == "<empty>" {
 		return ""
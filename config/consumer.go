@@ -0,0 +1,215 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Builder modes of a named consumer.
+const (
+	ModeArgument = "argument"
+	ModeInclude  = "include"
+	ModePipe     = "pipe"
+	ModeWorker   = "worker"
+)
+
+// ConsumerConfig holds the settings of a named consumer, defined by a subsection like [consumer "mail"].
+type ConsumerConfig struct {
+	Queue                string
+	Executable           string
+	Webhook              string
+	FastCGI              string
+	FastCGIScript        string
+	Socket               string
+	Gateway              string
+	Mode                 string
+	Output               bool
+	Compression          bool
+	Response             bool
+	Rpc                  bool
+	RpcContentType       string
+	Publish              string
+	Onfailure            int
+	Stricfailure         bool
+	Concurrency          int
+	PrefetchCount        int
+	PrefetchGlobal       bool
+	BatchSize            int
+	BatchTimeout         Duration
+	Routingkey           []string
+	MessageTTL           int
+	DeadLetterExchange   string
+	DeadLetterRoutingKey string
+	Priority             int
+	Nodeclare            bool
+	Durable              bool
+	Exclusive            bool
+	AutoDelete           bool
+	NoWait               bool
+	ExchangeName         string
+	ExchangeType         string
+	ExchangeDurable      bool
+	ExchangeAutodelete   bool
+	Timeout              Duration
+	TimeoutAction        string
+	ExitCodeMap          []string
+	ExitCodeDefault      string
+	RetryDelay           []Duration
+	ParkingLot           string
+	LocalRetryAttempts   int
+	LocalRetryExitCode   []string
+	PoisonThreshold      int
+	PoisonExchange       string
+	PoisonRoutingKey     string
+	RepublishExchange    string
+	RepublishRoutingKey  string
+}
+
+// Validate checks the consumer settings for consistency.
+func (cc ConsumerConfig) Validate() error {
+	if cc.Queue == "" {
+		return fmt.Errorf("no queue configured")
+	}
+
+	if cc.Executable == "" && cc.Webhook == "" && cc.FastCGI == "" && cc.Socket == "" && cc.Gateway == "" {
+		return fmt.Errorf("no executable, webhook, FastCGI responder, socket or gateway configured")
+	}
+
+	switch cc.Mode {
+	case "", ModeArgument, ModeInclude, ModePipe, ModeWorker:
+		return nil
+
+	default:
+		return fmt.Errorf("unknown mode %q", cc.Mode)
+	}
+}
+
+// HasConsumers checks if named consumers are configured.
+func (c Config) HasConsumers() bool {
+	return len(c.Consumer) > 0
+}
+
+// ConsumerNames returns the names of all configured consumers in alphabetical order.
+func (c Config) ConsumerNames() []string {
+	names := make([]string, 0, len(c.Consumer))
+	for name := range c.Consumer {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// ForConsumer creates the configuration for the named consumer. Queue, exchange, prefetch and acknowledgement settings
+// are taken from the consumers subsection, all other settings are inherited.
+func (c Config) ForConsumer(name string) (*Config, error) {
+	cc, ok := c.Consumer[name]
+	if !ok {
+		return nil, fmt.Errorf("consumer %q is not configured", name)
+	}
+
+	if err := cc.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid consumer %q: %v", name, err)
+	}
+
+	cfg := c
+	cfg.name = name
+	cfg.Consumer = nil
+
+	cfg.RabbitMq.Queue = cc.Queue
+	cfg.RabbitMq.Compression = cc.Compression
+	cfg.RabbitMq.Response = cc.Response
+	cfg.Rpc.Enabled = cc.Rpc
+	if cc.RpcContentType != "" {
+		cfg.Rpc.ContentType = cc.RpcContentType
+	}
+	cfg.Publish.Source = cc.Publish
+	cfg.RabbitMq.Onfailure = cc.Onfailure
+	cfg.RabbitMq.Stricfailure = cc.Stricfailure
+	cfg.RabbitMq.Concurrency = cc.Concurrency
+	cfg.Worker.Enabled = cc.Mode == ModeWorker
+	cfg.Webhook.Url = cc.Webhook
+	cfg.FastCGI.Address = cc.FastCGI
+	cfg.Socket.Path = cc.Socket
+	cfg.Gateway.Address = cc.Gateway
+
+	cfg.Prefetch.Count = cc.PrefetchCount
+	cfg.Prefetch.Global = cc.PrefetchGlobal
+
+	cfg.QueueSettings.Routingkey = cc.Routingkey
+	cfg.QueueSettings.MessageTTL = cc.MessageTTL
+	cfg.QueueSettings.DeadLetterExchange = cc.DeadLetterExchange
+	cfg.QueueSettings.DeadLetterRoutingKey = cc.DeadLetterRoutingKey
+	cfg.QueueSettings.Priority = cc.Priority
+	cfg.QueueSettings.Nodeclare = cc.Nodeclare
+	cfg.QueueSettings.Durable = cc.Durable
+	cfg.QueueSettings.Exclusive = cc.Exclusive
+	cfg.QueueSettings.AutoDelete = cc.AutoDelete
+	cfg.QueueSettings.NoWait = cc.NoWait
+
+	cfg.Exchange.Name = cc.ExchangeName
+	cfg.Exchange.Type = cc.ExchangeType
+	cfg.Exchange.Durable = cc.ExchangeDurable
+	cfg.Exchange.Autodelete = cc.ExchangeAutodelete
+
+	// Unlike the other settings, the timeout, the exit code mapping, the retries and the batches are only overridden if
+	// set.
+	if cc.Timeout > 0 {
+		cfg.Timeout.Execution = cc.Timeout
+	}
+	if cc.TimeoutAction != "" {
+		cfg.Timeout.Action = cc.TimeoutAction
+	}
+	if len(cc.ExitCodeMap) > 0 || cc.ExitCodeDefault != "" {
+		cfg.ExitCodes.Map = cc.ExitCodeMap
+		cfg.ExitCodes.Default = cc.ExitCodeDefault
+	}
+	if len(cc.RetryDelay) > 0 {
+		cfg.Retry.Delay = cc.RetryDelay
+	}
+	if cc.ParkingLot != "" {
+		cfg.Retry.ParkingLot = cc.ParkingLot
+	}
+	if cc.LocalRetryAttempts > 0 {
+		cfg.LocalRetry.Attempts = cc.LocalRetryAttempts
+	}
+	if len(cc.LocalRetryExitCode) > 0 {
+		cfg.LocalRetry.ExitCode = cc.LocalRetryExitCode
+	}
+	if cc.PoisonThreshold > 0 {
+		cfg.Poison.Threshold = cc.PoisonThreshold
+	}
+	if cc.PoisonExchange != "" || cc.PoisonRoutingKey != "" {
+		cfg.Poison.Exchange = cc.PoisonExchange
+		cfg.Poison.RoutingKey = cc.PoisonRoutingKey
+	}
+	if cc.FastCGIScript != "" {
+		cfg.FastCGI.Script = cc.FastCGIScript
+	}
+	if cc.BatchSize > 0 {
+		cfg.Batch.Size = cc.BatchSize
+	}
+	if cc.BatchTimeout > 0 {
+		cfg.Batch.Timeout = cc.BatchTimeout
+	}
+	if cc.RepublishExchange != "" || cc.RepublishRoutingKey != "" {
+		cfg.Republish.Exchange = cc.RepublishExchange
+		cfg.Republish.RoutingKey = cc.RepublishRoutingKey
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid consumer %q: %v", name, err)
+	}
+
+	return &cfg, nil
+}
+
+// ConsumerName returns the name of the consumer or an empty string if the configuration is not for a named consumer.
+func (c Config) ConsumerName() string {
+	return c.name
+}
+
+// SetDefaultConsumer sets the defaults of named consumers to match the defaults of the main configuration.
+func SetDefaultConsumer(cfg *Config) {
+	cfg.Default_Consumer.Durable = true
+}
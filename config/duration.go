@@ -0,0 +1,19 @@
+package config
+
+import "time"
+
+// Duration is a time.Duration which can be parsed from the configuration file using the notation of
+// time.ParseDuration, e.g. "1m30s".
+type Duration time.Duration
+
+// UnmarshalText is part of encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+
+	return nil
+}
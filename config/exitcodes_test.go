@@ -0,0 +1,45 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const exitCodesConfig = `[exitcodes]
+map = 3:reject
+
+[consumer "report"]
+queue = report
+executable = /usr/bin/report
+exitcodedefault = fail
+
+[consumer "mail"]
+queue = mail
+executable = /usr/bin/mail
+`
+
+var exitCodesTests = []struct {
+	name       string
+	config     string
+	consumer   string
+	hasMapping bool
+	mapping    []string
+	fallback   string
+}{
+	{"default", "", "", false, nil, ""},
+	{"configured", exitCodesConfig, "", true, []string{"3:reject"}, ""},
+	{"consumer", exitCodesConfig, "report", true, nil, "fail"},
+	{"inherited", exitCodesConfig, "mail", true, []string{"3:reject"}, ""},
+}
+
+func TestConfig_ExitCodes(t *testing.T) {
+	for _, test := range exitCodesTests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := loadConsumer(t, test.config, test.consumer)
+			assert.Equal(t, test.hasMapping, cfg.HasExitCodeMapping())
+			assert.Equal(t, test.mapping, cfg.ExitCodes.Map)
+			assert.Equal(t, test.fallback, cfg.ExitCodes.Default)
+		})
+	}
+}
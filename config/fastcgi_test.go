@@ -0,0 +1,51 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const fastCGIConfig = `[fastcgi]
+address = /run/php/php-fpm.sock
+script = /var/www/consumer.php
+timeout = 5s
+statusmap = 409:ack
+
+[consumer "report"]
+queue = report
+fastcgi = 127.0.0.1:9000
+fastcgiscript = /var/www/report.php
+
+[consumer "mail"]
+queue = mail
+fastcgi = 127.0.0.1:9000
+`
+
+var fastCGITests = []struct {
+	name      string
+	config    string
+	consumer  string
+	address   string
+	script    string
+	timeout   time.Duration
+	statusMap []string
+}{
+	{"default", "", "", "", "", 30 * time.Second, nil},
+	{"configured", fastCGIConfig, "", "/run/php/php-fpm.sock", "/var/www/consumer.php", 5 * time.Second, []string{"409:ack"}},
+	{"consumer", fastCGIConfig, "report", "127.0.0.1:9000", "/var/www/report.php", 5 * time.Second, []string{"409:ack"}},
+	{"inherited", fastCGIConfig, "mail", "127.0.0.1:9000", "/var/www/consumer.php", 5 * time.Second, []string{"409:ack"}},
+}
+
+func TestConfig_FastCGI(t *testing.T) {
+	for _, test := range fastCGITests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := loadConsumer(t, test.config, test.consumer)
+			assert.Equal(t, test.address, cfg.FastCGIAddress())
+			assert.Equal(t, test.script, cfg.FastCGIScript())
+			assert.Equal(t, test.timeout, cfg.FastCGITimeout())
+			assert.Equal(t, test.statusMap, cfg.FastCGIStatusMap())
+		})
+	}
+}
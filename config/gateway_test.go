@@ -0,0 +1,54 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const gatewayConfig = `[rabbitmq]
+concurrency = 2
+
+[prefetch]
+count = 5
+
+[gateway]
+address = 127.0.0.1:8080
+visibilitytimeout = 2m
+
+[consumer "report"]
+queue = report
+gateway = 127.0.0.1:8081
+concurrency = 2
+prefetchcount = 4
+
+[consumer "mail"]
+queue = mail
+executable = /usr/bin/mail
+`
+
+var gatewayTests = []struct {
+	name        string
+	config      string
+	consumer    string
+	address     string
+	visibility  time.Duration
+	concurrency int
+}{
+	{"default", "", "", "", 30 * time.Second, 1},
+	{"configured", gatewayConfig, "", "127.0.0.1:8080", 2 * time.Minute, 5},
+	{"consumer", gatewayConfig, "report", "127.0.0.1:8081", 2 * time.Minute, 4},
+	{"executable", gatewayConfig, "mail", "", 2 * time.Minute, 1},
+}
+
+func TestConfig_Gateway(t *testing.T) {
+	for _, test := range gatewayTests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := loadConsumer(t, test.config, test.consumer)
+			assert.Equal(t, test.address, cfg.GatewayAddress())
+			assert.Equal(t, test.visibility, cfg.GatewayVisibilityTimeout())
+			assert.Equal(t, test.concurrency, cfg.Concurrency())
+		})
+	}
+}
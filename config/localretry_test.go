@@ -0,0 +1,55 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/backoff"
+	"github.com/stretchr/testify/assert"
+)
+
+const localRetryConfig = `[localretry]
+attempts = 3
+exitcode = 75
+exitcode = signal
+
+[consumer "resize"]
+queue = resize
+executable = /usr/bin/resize
+localretryattempts = 5
+
+[consumer "mail"]
+queue = mail
+executable = /usr/bin/mail
+`
+
+var localRetryTests = []struct {
+	name      string
+	config    string
+	consumer  string
+	attempts  int
+	exitCodes []string
+}{
+	{"default", "", "", 0, nil},
+	{"configured", localRetryConfig, "", 2, []string{"75", "signal"}},
+	{"consumer", localRetryConfig, "resize", 4, []string{"75", "signal"}},
+	{"inherited", localRetryConfig, "mail", 2, []string{"75", "signal"}},
+	{"single attempt", "[localretry]\nattempts = 1", "", 0, nil},
+	{"disabled", "[localretry]\nattempts = 0", "", 0, nil},
+}
+
+func TestConfig_LocalRetry(t *testing.T) {
+	for _, test := range localRetryTests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := loadConsumer(t, test.config, test.consumer)
+			assert.Equal(t, backoff.Policy{
+				Attempts:   test.attempts,
+				Initial:    100 * time.Millisecond,
+				Max:        10 * time.Second,
+				Multiplier: 2,
+				Jitter:     0.2,
+			}, cfg.LocalRetryPolicy())
+			assert.Equal(t, test.exitCodes, cfg.LocalRetryExitCodes())
+		})
+	}
+}
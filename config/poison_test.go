@@ -0,0 +1,44 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const poisonConfig = `[poison]
+threshold = 5
+routingkey = poison
+
+[consumer "resize"]
+queue = resize
+executable = /usr/bin/resize
+poisonthreshold = 3
+poisonexchange = quarantine
+`
+
+var poisonTests = []struct {
+	name        string
+	config      string
+	consumer    string
+	threshold   int
+	exchange    string
+	routingKey  string
+	trackerSize int
+}{
+	{"default", "", "", 0, "", "", 10000},
+	{"configured", poisonConfig, "", 5, "", "poison", 10000},
+	{"consumer", poisonConfig, "resize", 3, "quarantine", "", 10000},
+}
+
+func TestConfig_Poison(t *testing.T) {
+	for _, test := range poisonTests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := loadConsumer(t, test.config, test.consumer)
+			assert.Equal(t, test.threshold, cfg.PoisonThreshold())
+			assert.Equal(t, test.exchange, cfg.PoisonExchange())
+			assert.Equal(t, test.routingKey, cfg.PoisonRoutingKey())
+			assert.Equal(t, test.trackerSize, cfg.PoisonTrackerSize())
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const publishConfig = `[publish]
+source = stdout
+
+[consumer "resize"]
+queue = resize
+executable = /usr/bin/resize
+publish = fd
+
+[consumer "mail"]
+queue = mail
+executable = /usr/bin/mail
+`
+
+var publishSourceTests = []struct {
+	name     string
+	config   string
+	consumer string
+	source   string
+}{
+	{"default", "", "", ""},
+	{"configured", publishConfig, "", "stdout"},
+	{"consumer", publishConfig, "resize", "fd"},
+	{"disabled", publishConfig, "mail", ""},
+}
+
+func TestConfig_PublishSource(t *testing.T) {
+	for _, test := range publishSourceTests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.source, loadConsumer(t, test.config, test.consumer).PublishSource())
+		})
+	}
+}
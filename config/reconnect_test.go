@@ -0,0 +1,48 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/backoff"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var reconnectTests = []struct {
+	name   string
+	config string
+	policy backoff.Policy
+}{
+	{
+		"default",
+		"",
+		backoff.Policy{Attempts: -1, Initial: time.Second, Max: time.Minute, Multiplier: 2, Jitter: 0.2},
+	},
+	{
+		"configured",
+		`[reconnect]
+attempts = 5
+initialinterval = 500ms
+maxinterval = 2m
+multiplier = 1.5
+jitter = 0`,
+		backoff.Policy{Attempts: 5, Initial: 500 * time.Millisecond, Max: 2 * time.Minute, Multiplier: 1.5, Jitter: 0},
+	},
+}
+
+func TestConfig_ReconnectPolicy(t *testing.T) {
+	for _, test := range reconnectTests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := config.CreateFromString(test.config)
+			if err != nil {
+				t.Fatalf("failed to load config: %v", err)
+			}
+			assert.Equal(t, test.policy, cfg.ReconnectPolicy())
+		})
+	}
+	t.Run("invalid duration", func(t *testing.T) {
+		_, err := config.CreateFromString("[reconnect]\ninitialinterval = soon")
+		assert.Error(t, err)
+	})
+}
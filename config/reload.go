@@ -0,0 +1,99 @@
+package config
+
+import "reflect"
+
+// RequiresResubscribe checks if replacing this configuration by n requires the consumers to resubscribe using a new
+// connection. The prefetch settings, the log files, the executable, the way it is run and how its outcome is handled
+// can be changed in place, unless this changes the concurrency of a consumer, like with a gateway. Any other change
+// requires to resubscribe.
+func (c Config) RequiresResubscribe(n *Config) bool {
+	o := c
+	o.takeInPlace(n)
+
+	return !reflect.DeepEqual(&o, n) || c.changesConcurrency(n)
+}
+
+// changesConcurrency checks if replacing this configuration by n changes the concurrency of any consumer.
+func (c Config) changesConcurrency(n *Config) bool {
+	if c.Concurrency() != n.Concurrency() {
+		return true
+	}
+
+	for name := range c.Consumer {
+		oc, oerr := c.ForConsumer(name)
+		nc, nerr := n.ForConsumer(name)
+		if oerr == nil && nerr == nil && oc.Concurrency() != nc.Concurrency() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// takeInPlace copies the settings that can be changed in place from n.
+func (c *Config) takeInPlace(n *Config) {
+	c.RabbitMq.Compression = n.RabbitMq.Compression
+	c.RabbitMq.Response = n.RabbitMq.Response
+	c.RabbitMq.Onfailure = n.RabbitMq.Onfailure
+	c.RabbitMq.Stricfailure = n.RabbitMq.Stricfailure
+	c.Prefetch = n.Prefetch
+	c.Rpc = n.Rpc
+	c.Publish = n.Publish
+	c.ExitCodes = n.ExitCodes
+	c.Republish = n.Republish
+	c.Poison = n.Poison
+	c.LocalRetry = n.LocalRetry
+	c.Timeout = n.Timeout
+	c.Worker = n.Worker
+	c.Webhook = n.Webhook
+	c.FastCGI = n.FastCGI
+	c.Socket = n.Socket
+	c.Gateway = n.Gateway
+	c.Logs = n.Logs
+
+	if c.Consumer == nil {
+		return
+	}
+
+	consumers := make(map[string]*ConsumerConfig, len(c.Consumer))
+	for name, cc := range c.Consumer {
+		o := *cc
+		if nc, ok := n.Consumer[name]; ok {
+			o.takeInPlace(nc)
+		}
+		consumers[name] = &o
+	}
+	c.Consumer = consumers
+}
+
+// takeInPlace copies the settings that can be changed in place from n.
+func (cc *ConsumerConfig) takeInPlace(n *ConsumerConfig) {
+	cc.Executable = n.Executable
+	cc.Webhook = n.Webhook
+	cc.FastCGI = n.FastCGI
+	cc.FastCGIScript = n.FastCGIScript
+	cc.Socket = n.Socket
+	cc.Gateway = n.Gateway
+	cc.Mode = n.Mode
+	cc.Output = n.Output
+	cc.Compression = n.Compression
+	cc.Response = n.Response
+	cc.Rpc = n.Rpc
+	cc.RpcContentType = n.RpcContentType
+	cc.Publish = n.Publish
+	cc.Onfailure = n.Onfailure
+	cc.Stricfailure = n.Stricfailure
+	cc.PrefetchCount = n.PrefetchCount
+	cc.PrefetchGlobal = n.PrefetchGlobal
+	cc.Timeout = n.Timeout
+	cc.TimeoutAction = n.TimeoutAction
+	cc.ExitCodeMap = n.ExitCodeMap
+	cc.ExitCodeDefault = n.ExitCodeDefault
+	cc.LocalRetryAttempts = n.LocalRetryAttempts
+	cc.LocalRetryExitCode = n.LocalRetryExitCode
+	cc.PoisonThreshold = n.PoisonThreshold
+	cc.PoisonExchange = n.PoisonExchange
+	cc.PoisonRoutingKey = n.PoisonRoutingKey
+	cc.RepublishExchange = n.RepublishExchange
+	cc.RepublishRoutingKey = n.RepublishRoutingKey
+}
@@ -0,0 +1,38 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const republishConfig = `[republish]
+exchange = failed
+
+[consumer "resize"]
+queue = resize
+executable = /usr/bin/resize
+republishroutingkey = resize.failed
+`
+
+var republishTests = []struct {
+	name       string
+	config     string
+	consumer   string
+	exchange   string
+	routingKey string
+}{
+	{"default", "", "", "", ""},
+	{"configured", republishConfig, "", "failed", ""},
+	{"consumer", republishConfig, "resize", "", "resize.failed"},
+}
+
+func TestConfig_Republish(t *testing.T) {
+	for _, test := range republishTests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := loadConsumer(t, test.config, test.consumer)
+			assert.Equal(t, test.exchange, cfg.RepublishExchange())
+			assert.Equal(t, test.routingKey, cfg.RepublishRoutingKey())
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const retryConfig = `[rabbitmq]
+queue = mail
+
+[retry]
+delay = 10s
+delay = 1m30s
+
+[consumer "resize"]
+queue = resize
+executable = /usr/bin/resize
+retrydelay = 1h
+parkinglot = parked
+`
+
+var retryTests = []struct {
+	name       string
+	config     string
+	consumer   string
+	retry      bool
+	delays     []time.Duration
+	queues     []string
+	parkingLot string
+}{
+	{"default", "[rabbitmq]\nqueue = mail", "", false, []time.Duration{}, []string{}, "mail.parking-lot"},
+	{"configured", retryConfig, "", true, []time.Duration{10 * time.Second, 90 * time.Second}, []string{"mail.retry.10s", "mail.retry.90s"}, "mail.parking-lot"},
+	{"consumer", retryConfig, "resize", true, []time.Duration{time.Hour}, []string{"resize.retry.1h"}, "parked"},
+}
+
+func TestConfig_Retry(t *testing.T) {
+	for _, test := range retryTests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := loadConsumer(t, test.config, test.consumer)
+			assert.Equal(t, test.retry, cfg.HasRetry())
+			assert.Equal(t, test.delays, cfg.RetryDelays())
+			assert.Equal(t, test.queues, cfg.RetryQueues())
+			assert.Equal(t, test.parkingLot, cfg.ParkingLotQueue())
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const rpcConfig = `[rpc]
+enabled = On
+
+[consumer "quote"]
+queue = quote
+executable = /usr/bin/quote
+rpc = On
+rpccontenttype = application/json
+
+[consumer "mail"]
+queue = mail
+executable = /usr/bin/mail
+`
+
+var rpcTests = []struct {
+	name        string
+	config      string
+	consumer    string
+	rpc         bool
+	contentType string
+}{
+	{"default", "", "", false, "text/plain"},
+	{"configured", rpcConfig, "", true, "text/plain"},
+	{"consumer", rpcConfig, "quote", true, "application/json"},
+	{"disabled", rpcConfig, "mail", false, "text/plain"},
+}
+
+func TestConfig_Rpc(t *testing.T) {
+	for _, test := range rpcTests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := loadConsumer(t, test.config, test.consumer)
+			assert.Equal(t, test.rpc, cfg.IsRpc())
+			assert.Equal(t, test.contentType, cfg.ReplyContentType())
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var shutdownTests = []struct {
+	name    string
+	config  string
+	timeout time.Duration
+	drain   bool
+}{
+	{"default", "", 0, false},
+	{
+		"configured",
+		`[shutdown]
+timeout = 1m
+drain = true`,
+		time.Minute,
+		true,
+	},
+}
+
+func TestConfig_Shutdown(t *testing.T) {
+	for _, test := range shutdownTests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := config.CreateFromString(test.config)
+			if err != nil {
+				t.Fatalf("failed to load config: %v", err)
+			}
+			assert.Equal(t, test.timeout, cfg.ShutdownTimeout())
+			assert.Equal(t, test.drain, cfg.DrainOnShutdown())
+		})
+	}
+}
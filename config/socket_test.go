@@ -0,0 +1,51 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/backoff"
+	"github.com/stretchr/testify/assert"
+)
+
+const socketConfig = `[socket]
+path = /run/consumer.sock
+timeout = 5s
+reconnectattempts = 3
+reconnectmaxinterval = 1s
+
+[consumer "report"]
+queue = report
+socket = /run/report.sock
+
+[consumer "mail"]
+queue = mail
+executable = /usr/bin/mail
+`
+
+var socketTests = []struct {
+	name     string
+	config   string
+	consumer string
+	path     string
+	timeout  time.Duration
+	attempts int
+	max      time.Duration
+}{
+	{"default", "", "", "", 30 * time.Second, 10, 5 * time.Second},
+	{"configured", socketConfig, "", "/run/consumer.sock", 5 * time.Second, 3, time.Second},
+	{"consumer", socketConfig, "report", "/run/report.sock", 5 * time.Second, 3, time.Second},
+	{"executable", socketConfig, "mail", "", 5 * time.Second, 3, time.Second},
+}
+
+func TestConfig_Socket(t *testing.T) {
+	for _, test := range socketTests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := loadConsumer(t, test.config, test.consumer)
+			assert.Equal(t, test.path, cfg.SocketPath())
+			assert.Equal(t, test.timeout, cfg.SocketTimeout())
+			policy := backoff.Policy{Attempts: test.attempts, Initial: 100 * time.Millisecond, Max: test.max, Multiplier: 2, Jitter: 0.2}
+			assert.Equal(t, policy, cfg.SocketReconnectPolicy())
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const timeoutConfig = `[timeout]
+execution = 30s
+grace = 5s
+action = reject
+
+[consumer "report"]
+queue = report
+executable = /usr/bin/report
+timeout = 10m
+timeoutaction = deadletter
+
+[consumer "mail"]
+queue = mail
+executable = /usr/bin/mail
+`
+
+var timeoutTests = []struct {
+	name      string
+	config    string
+	consumer  string
+	execution time.Duration
+	grace     time.Duration
+	action    string
+}{
+	{"default", "", "", 0, 10 * time.Second, "requeue"},
+	{"configured", timeoutConfig, "", 30 * time.Second, 5 * time.Second, "reject"},
+	{"consumer", timeoutConfig, "report", 10 * time.Minute, 5 * time.Second, "deadletter"},
+	{"inherited", timeoutConfig, "mail", 30 * time.Second, 5 * time.Second, "reject"},
+}
+
+func TestConfig_Timeout(t *testing.T) {
+	for _, test := range timeoutTests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := loadConsumer(t, test.config, test.consumer)
+			assert.Equal(t, test.execution, cfg.ExecutionTimeout())
+			assert.Equal(t, test.grace, cfg.TimeoutGrace())
+			assert.Equal(t, test.action, cfg.TimeoutAction())
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+)
+
+// TLSConfig creates the TLS configuration used to connect with the broker. If TLS is not enabled, nil is returned.
+func (c Config) TLSConfig() (*tls.Config, error) {
+	if !c.TLS.Enabled {
+		return nil, nil
+	}
+
+	// Broker URLs using the amqp scheme would connect in plain text despite TLS being enabled.
+	for _, u := range c.AmqpUrls() {
+		uri, err := url.Parse(u)
+		if err != nil {
+			return nil, fmt.Errorf("invalid broker URL: %v", err)
+		}
+		if uri.Scheme != "amqps" {
+			return nil, fmt.Errorf("TLS requires the amqps scheme, but the broker URL %s uses %s", uri.Redacted(), uri.Scheme)
+		}
+	}
+
+	cfg, err := newTLSConfig(c.TLS.CAFile, c.TLS.CertFile, c.TLS.KeyFile, c.TLS.ServerName, c.TLS.InsecureSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.TLS.External && len(cfg.Certificates) == 0 {
+		return nil, fmt.Errorf("SASL EXTERNAL authentication requires a client certificate")
+	}
+
+	return cfg, nil
+}
+
+// WebhookTLSConfig creates the TLS configuration used to post messages to the webhook. If no TLS settings are
+// configured for the webhook, nil is returned and the defaults of the system apply.
+func (c Config) WebhookTLSConfig() (*tls.Config, error) {
+	w := c.Webhook
+	if w.CAFile == "" && w.CertFile == "" && w.KeyFile == "" && w.ServerName == "" && !w.InsecureSkipVerify {
+		return nil, nil
+	}
+
+	return newTLSConfig(w.CAFile, w.CertFile, w.KeyFile, w.ServerName, w.InsecureSkipVerify)
+}
+
+// newTLSConfig creates a TLS configuration verifying the server by the certificates in the CA file, if set, and
+// presenting the client certificate, if set.
+func newTLSConfig(caFile, certFile, keyFile, serverName string, insecure bool) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: insecure,
+	}
+
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %v", err)
+		}
+
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in CA file %s", caFile)
+		}
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// SASLExternal checks if the consumer authenticates using SASL EXTERNAL, the identity of the client certificate
+// replacing username and password.
+func (c Config) SASLExternal() bool {
+	return c.TLS.Enabled && c.TLS.External
+}
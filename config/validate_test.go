@@ -0,0 +1,94 @@
+package config_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/config"
+	"github.com/stretchr/testify/assert"
+)
+
+const validateConfig = `[batch]
+size = 20
+
+[consumer "resize"]
+queue = resize
+executable = /usr/bin/resize
+publish = fd
+
+[consumer "retry"]
+queue = retry
+executable = /usr/bin/retry
+publish = fd
+localretryattempts = 3
+
+[consumer "worker"]
+queue = worker
+executable = /usr/bin/worker
+mode = worker
+`
+
+var validateTests = []struct {
+	name   string
+	config string
+	err    error
+}{
+	{"default", "", nil},
+	{"perMessage", "[localretry]\nattempts = 3\n[republish]\nexchange = failed\n[rpc]\nenabled = On\n[publish]\nsource = fd", nil},
+	{"batch", "[batch]\nsize = 20", nil},
+	{"batchOfOne", "[batch]\nsize = 1\n[rpc]\nenabled = On", nil},
+	{"batchSingleAttempt", "[batch]\nsize = 20\n[localretry]\nattempts = 1", nil},
+	{"batchLocalRetry", "[batch]\nsize = 20\n[localretry]\nattempts = 3", fmt.Errorf("local retries can not be used in batch mode")},
+	{"batchRepublish", "[batch]\nsize = 20\n[republish]\nroutingkey = failed", fmt.Errorf("republishing can not be used in batch mode")},
+	{"worker", "[worker]\nenabled = On", nil},
+	{"workerRpc", "[worker]\nenabled = On\n[rpc]\nenabled = On", fmt.Errorf("RPC can not be used in worker mode")},
+	{"workerBatch", "[worker]\nenabled = On\n[batch]\nsize = 20", fmt.Errorf("batch processing can not be used in worker mode")},
+	{"webhook", "[webhook]\nurl = http://localhost/messages", nil},
+	{"webhookLocalRetry", "[webhook]\nurl = http://localhost/messages\n[localretry]\nattempts = 3\n[batch]\nsize = 20", fmt.Errorf("local retries and batch processing can not be used with a webhook")},
+	{"webhookPublish", "[webhook]\nurl = http://localhost/messages\n[publish]\nsource = fd", fmt.Errorf("publishing can not be used with a webhook")},
+	{"fastCGI", "[fastcgi]\naddress = /run/php/php-fpm.sock", nil},
+	{"fastCGIRepublish", "[fastcgi]\naddress = /run/php/php-fpm.sock\n[republish]\nexchange = failed\n[rpc]\nenabled = On", fmt.Errorf("republishing and RPC can not be used with FastCGI")},
+	{"socket", "[socket]\npath = /run/consumer.sock", nil},
+	{"socketBatch", "[socket]\npath = /run/consumer.sock\n[batch]\nsize = 20", fmt.Errorf("batch processing can not be used with a socket")},
+	{"gateway", "[gateway]\naddress = 127.0.0.1:8080", nil},
+	{"gatewayLocalRetry", "[gateway]\naddress = 127.0.0.1:8080\n[localretry]\nattempts = 2", fmt.Errorf("local retries can not be used with the gateway")},
+	{"batchAll", "[batch]\nsize = 20\n[localretry]\nattempts = 3\n[republish]\nexchange = failed\n[rpc]\nenabled = On\n[publish]\nsource = fd", fmt.Errorf("local retries, republishing, RPC and publishing can not be used in batch mode")},
+}
+
+func TestConfig_Validate(t *testing.T) {
+	for _, test := range validateTests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := config.CreateFromString(test.config)
+			if err != nil {
+				t.Fatalf("failed to load config: %v", err)
+			}
+			assert.Equal(t, test.err, cfg.Validate())
+		})
+	}
+}
+
+var validateConsumerTests = []struct {
+	name string
+	err  error
+}{
+	{"resize", fmt.Errorf("invalid consumer \"resize\": publishing can not be used in batch mode")},
+	{"retry", fmt.Errorf("invalid consumer \"retry\": local retries and publishing can not be used in batch mode")},
+	{"worker", fmt.Errorf("invalid consumer \"worker\": batch processing can not be used in worker mode")},
+}
+
+func TestConfig_ValidateConsumer(t *testing.T) {
+	cfg, err := config.CreateFromString(validateConfig)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	// The settings of the main configuration are only validated once merged with the ones of a consumer.
+	assert.Nil(t, cfg.Validate())
+
+	for _, test := range validateConsumerTests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := cfg.ForConsumer(test.name)
+			assert.Equal(t, test.err, err)
+		})
+	}
+}
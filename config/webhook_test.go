@@ -0,0 +1,54 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const webhookConfig = `[timeout]
+execution = 1m
+
+[webhook]
+url = http://localhost:8080/messages
+envelope = On
+statusmap = 409:ack
+statusmap = 422:deadletter
+
+[consumer "report"]
+queue = report
+webhook = http://localhost:8080/reports
+timeout = 10s
+
+[consumer "mail"]
+queue = mail
+executable = /usr/bin/mail
+`
+
+var webhookTests = []struct {
+	name      string
+	config    string
+	consumer  string
+	url       string
+	envelope  bool
+	timeout   time.Duration
+	statusMap []string
+}{
+	{"default", "", "", "", false, 30 * time.Second, nil},
+	{"configured", webhookConfig, "", "http://localhost:8080/messages", true, time.Minute, []string{"409:ack", "422:deadletter"}},
+	{"consumer", webhookConfig, "report", "http://localhost:8080/reports", true, 10 * time.Second, []string{"409:ack", "422:deadletter"}},
+	{"executable", webhookConfig, "mail", "", true, time.Minute, []string{"409:ack", "422:deadletter"}},
+}
+
+func TestConfig_Webhook(t *testing.T) {
+	for _, test := range webhookTests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := loadConsumer(t, test.config, test.consumer)
+			assert.Equal(t, test.url, cfg.WebhookURL())
+			assert.Equal(t, test.envelope, cfg.WebhookEnvelope())
+			assert.Equal(t, test.timeout, cfg.WebhookTimeout())
+			assert.Equal(t, test.statusMap, cfg.WebhookStatusMap())
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const workerConfig = `[worker]
+enabled = true
+maxmessages = 1000
+maxmemory = 256
+crashaction = deadletter
+
+[consumer "resize"]
+queue = resize
+executable = /usr/bin/resize
+mode = worker
+
+[consumer "mail"]
+queue = mail
+executable = /usr/bin/mail
+mode = pipe
+`
+
+var workerTests = []struct {
+	name        string
+	config      string
+	consumer    string
+	enabled     bool
+	maxMessages int
+	maxMemory   int64
+	crashAction string
+}{
+	{"default", "", "", false, 0, 0, "requeue"},
+	{"configured", workerConfig, "", true, 1000, 256 * 1024 * 1024, "deadletter"},
+	{"consumer", workerConfig, "resize", true, 1000, 256 * 1024 * 1024, "deadletter"},
+	{"pipe", workerConfig, "mail", false, 1000, 256 * 1024 * 1024, "deadletter"},
+}
+
+func TestConfig_Worker(t *testing.T) {
+	for _, test := range workerTests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := loadConsumer(t, test.config, test.consumer)
+			assert.Equal(t, test.enabled, cfg.WorkerMode())
+			assert.Equal(t, test.maxMessages, cfg.WorkerMaxMessages())
+			assert.Equal(t, test.maxMemory, cfg.WorkerMaxMemory())
+			assert.Equal(t, test.crashAction, cfg.WorkerCrashAction())
+		})
+	}
+}
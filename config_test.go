@@ -15,7 +15,7 @@ var loadConfigurationTest = []struct {
 	name  string
 	flags func(set *flag.FlagSet)
 	err   error
-	url   string
+	url   []string
 	queue string
 }{
 	{
@@ -24,14 +24,14 @@ var loadConfigurationTest = []struct {
 			set.String("configuration", "fixtures/default.conf", "")
 		},
 		nil,
-		"",
+		nil,
 		"test",
 	},
 	{
 		"missingFlags",
 		func(set *flag.FlagSet) {},
 		cli.NewExitError("", 1),
-		"",
+		nil,
 		"",
 	},
 	{
@@ -40,7 +40,7 @@ var loadConfigurationTest = []struct {
 			set.String("configuration", "/does/not/exits.conf", "")
 		},
 		fmt.Errorf("failed parsing configuration: open /does/not/exits.conf: no such file or directory"),
-		"",
+		nil,
 		"",
 	},
 	{
@@ -50,7 +50,7 @@ var loadConfigurationTest = []struct {
 			set.String("url", "amqp://amqp.example.com:1234", "")
 		},
 		nil,
-		"amqp://amqp.example.com:1234",
+		[]string{"amqp://amqp.example.com:1234"},
 		"test",
 	},
 	{
@@ -60,7 +60,7 @@ var loadConfigurationTest = []struct {
 			set.String("queue-name", "someOtherQueue", "")
 		},
 		nil,
-		"",
+		nil,
 		"someOtherQueue",
 	},
 }
@@ -1,11 +1,24 @@
 package consumer
 
+import (
+	"crypto/tls"
+	"time"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/backoff"
+)
+
 // Config defines the interface to present configurations to the consumer.
 type Config interface {
-	AmqpUrl() string
+	AckBatchInterval() time.Duration
+	AckBatchSize() int
+	AmqpUrls() []string
+	BatchSize() int
+	BatchTimeout() time.Duration
+	Concurrency() int
 	ConsumerTag() string
 	DeadLetterExchange() string
 	DeadLetterRoutingKey() string
+	DrainOnShutdown() bool
 	ExchangeIsAutoDelete() bool
 	ExchangeIsDurable() bool
 	ExchangeName() string
@@ -15,13 +28,25 @@ type Config interface {
 	HasExchange() bool
 	HasMessageTTL() bool
 	HasPriority() bool
+	HasRetry() bool
 	MessageTTL() int32
 	MustDeclareQueue() bool
+	ParkingLotQueue() string
+	PoisonExchange() string
+	PoisonRoutingKey() string
+	PoisonThreshold() int
+	PoisonTrackerSize() int
 	PrefetchCount() int
 	PrefetchIsGlobal() bool
 	Priority() int32
 	QueueName() string
+	ReconnectPolicy() backoff.Policy
+	RetryDelays() []time.Duration
+	RetryQueues() []string
 	RoutingKeys() []string
+	SASLExternal() bool
+	ShuffleAmqpUrls() bool
+	TLSConfig() (*tls.Config, error)
 	QueueIsDurable() bool
 	QueueIsExclusive() bool
 	QueueIsAutoDelete() bool
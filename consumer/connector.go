@@ -0,0 +1,188 @@
+package consumer
+
+import (
+	"crypto/tls"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/bketelsen/logr"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/collector"
+	"github.com/streadway/amqp"
+)
+
+// Connector is a Connection which dials the broker on demand. Once the connection got lost, the next request for a
+// channel will establish a new connection.
+type Connector struct {
+	// URLs are the URLs of the broker nodes. Each (re)connect tries the nodes in order, starting with the one
+	// following the node connected to last.
+	URLs []string
+	// TLS is the configuration used for amqps URLs. If nil, the default configuration is used.
+	TLS *tls.Config
+	// External enables SASL EXTERNAL authentication, the broker identifying the client by its TLS certificate.
+	External bool
+	Log      logr.Logger
+	mu       sync.Mutex
+	conn     *amqp.Connection
+	next     int
+}
+
+// NewConnector creates a new connector for the given URLs. The connection is established lazily.
+func NewConnector(urls []string, l logr.Logger) *Connector {
+	return &Connector{URLs: urls, Log: l}
+}
+
+// NewConnectorFromConfig creates a new connector using the URLs and TLS settings of the configuration.
+func NewConnectorFromConfig(cfg Config, l logr.Logger) (*Connector, error) {
+	t, err := cfg.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	urls := cfg.AmqpUrls()
+	if cfg.ShuffleAmqpUrls() {
+		shuffled := make([]string, len(urls))
+		for i, j := range rand.Perm(len(urls)) {
+			shuffled[i] = urls[j]
+		}
+		urls = shuffled
+	}
+
+	c := NewConnector(urls, l)
+	c.TLS = t
+	c.External = cfg.SASLExternal()
+
+	return c, nil
+}
+
+// Channel opens a new channel, (re)connecting with the broker if there is no open connection.
+func (c *Connector) Channel() (*amqp.Channel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil || c.conn.IsClosed() {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+	}
+
+	c.Log.Info("Opening channel...")
+	ch, err := c.conn.Channel()
+	if nil != err {
+		return nil, fmt.Errorf("failed to open a channel: %v", err)
+	}
+	c.Log.Info("Done.")
+
+	return ch, nil
+}
+
+// Close closes the current connection, if there is one.
+func (c *Connector) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return nil
+	}
+
+	return c.conn.Close()
+}
+
+// connect dials the broker nodes one after the other until a connection is established. The next call starts with the
+// node following the one connected to, moving on to another node of the cluster once the connection got lost.
+func (c *Connector) connect() error {
+	if len(c.URLs) == 0 {
+		return fmt.Errorf("failed connecting RabbitMQ: no URL configured")
+	}
+
+	cluster := len(c.URLs) > 1
+
+	var err error
+	for i := range c.URLs {
+		n := (c.next + i) % len(c.URLs)
+		node := nodeName(c.URLs[n])
+
+		if cluster {
+			c.Log.Infof("Connecting RabbitMQ node %s...", node)
+		} else {
+			c.Log.Info("Connecting RabbitMQ...")
+		}
+
+		var conn *amqp.Connection
+		if conn, err = c.dial(c.URLs[n]); err != nil {
+			if cluster {
+				c.Log.Errorf("Failed connecting %s: %v", node, err)
+			}
+			continue
+		}
+
+		c.conn = conn
+		c.next = n + 1
+		collector.ConnectedNode.Reset()
+		collector.ConnectedNode.WithLabelValues(node).Set(1)
+		go c.untrack(conn, node)
+		c.Log.Info("Connected.")
+
+		return nil
+	}
+
+	return fmt.Errorf("failed connecting RabbitMQ: %v", err)
+}
+
+// untrack clears the connected node metric once the connection got closed, unless connected anew meanwhile.
+func (c *Connector) untrack(conn *amqp.Connection, node string) {
+	<-conn.NotifyClose(make(chan *amqp.Error, 1))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == conn {
+		collector.ConnectedNode.DeleteLabelValues(node)
+	}
+}
+
+// dial connects with the broker node. The TLS configuration and SASL EXTERNAL authentication only apply to amqps URLs.
+func (c *Connector) dial(u string) (*amqp.Connection, error) {
+	if uri, err := url.Parse(u); err != nil || uri.Scheme != "amqps" {
+		return amqp.Dial(u)
+	}
+
+	if !c.External {
+		return amqp.DialTLS(u, c.TLS)
+	}
+
+	// Same defaults as used by amqp.DialTLS.
+	return amqp.DialConfig(u, amqp.Config{
+		Heartbeat:       10 * time.Second,
+		Locale:          "en_US",
+		TLSClientConfig: c.TLS,
+		SASL:            []amqp.Authentication{externalAuth{}},
+	})
+}
+
+// externalAuth is the SASL EXTERNAL mechanism. The identity is taken from the client certificate, hence the empty
+// response.
+type externalAuth struct{}
+
+// Mechanism is part of amqp.Authentication.
+func (externalAuth) Mechanism() string {
+	return "EXTERNAL"
+}
+
+// Response is part of amqp.Authentication.
+func (externalAuth) Response() string {
+	return ""
+}
+
+// nodeName strips the credentials from the URL, making it safe to be logged and exposed as metric label.
+func nodeName(u string) string {
+	uri, err := url.Parse(u)
+	if err != nil {
+		return "unknown"
+	}
+	uri.User = nil
+
+	return uri.String()
+}
@@ -0,0 +1,62 @@
+package consumer_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	log "github.com/corvus-ch/logr/buffered"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/consumer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnector_Channel(t *testing.T) {
+	a, b := closedAddr(t), closedAddr(t)
+
+	tests := []struct {
+		name   string
+		urls   []string
+		err    string
+		output string
+	}{
+		{
+			"none",
+			nil,
+			"failed connecting RabbitMQ: no URL configured",
+			"",
+		},
+		{
+			"single",
+			[]string{fmt.Sprintf("amqp://guest:guest@%s/", a)},
+			fmt.Sprintf("failed connecting RabbitMQ: dial tcp %s: connect: connection refused", a),
+			"INFO Connecting RabbitMQ...\n",
+		},
+		{
+			"cluster",
+			[]string{fmt.Sprintf("amqp://guest:guest@%s/", a), fmt.Sprintf("amqp://%s/vhost", b)},
+			fmt.Sprintf("failed connecting RabbitMQ: dial tcp %s: connect: connection refused", b),
+			fmt.Sprintf("INFO Connecting RabbitMQ node amqp://%[1]s/...\nERROR Failed connecting amqp://%[1]s/: dial tcp %[1]s: connect: connection refused\nINFO Connecting RabbitMQ node amqp://%[2]s/vhost...\nERROR Failed connecting amqp://%[2]s/vhost: dial tcp %[2]s: connect: connection refused\n", a, b),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := log.New(0)
+			c := consumer.NewConnector(test.urls, l)
+			ch, err := c.Channel()
+			assert.Nil(t, ch)
+			assert.EqualError(t, err, test.err)
+			assert.Equal(t, test.output, l.Buf().String())
+		})
+	}
+}
+
+// closedAddr returns the address of a local port nobody is listening on.
+func closedAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	return l.Addr().String()
+}
@@ -3,7 +3,13 @@ package consumer
 import (
 	"context"
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/bketelsen/logr"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/acknowledger"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/backoff"
 	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
 	"github.com/corvus-ch/rabbitmq-cli-consumer/processor"
 	"github.com/streadway/amqp"
@@ -16,7 +22,48 @@ type Consumer struct {
 	Tag        string
 	Processor  processor.Processor
 	Log        logr.Logger
-	canceled   bool
+	// Reconnect is the policy applied when the channel or connection got closed by the broker. Reconnecting requires
+	// Open to be set.
+	Reconnect backoff.Policy
+	// Open opens a new channel, ready to be consumed from. It is used to recover from a lost channel or connection.
+	Open func() (Channel, error)
+	// Concurrency is the number of messages processed in parallel. Values smaller than one are treated as one.
+	Concurrency int
+	// AckBatchSize is the number of positive acknowledgements coalesced into one. Values smaller than two disable
+	// batching.
+	AckBatchSize int
+	// AckBatchInterval is the maximum time acknowledgements are held back when batching is enabled.
+	AckBatchInterval time.Duration
+	// BatchSize is the maximum number of messages passed to the processor at once, if it supports processing batches.
+	// Values smaller than two disable batching.
+	BatchSize int
+	// BatchTimeout is the maximum time waited for a batch to be completed once its first message has been received.
+	BatchTimeout time.Duration
+	// Drain makes the consumer process the messages already received once it got canceled, instead of putting them
+	// back into the queue.
+	Drain bool
+	// IdleTimeout makes the consumer exit when idle. Once the queue is empty and no message has been received for that
+	// long, the consumer cancels its subscription and Consume returns without error. Zero keeps the consumer running.
+	IdleTimeout time.Duration
+	// Delayer holds the messages requeued with a delay, if any. They count as in flight and get requeued right away once
+	// the consumer got canceled.
+	Delayer *acknowledger.Delayer
+	// Publisher diverts poison messages to the poison target. Without it, diverting fails and the messages are
+	// requeued.
+	Publisher acknowledger.Publisher
+	canceled  int32
+	// lastDelivery is the time the last message has been received, in nanoseconds since the epoch.
+	lastDelivery int64
+	inFlight     int32
+	// tracker counts the deliveries of messages locally for the detection of poison messages. It is kept across
+	// updates of the configuration not changing its size.
+	tracker     *delivery.Tracker
+	trackerSize int
+	trackerMu   sync.Mutex
+
+	// mu guards the processor, the channel and the configuration against being changed by Update while in use.
+	mu  sync.RWMutex
+	cfg Config
 }
 
 // New creates a new consumer instance. The setup of the amqp connection and channel is expected to be done by the
@@ -33,36 +80,101 @@ func New(conn Connection, ch Channel, p processor.Processor, l logr.Logger) *Con
 // NewFromConfig creates a new consumer instance. The setup of the amqp connection and channel is done according to the
 // configuration.
 func NewFromConfig(cfg Config, p processor.Processor, l logr.Logger) (*Consumer, error) {
-	l.Info("Connecting RabbitMQ...")
-	conn, err := amqp.Dial(cfg.AmqpUrl())
-	if nil != err {
-		return nil, fmt.Errorf("failed connecting RabbitMQ: %v", err)
+	conn, err := NewConnectorFromConfig(cfg, l)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := NewFromConnector(conn, cfg, p, l)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// NewFromConnector creates a new consumer instance using its own channel on the connection of the given connector.
+// This allows several consumers to share the same connection. The setup of the channel is done according to the
+// configuration.
+func NewFromConnector(conn *Connector, cfg Config, p processor.Processor, l logr.Logger) (*Consumer, error) {
+	c := &Consumer{
+		Connection:  conn,
+		Queue:       cfg.QueueName(),
+		Tag:         cfg.ConsumerTag(),
+		Processor:   p,
+		Log:         l,
+		Reconnect:   cfg.ReconnectPolicy(),
+		Concurrency: cfg.Concurrency(),
+
+		AckBatchSize:     cfg.AckBatchSize(),
+		AckBatchInterval: cfg.AckBatchInterval(),
+		BatchSize:        cfg.BatchSize(),
+		BatchTimeout:     cfg.BatchTimeout(),
+		Drain:            cfg.DrainOnShutdown(),
+
+		cfg: cfg,
 	}
-	l.Info("Connected.")
 
-	l.Info("Opening channel...")
-	ch, err := conn.Channel()
-	if nil != err {
-		return nil, fmt.Errorf("failed to open a channel: %v", err)
+	// The channel is set up according to the current configuration, which may have been changed by Update since.
+	c.Open = func() (Channel, error) {
+		ch, err := conn.Channel()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := Setup(c.config(), ch, l); err != nil {
+			return nil, err
+		}
+
+		return ch, nil
 	}
-	l.Info("Done.")
 
-	if err := Setup(cfg, ch, l); err != nil {
+	ch, err := c.Open()
+	if err != nil {
 		return nil, err
 	}
+	c.Channel = ch
 
-	return &Consumer{
-		Connection: conn,
-		Channel:    ch,
-		Queue:      cfg.QueueName(),
-		Tag:        cfg.ConsumerTag(),
-		Processor:  p,
-		Log:        l,
-	}, nil
+	if cfg.Concurrency() > cfg.PrefetchCount() {
+		l.Infof("Concurrency of %d is limited by the prefetch count of %d.", cfg.Concurrency(), cfg.PrefetchCount())
+	}
+
+	if cfg.BatchSize() > cfg.PrefetchCount() {
+		l.Infof("Batch size of %d is limited by the prefetch count of %d.", cfg.BatchSize(), cfg.PrefetchCount())
+	}
+
+	return c, nil
 }
 
 // Consume subscribes itself to the message queue and starts consuming messages.
+//
+// If the broker closes the channel or the connection, the consumer tries to reconnect according to its reconnect
+// policy. Once all attempts failed, the error received from the broker is returned.
 func (c *Consumer) Consume(ctx context.Context) error {
+	for {
+		err := c.subscribe(ctx)
+		closeErr, ok := err.(*amqp.Error)
+		if !ok || c.Open == nil || ctx.Err() != nil {
+			return err
+		}
+
+		// Getting canceled while waiting to reconnect is a regular shutdown, not a failure.
+		if err := c.reconnect(ctx, closeErr); err != nil || ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+func (c *Consumer) subscribe(ctx context.Context) error {
+	if c.IdleTimeout > 0 {
+		n, err := c.queueDepth()
+		if err != nil {
+			return fmt.Errorf("failed to inspect queue: %s", err)
+		}
+		c.Log.Infof("Consuming %d messages from queue \"%s\" until idle...", n, c.Queue)
+	}
+
 	c.Log.Info("Registering consumer... ")
 	msgs, err := c.Channel.Consume(c.Queue, c.Tag, false, false, false, false, nil)
 	if err != nil {
@@ -72,42 +184,352 @@ func (c *Consumer) Consume(ctx context.Context) error {
 	c.Log.Info("Succeeded registering consumer.")
 	c.Log.Info("Waiting for messages...")
 
-	remoteClose := make(chan *amqp.Error)
+	remoteClose := make(chan *amqp.Error, 1)
 	c.Channel.NotifyClose(remoteClose)
 
+	var b *delivery.Batcher
+	if c.AckBatchSize > 1 {
+		b = delivery.NewBatcher(c.AckBatchSize, c.AckBatchInterval)
+		msgs = b.Track(msgs)
+	}
+
+	var idle <-chan time.Time
+	var timer *time.Timer
+	if c.IdleTimeout > 0 {
+		atomic.StoreInt64(&c.lastDelivery, time.Now().UnixNano())
+		timer = time.NewTimer(c.IdleTimeout)
+		defer timer.Stop()
+		idle = timer.C
+	}
+
 	done := make(chan error)
-	go c.consume(msgs, done)
+	go c.consume(msgs, b, done)
 
-	select {
-	case err := <-remoteClose:
-		return err
+	for {
+		select {
+		case err := <-remoteClose:
+			// The delivery tags of messages still being processed are bound to the closed channel. Their acknowledgment
+			// will fail and the broker is going to redeliver them. Wait for them anyway to not process them twice in
+			// parallel.
+			c.Log.Info("Channel closed, waiting for running processes to finish...")
+			<-done
+			return err
+
+		case <-ctx.Done():
+			return c.cancel(done)
 
-	case <-ctx.Done():
-		c.canceled = true
-		err := c.Channel.Cancel(c.Tag, false)
-		if err == nil {
-			err = <-done
+		case <-idle:
+			if wait := c.untilIdle(); wait > 0 {
+				timer.Reset(wait)
+				continue
+			}
+
+			c.Log.Info("Consumer idle, cancel consumption of messages.")
+			return c.cancel(done)
+
+		case err := <-done:
+			// The deliveries channel also gets closed when the channel is closed by the broker. In that case, the close
+			// notification has been sent before.
+			if err == nil {
+				select {
+				case closeErr, ok := <-remoteClose:
+					if ok {
+						return closeErr
+					}
+				default:
+				}
+			}
+			return err
 		}
-		return err
+	}
+}
 
-	case err := <-done:
-		return err
+// cancel cancels the subscription and waits for the running processes to finish. Unless Drain is set, the messages held
+// back by the processor are put back into the queue. Messages waiting for their delayed requeue are requeued right away.
+func (c *Consumer) cancel(done <-chan error) error {
+	atomic.StoreInt32(&c.canceled, 1)
+	err := c.Channel.Cancel(c.Tag, false)
+	if r, ok := c.CurrentProcessor().(processor.Releaser); ok && !c.Drain {
+		r.Release()
+	}
+	if err == nil {
+		err = <-done
+	}
+	if c.Delayer != nil {
+		c.Delayer.Flush()
+	}
+	return err
+}
+
+// untilIdle returns the time left until the consumer is idle, which is once all messages received have been settled,
+// no message has been received for the idle timeout and the queue is empty.
+func (c *Consumer) untilIdle() time.Duration {
+	if atomic.LoadInt32(&c.inFlight) > 0 || (c.Delayer != nil && c.Delayer.Pending() > 0) {
+		return c.IdleTimeout
+	}
+
+	last := time.Unix(0, atomic.LoadInt64(&c.lastDelivery))
+	if wait := c.IdleTimeout - time.Since(last); wait > 0 {
+		return wait
+	}
+
+	n, err := c.queueDepth()
+	if err != nil {
+		c.Log.Errorf("Failed to inspect queue: %v", err)
+		return c.IdleTimeout
+	}
+	if n > 0 {
+		return c.IdleTimeout
+	}
+
+	return 0
+}
+
+// queueDepth returns the number of messages ready to be delivered from the queue.
+func (c *Consumer) queueDepth() (int, error) {
+	q, err := c.Channel.QueueDeclarePassive(c.Queue, false, false, false, false, nil)
+	if err != nil {
+		return 0, err
 	}
+
+	return q.Messages, nil
 }
 
-func (c *Consumer) consume(msgs <-chan amqp.Delivery, done chan error) {
-	for m := range msgs {
-		d := delivery.New(m)
-		if c.canceled {
-			d.Nack(true)
+func (c *Consumer) reconnect(ctx context.Context, cause *amqp.Error) error {
+	c.Log.Errorf("Lost channel: %v", cause)
+
+	for attempt := 1; !c.Reconnect.Exhausted(attempt); attempt++ {
+		wait := c.Reconnect.Duration(attempt)
+		c.Log.Infof("Reconnecting in %v (attempt %d)...", wait, attempt)
+
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-time.After(wait):
+		}
+
+		ch, err := c.Open()
+		if err != nil {
+			c.Log.Errorf("Failed to reconnect: %v", err)
 			continue
 		}
-		if err := c.checkError(c.Processor.Process(d)); err != nil {
-			done <- err
-			return
+
+		c.mu.Lock()
+		c.Channel = ch
+		c.mu.Unlock()
+		c.Log.Info("Reconnected.")
+		return nil
+	}
+
+	return cause
+}
+
+// consume processes the deliveries using a pool of workers. Once the deliveries channel is closed or one of the
+// workers failed, it waits for all running processes to finish, flushes the acknowledgements held back by the batcher
+// and reports the first error, if any.
+func (c *Consumer) consume(msgs <-chan amqp.Delivery, b *delivery.Batcher, done chan error) {
+	n := c.Concurrency
+	if n < 1 {
+		n = 1
+	}
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var err error
+	stop := make(chan struct{})
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if werr := c.work(msgs, stop); werr != nil {
+				once.Do(func() {
+					err = werr
+					close(stop)
+				})
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if b != nil {
+		if ferr := b.Flush(); ferr != nil {
+			c.Log.Errorf("Failed to flush acknowledgements: %v", ferr)
+		}
+	}
+
+	done <- err
+}
+
+func (c *Consumer) work(msgs <-chan amqp.Delivery, stop <-chan struct{}) error {
+	if c.BatchSize > 1 {
+		return c.workBatches(msgs, stop)
+	}
+
+	for {
+		select {
+		case <-stop:
+			return nil
+
+		case m, ok := <-msgs:
+			if !ok {
+				return nil
+			}
+			d := delivery.New(m)
+			if !c.Drain && atomic.LoadInt32(&c.canceled) == 1 {
+				d.Nack(true)
+				continue
+			}
+			if err := c.process(d); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// workBatches collects batches of messages and passes them to the processor. A batch is passed once it is complete,
+// the batch timeout is exceeded or no more messages are to be received.
+func (c *Consumer) workBatches(msgs <-chan amqp.Delivery, stop <-chan struct{}) error {
+	for {
+		ds, ok := c.collect(msgs, stop)
+		if len(ds) > 0 {
+			if !c.Drain && atomic.LoadInt32(&c.canceled) == 1 {
+				for _, d := range ds {
+					d.Nack(true)
+				}
+				c.finish(len(ds))
+			} else if err := c.processBatch(ds); err != nil {
+				return err
+			}
+		}
+
+		if !ok {
+			return nil
+		}
+	}
+}
+
+// collect waits for the first message of a batch and collects further messages until the batch is complete or the
+// batch timeout is exceeded. The second return value is false once no more messages are to be received. If the
+// workers are stopped in the meantime, the messages collected are put back into the queue. The messages collected count
+// as in flight, so the consumer does not turn idle while waiting for the batch to be completed.
+func (c *Consumer) collect(msgs <-chan amqp.Delivery, stop <-chan struct{}) ([]delivery.Delivery, bool) {
+	var ds []delivery.Delivery
+	select {
+	case <-stop:
+		return nil, false
+
+	case m, ok := <-msgs:
+		if !ok {
+			return nil, false
 		}
+		atomic.AddInt32(&c.inFlight, 1)
+		ds = append(ds, delivery.New(m))
 	}
-	done <- nil
+
+	timer := time.NewTimer(c.BatchTimeout)
+	defer timer.Stop()
+
+	for len(ds) < c.BatchSize {
+		select {
+		case <-stop:
+			for _, d := range ds {
+				d.Nack(true)
+			}
+			c.finish(len(ds))
+			return nil, false
+
+		case <-timer.C:
+			return ds, true
+
+		case m, ok := <-msgs:
+			if !ok {
+				return ds, false
+			}
+			atomic.AddInt32(&c.inFlight, 1)
+			ds = append(ds, delivery.New(m))
+		}
+	}
+
+	return ds, true
+}
+
+// processBatch passes the messages collected to the processor, no longer counting them as in flight afterwards.
+// Processors not supporting batches get the messages one after the other. Poison messages are taken out of the batch.
+func (c *Consumer) processBatch(ds []delivery.Delivery) error {
+	defer c.finish(len(ds))
+
+	var healthy []delivery.Delivery
+	for _, d := range ds {
+		if !c.quarantined(d) {
+			healthy = append(healthy, d)
+		}
+	}
+	if ds = healthy; len(ds) == 0 {
+		return nil
+	}
+
+	bp, ok := c.CurrentProcessor().(processor.BatchProcessor)
+	if !ok {
+		for _, d := range ds {
+			if err := c.checkError(c.CurrentProcessor().Process(d)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return c.checkError(bp.ProcessBatch(ds))
+}
+
+// process passes the message to the processor, keeping track of the messages in flight to tell when idle. Poison
+// messages are taken out of circulation instead.
+func (c *Consumer) process(d delivery.Delivery) error {
+	atomic.AddInt32(&c.inFlight, 1)
+	defer c.finish(1)
+
+	if c.quarantined(d) {
+		return nil
+	}
+
+	return c.checkError(c.CurrentProcessor().Process(d))
+}
+
+// finish no longer counts the messages as in flight, keeping track of the time to tell when idle.
+func (c *Consumer) finish(n int) {
+	atomic.StoreInt64(&c.lastDelivery, time.Now().UnixNano())
+	atomic.AddInt32(&c.inFlight, -int32(n))
+}
+
+// Update makes the consumer use the given processor and applies the prefetch settings of the configuration. Messages
+// already being processed are finished by the previous processor, all further messages are passed to the new one.
+// The configuration is also used to set up the channel when reconnecting.
+func (c *Consumer) Update(cfg Config, p processor.Processor) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cfg = cfg
+	c.Processor = p
+
+	return setupQoS(cfg, c.Channel, c.Log)
+}
+
+// CurrentProcessor returns the processor messages are currently passed to.
+func (c *Consumer) CurrentProcessor() processor.Processor {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.Processor
+}
+
+func (c *Consumer) config() Config {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.cfg
 }
 
 func (c *Consumer) checkError(err error) error {
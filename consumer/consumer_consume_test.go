@@ -7,11 +7,14 @@ import (
 	"time"
 
 	log "github.com/corvus-ch/logr/buffered"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/acknowledger"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/backoff"
 	"github.com/corvus-ch/rabbitmq-cli-consumer/consumer"
 	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
 	"github.com/corvus-ch/rabbitmq-cli-consumer/processor"
 	"github.com/streadway/amqp"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 const intMax = int(^uint(0) >> 1)
@@ -32,6 +35,7 @@ type consumeTest struct {
 	a           *TestAmqpAcknowledger
 	dd          []amqp.Delivery
 	cancelCount int
+	drain       bool
 }
 
 func newSimpleConsumeTest(name, output string, setup setupFunc) *consumeTest {
@@ -67,6 +71,7 @@ func (ct *consumeTest) Run(t *testing.T) {
 	c := consumer.New(nil, ct.ch, ct.p, l)
 	c.Queue = t.Name()
 	c.Tag = ct.Tag
+	c.Drain = ct.drain
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
 		ct.done <- c.Consume(ctx)
@@ -192,7 +197,228 @@ func TestConsumer_Consume_NotifyClose(t *testing.T) {
 		// When called too early, the close handler is not yet registered. Try again later.
 		time.Sleep(time.Millisecond)
 	}
+	// Along with the channel, the deliveries get closed.
+	close(d)
 
 	assert.Equal(t, &amqp.Error{Reason: "server close", Code: 320}, <-done)
 	ch.AssertExpectations(t)
 }
+
+func TestConsumer_Consume_Reconnect(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts int
+		openErrs []error
+		err      error
+		output   string
+	}{
+		{
+			"disabled",
+			0,
+			[]error{},
+			&amqp.Error{Reason: "server close", Code: 320},
+			"INFO Registering consumer... \nINFO Succeeded registering consumer.\nINFO Waiting for messages...\nINFO Channel closed, waiting for running processes to finish...\nERROR Lost channel: Exception (320) Reason: \"server close\"\n",
+		},
+		{
+			"reconnect",
+			3,
+			[]error{fmt.Errorf("dial error"), nil},
+			nil,
+			"INFO Registering consumer... \nINFO Succeeded registering consumer.\nINFO Waiting for messages...\nINFO Channel closed, waiting for running processes to finish...\nERROR Lost channel: Exception (320) Reason: \"server close\"\nINFO Reconnecting in 1ms (attempt 1)...\nERROR Failed to reconnect: dial error\nINFO Reconnecting in 1ms (attempt 2)...\nINFO Reconnected.\nINFO Registering consumer... \nINFO Succeeded registering consumer.\nINFO Waiting for messages...\n",
+		},
+		{
+			"exhausted",
+			2,
+			[]error{fmt.Errorf("dial error"), fmt.Errorf("dial error")},
+			&amqp.Error{Reason: "server close", Code: 320},
+			"INFO Registering consumer... \nINFO Succeeded registering consumer.\nINFO Waiting for messages...\nINFO Channel closed, waiting for running processes to finish...\nERROR Lost channel: Exception (320) Reason: \"server close\"\nINFO Reconnecting in 1ms (attempt 1)...\nERROR Failed to reconnect: dial error\nINFO Reconnecting in 1ms (attempt 2)...\nERROR Failed to reconnect: dial error\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ch := new(TestChannel)
+			d := make(chan amqp.Delivery)
+			ch.On("Consume", "", "", false, false, false, false, nilAmqpTable).Once().Return(d, nil)
+
+			newCh := new(TestChannel)
+			newD := make(chan amqp.Delivery)
+			newCh.On("Consume", "", "", false, false, false, false, nilAmqpTable).Once().Return(newD, nil)
+			newCh.On("Cancel", "", false).Once().Return(nil).Run(func(_ mock.Arguments) {
+				close(newD)
+			})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			l := log.New(0)
+			c := consumer.New(nil, ch, new(TestProcessor), l)
+			c.Reconnect = backoff.Policy{Attempts: test.attempts, Initial: time.Millisecond}
+			openErrs := test.openErrs
+			c.Open = func() (consumer.Channel, error) {
+				err := openErrs[0]
+				openErrs = openErrs[1:]
+				if err != nil {
+					return nil, err
+				}
+				go func() {
+					waitForNotifyClose(newCh)
+					cancel()
+				}()
+				return newCh, nil
+			}
+
+			go func() {
+				done <- c.Consume(ctx)
+			}()
+
+			waitForNotifyClose(ch)
+			ch.TriggerNotifyClose("server close")
+			close(d)
+
+			assert.Equal(t, test.err, <-done)
+			assert.Equal(t, test.output, l.Buf().String())
+			assert.Empty(t, openErrs)
+			ch.AssertExpectations(t)
+		})
+	}
+}
+
+func TestConsumer_Consume_ReconnectCanceled(t *testing.T) {
+	ch := new(TestChannel)
+	d := make(chan amqp.Delivery)
+	ch.On("Consume", "", "", false, false, false, false, nilAmqpTable).Once().Return(d, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	l := log.New(0)
+	c := consumer.New(nil, ch, new(TestProcessor), l)
+	c.Reconnect = backoff.Policy{Attempts: 3, Initial: time.Minute}
+	c.Open = func() (consumer.Channel, error) {
+		t.Error("reconnected after shutdown")
+		return nil, fmt.Errorf("dial error")
+	}
+
+	go func() {
+		done <- c.Consume(ctx)
+	}()
+
+	waitForNotifyClose(ch)
+	ch.TriggerNotifyClose("server close")
+	close(d)
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	assert.Nil(t, <-done)
+	assert.Equal(t, "INFO Registering consumer... \nINFO Succeeded registering consumer.\nINFO Waiting for messages...\nINFO Channel closed, waiting for running processes to finish...\nERROR Lost channel: Exception (320) Reason: \"server close\"\nINFO Reconnecting in 1m0s (attempt 1)...\n", l.Buf().String())
+	ch.AssertExpectations(t)
+}
+
+func TestConsumer_Consume_CanceledDelayedRequeue(t *testing.T) {
+	a := new(TestAmqpAcknowledger)
+	a.On("Nack", uint64(1), false, true).Return(nil).Once()
+	m := amqp.Delivery{Acknowledger: a, DeliveryTag: 1}
+	msgs := make(chan amqp.Delivery, 1)
+	msgs <- m
+
+	ch := new(TestChannel)
+	ch.On("Consume", "", "", false, false, false, false, nilAmqpTable).Once().Return(msgs, nil)
+	ch.On("Cancel", "", false).Return(nil).Once().Run(func(_ mock.Arguments) {
+		close(msgs)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	dl := &acknowledger.Delayer{}
+	p := new(TestProcessor)
+	p.On("Process", delivery.New(m)).Return(nil).Once().Run(func(args mock.Arguments) {
+		d := args.Get(0).(delivery.Delivery)
+		dl.Delay(time.Hour, func() {
+			d.Nack(true)
+		})
+		cancel()
+	})
+
+	c := consumer.New(nil, ch, p, log.New(0))
+	c.Delayer = dl
+
+	assert.Nil(t, c.Consume(ctx))
+	assert.Equal(t, 0, dl.Pending())
+	ch.AssertExpectations(t)
+	p.AssertExpectations(t)
+	a.AssertExpectations(t)
+}
+
+// waitForNotifyClose waits until the consumer did register its close handler with the channel.
+func waitForNotifyClose(ch *TestChannel) {
+	for !ch.HasNotifyClose() {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConsumer_Consume_Concurrency(t *testing.T) {
+	ch := new(TestChannel)
+	msgs := make(chan amqp.Delivery, 2)
+	p := new(TestProcessor)
+	started := make(chan bool)
+	release := make(chan bool)
+	done := make(chan error)
+
+	ch.On("Consume", "", "", false, false, false, false, nilAmqpTable).Once().Return(msgs, nil)
+	p.On("Process", mock.Anything).Twice().Return(nil).Run(func(_ mock.Arguments) {
+		started <- true
+		<-release
+	})
+
+	c := consumer.New(nil, ch, p, log.New(0))
+	c.Concurrency = 2
+
+	go func() {
+		done <- c.Consume(context.Background())
+	}()
+
+	msgs <- amqp.Delivery{DeliveryTag: 1}
+	msgs <- amqp.Delivery{DeliveryTag: 2}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+			// Intentionally left blank.
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timeout because messages are not processed in parallel")
+		}
+	}
+	close(release)
+	close(msgs)
+
+	assert.Nil(t, <-done)
+	ch.AssertExpectations(t)
+	p.AssertExpectations(t)
+}
+
+func TestConsumer_Consume_AckBatch(t *testing.T) {
+	ch := new(TestChannel)
+	msgs := make(chan amqp.Delivery)
+	p := new(TestProcessor)
+	a := new(TestAmqpAcknowledger)
+	done := make(chan error)
+
+	ch.On("Consume", "", "", false, false, false, false, nilAmqpTable).Once().Return(msgs, nil)
+	p.On("Process", mock.Anything).Times(3).Return(nil).Run(func(args mock.Arguments) {
+		args.Get(0).(delivery.Delivery).Ack()
+	})
+	a.On("Ack", uint64(3), true).Once().Return(nil)
+
+	c := consumer.New(nil, ch, p, log.New(0))
+	c.AckBatchSize = 10
+	c.AckBatchInterval = time.Hour
+
+	go func() {
+		done <- c.Consume(context.Background())
+	}()
+
+	for i := uint64(1); i <= 3; i++ {
+		msgs <- amqp.Delivery{Acknowledger: a, DeliveryTag: i}
+	}
+	close(msgs)
+
+	assert.Nil(t, <-done)
+	ch.AssertExpectations(t)
+	p.AssertExpectations(t)
+	a.AssertExpectations(t)
+}
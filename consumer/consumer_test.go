@@ -2,13 +2,22 @@ package consumer_test
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	stdlog "log"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
 	log "github.com/corvus-ch/logr/buffered"
+	"github.com/corvus-ch/logr/std"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/acknowledger"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/config"
 	"github.com/corvus-ch/rabbitmq-cli-consumer/consumer"
 	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/processor"
 	"github.com/streadway/amqp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -71,8 +80,8 @@ var cancelTests = []*consumeTest{
 				ct.sync <- true
 				<-ct.sync
 			})
-			ct.a.On("Nack", uint64(1), true, true).Return(nil)
-			ct.a.On("Nack", uint64(2), true, true).Return(nil)
+			ct.a.On("Nack", uint64(1), false, true).Return(nil)
+			ct.a.On("Nack", uint64(2), false, true).Return(nil)
 			return nil
 		},
 	),
@@ -91,6 +100,31 @@ var cancelTests = []*consumeTest{
 	),
 }
 
+func newDrainTest() *consumeTest {
+	ct := newConsumeTest(
+		"drain remaining",
+		"INFO Registering consumer... \nINFO Succeeded registering consumer.\nINFO Waiting for messages...\n",
+		3,
+		1,
+		func(t *testing.T, ct *consumeTest) error {
+			ct.ch.On("Consume", t.Name(), ct.Tag, false, false, false, false, nilAmqpTable).
+				Once().
+				Return(ct.msgs, nil)
+			ct.ch.On("Cancel", ct.Tag, false).Return(nil)
+			ct.p.On("Process", delivery.New(ct.dd[0])).Return(nil).Run(func(_ mock.Arguments) {
+				ct.sync <- true
+				<-ct.sync
+			})
+			ct.p.On("Process", delivery.New(ct.dd[1])).Return(nil).Once()
+			ct.p.On("Process", delivery.New(ct.dd[2])).Return(nil).Once()
+			return nil
+		},
+	)
+	ct.drain = true
+
+	return ct
+}
+
 func TestConsumer_Cancel(t *testing.T) {
 	t.Run("happy path", func(t *testing.T) {
 		testConsumerCancel(t, nil)
@@ -111,7 +145,196 @@ func TestConsumer_Cancel(t *testing.T) {
 			t.Error("Timeout because notify handler is blocking cancel")
 		}
 	})
-	for _, test := range cancelTests {
+	for _, test := range append(cancelTests, newDrainTest()) {
 		t.Run(test.Name, test.Run)
 	}
 }
+
+func TestConsumer_Cancel_Release(t *testing.T) {
+	for _, drain := range []bool{false, true} {
+		t.Run(fmt.Sprintf("drain %v", drain), func(t *testing.T) {
+			done := make(chan error)
+			ch := new(TestChannel)
+			msgs := make(chan amqp.Delivery)
+			ch.On("Consume", "queue", "ctag", false, false, false, false, nilAmqpTable).Once().Return(msgs, nil)
+			ch.On("Cancel", "ctag", false).Once().Return(nil).Run(func(_ mock.Arguments) {
+				close(msgs)
+			})
+			p := new(TestReleaser)
+			if !drain {
+				p.On("Release").Once()
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			c := consumer.New(nil, ch, p, log.New(0))
+			c.Queue = "queue"
+			c.Tag = "ctag"
+			c.Drain = drain
+			go func() {
+				done <- c.Consume(ctx)
+			}()
+			cancel()
+			assert.Nil(t, <-done)
+			ch.AssertExpectations(t)
+			p.AssertExpectations(t)
+		})
+	}
+}
+
+func TestConsumer_Update(t *testing.T) {
+	cfg, err := config.LoadAndParse("fixtures/qos.conf")
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	ch := new(TestChannel)
+	ch.On("Qos", 42, 0, true).Return(nil).Once()
+	p := new(TestProcessor)
+	c := consumer.New(nil, ch, new(TestProcessor), log.New(0))
+
+	assert.Nil(t, c.Update(cfg, p))
+	assert.Equal(t, p, c.CurrentProcessor())
+	ch.AssertExpectations(t)
+}
+
+func TestConsumer_Gateway(t *testing.T) {
+	cfg, err := config.CreateFromString("[rabbitmq]\nconcurrency = 1\n\n[prefetch]\ncount = 3\n\n[gateway]\naddress = 127.0.0.1:8080\n")
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	a := new(TestAmqpAcknowledger)
+	msgs := make(chan amqp.Delivery, 3)
+	for i := 1; i <= 3; i++ {
+		msgs <- amqp.Delivery{Acknowledger: a, DeliveryTag: uint64(i)}
+		a.On("Ack", uint64(i), false).Return(nil).Once()
+	}
+
+	ch := new(TestChannel)
+	ch.On("Consume", "", "", false, false, false, false, nilAmqpTable).Return(msgs, nil).Once()
+
+	g := processor.NewGateway()
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+	ack := &acknowledger.Responding{Acknowledger: &acknowledger.Default{}}
+	// The messages are processed in parallel, which the buffered logger does not support.
+	l := std.New(0, stdlog.New(ioutil.Discard, "", 0))
+	c := consumer.New(nil, ch, processor.NewGatewayFromConfig(cfg, g, ack, l), log.New(0))
+	c.Concurrency = cfg.Concurrency()
+
+	done := make(chan error)
+	go func() {
+		done <- c.Consume(context.Background())
+	}()
+
+	// All prefetched messages can be leased at once, despite the concurrency of one.
+	var ids []string
+	for deadline := time.Now().Add(5 * time.Second); len(ids) < 3 && time.Now().Before(deadline); {
+		res, err := http.Get(srv.URL + "/messages?max=3&wait=1s")
+		if err != nil {
+			t.Fatalf("failed to fetch messages: %v", err)
+		}
+		var leased []struct{ ID string }
+		json.NewDecoder(res.Body).Decode(&leased)
+		res.Body.Close()
+		for _, l := range leased {
+			ids = append(ids, l.ID)
+		}
+	}
+	if len(ids) != 3 {
+		t.Fatalf("leased %d messages at once, expected 3", len(ids))
+	}
+
+	for _, id := range ids {
+		res, err := http.Post(srv.URL+"/messages/"+id+"/ack", "", nil)
+		if err != nil {
+			t.Fatalf("failed to settle message: %v", err)
+		}
+		res.Body.Close()
+	}
+	close(msgs)
+
+	assert.Nil(t, <-done)
+	ch.AssertExpectations(t)
+	a.AssertExpectations(t)
+}
+
+func TestConsumer_IdleTimeout(t *testing.T) {
+	msgs := make(chan amqp.Delivery, 1)
+	m := amqp.Delivery{Body: []byte(t.Name())}
+	msgs <- m
+
+	ch := new(TestChannel)
+	ch.On("QueueDeclarePassive", "queue", false, false, false, false, nilAmqpTable).Return(amqp.Queue{Messages: 1}, nil).Once()
+	ch.On("QueueDeclarePassive", "queue", false, false, false, false, nilAmqpTable).Return(amqp.Queue{}, nil)
+	ch.On("Consume", "queue", "tag", false, false, false, false, nilAmqpTable).Return(msgs, nil).Once()
+	ch.On("Cancel", "tag", false).Return(nil).Once().Run(func(_ mock.Arguments) {
+		close(msgs)
+	})
+	p := new(TestProcessor)
+	p.On("Process", delivery.New(m)).Return(nil).Once()
+
+	l := log.New(0)
+	c := consumer.New(nil, ch, p, l)
+	c.Queue = "queue"
+	c.Tag = "tag"
+	c.IdleTimeout = 50 * time.Millisecond
+
+	assert.Nil(t, c.Consume(context.Background()))
+	assert.Equal(t, "INFO Consuming 1 messages from queue \"queue\" until idle...\nINFO Registering consumer... \nINFO Succeeded registering consumer.\nINFO Waiting for messages...\nINFO Consumer idle, cancel consumption of messages.\n", l.Buf().String())
+	ch.AssertExpectations(t)
+	p.AssertExpectations(t)
+}
+
+func TestConsumer_IdleTimeout_Batch(t *testing.T) {
+	msgs := make(chan amqp.Delivery, 1)
+	m := amqp.Delivery{Body: []byte(t.Name())}
+	msgs <- m
+
+	ch := new(TestChannel)
+	ch.On("QueueDeclarePassive", "queue", false, false, false, false, nilAmqpTable).Return(amqp.Queue{}, nil)
+	ch.On("Consume", "queue", "tag", false, false, false, false, nilAmqpTable).Return(msgs, nil).Once()
+	ch.On("Cancel", "tag", false).Return(nil).Once().Run(func(_ mock.Arguments) {
+		close(msgs)
+	})
+	p := new(TestProcessor)
+	p.On("ProcessBatch", []delivery.Delivery{delivery.New(m)}).Return(nil).Once()
+
+	c := consumer.New(nil, ch, p, log.New(0))
+	c.Queue = "queue"
+	c.Tag = "tag"
+	c.BatchSize = 2
+	c.BatchTimeout = 200 * time.Millisecond
+	c.IdleTimeout = 50 * time.Millisecond
+
+	assert.Nil(t, c.Consume(context.Background()))
+	ch.AssertExpectations(t)
+	p.AssertExpectations(t)
+}
+
+func TestConsumer_Batch(t *testing.T) {
+	msgs := make(chan amqp.Delivery, 3)
+	var dd []delivery.Delivery
+	for i := 1; i <= 3; i++ {
+		m := amqp.Delivery{DeliveryTag: uint64(i), Body: []byte(fmt.Sprintf("message %d", i))}
+		msgs <- m
+		dd = append(dd, delivery.New(m))
+	}
+
+	ch := new(TestChannel)
+	ch.On("Consume", "queue", "tag", false, false, false, false, nilAmqpTable).Return(msgs, nil).Once()
+	p := new(TestProcessor)
+	p.On("ProcessBatch", dd[:2]).Return(nil).Once()
+	p.On("ProcessBatch", dd[2:]).Return(nil).Once().Run(func(_ mock.Arguments) {
+		close(msgs)
+	})
+
+	c := consumer.New(nil, ch, p, log.New(0))
+	c.Queue = "queue"
+	c.Tag = "tag"
+	c.BatchSize = 2
+	c.BatchTimeout = 50 * time.Millisecond
+
+	assert.Nil(t, c.Consume(context.Background()))
+	ch.AssertExpectations(t)
+	p.AssertExpectations(t)
+}
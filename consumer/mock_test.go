@@ -1,6 +1,8 @@
 package consumer_test
 
 import (
+	"sync"
+
 	"github.com/corvus-ch/rabbitmq-cli-consumer/consumer"
 	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
 	"github.com/corvus-ch/rabbitmq-cli-consumer/processor"
@@ -28,6 +30,7 @@ func (t *TestConnection) Channel() (*amqp.Channel, error) {
 type TestChannel struct {
 	consumer.Channel
 	mock.Mock
+	mu          sync.Mutex
 	notifyClose chan *amqp.Error
 }
 
@@ -38,11 +41,21 @@ func (t *TestChannel) ExchangeDeclare(name, kind string, durable, autoDelete, in
 }
 
 func (t *TestChannel) NotifyClose(c chan *amqp.Error) chan *amqp.Error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	t.notifyClose = c
 	return c
 }
 
+func (t *TestChannel) HasNotifyClose() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.notifyClose != nil
+}
+
 func (t *TestChannel) TriggerNotifyClose(reason string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	if t.notifyClose != nil {
 		t.notifyClose <- &amqp.Error{
 			Reason: reason,
@@ -59,6 +72,12 @@ func (t *TestChannel) QueueDeclare(name string, durable, autoDelete, exclusive,
 	return argsT.Get(0).(amqp.Queue), argsT.Error(1)
 }
 
+func (t *TestChannel) QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	argsT := t.Called(name, durable, autoDelete, exclusive, noWait, args)
+
+	return argsT.Get(0).(amqp.Queue), argsT.Error(1)
+}
+
 func (t *TestChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
 	argsT := t.Called(prefetchCount, prefetchSize, global)
 
@@ -93,6 +112,22 @@ func (t *TestChannel) Publish(exchange, key string, mandatory, immediate bool, m
 
 	return argsT.Error(0)
 }
+func (t *TestChannel) Confirm(noWait bool) error {
+	argsT := t.Called(noWait)
+
+	return argsT.Error(0)
+}
+
+func (t *TestChannel) NotifyPublish(c chan amqp.Confirmation) chan amqp.Confirmation {
+	argsT := t.Called(c)
+
+	if f, ok := argsT.Get(0).(func(chan amqp.Confirmation)); ok {
+		f(c)
+	}
+
+	return c
+}
+
 func (t *TestChannel) Cancel(consumer string, noWait bool) error {
 	argsT := t.Called(consumer, noWait)
 
@@ -108,10 +143,22 @@ func (p *TestProcessor) Process(d delivery.Delivery) error {
 	return p.Called(d).Error(0)
 }
 
+func (p *TestProcessor) ProcessBatch(ds []delivery.Delivery) error {
+	return p.Called(ds).Error(0)
+}
+
 func (p *TestProcessor) Cancel() error {
 	return p.Called().Error(0)
 }
 
+type TestReleaser struct {
+	TestProcessor
+}
+
+func (p *TestReleaser) Release() {
+	p.Called()
+}
+
 type TestAmqpAcknowledger struct {
 	amqp.Acknowledger
 	mock.Mock
@@ -134,3 +181,11 @@ func (a *TestAmqpAcknowledger) Reject(tag uint64, requeue bool) error {
 
 	return argsT.Error(0)
 }
+
+type TestPublisher struct {
+	mock.Mock
+}
+
+func (p *TestPublisher) Publish(exchange, key string, msg amqp.Publishing) error {
+	return p.Called(exchange, key, msg).Error(0)
+}
@@ -0,0 +1,90 @@
+package consumer
+
+import (
+	"fmt"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+)
+
+// DeliveriesHeader is the message header holding the number of deliveries of a poison message diverted to the poison
+// target.
+const DeliveriesHeader = "x-deliveries"
+
+// quarantined checks if the message is poison, which is once it got delivered more often than the poison threshold.
+// Poison messages are taken out of circulation instead of being passed to the processor, whatever kind of processor
+// it is. Without configuration, as with New, no message is considered poison.
+func (c *Consumer) quarantined(d delivery.Delivery) bool {
+	cfg := c.config()
+	if cfg == nil || cfg.PoisonThreshold() <= 0 {
+		return false
+	}
+
+	n := c.deliveries(d, cfg.QueueName(), cfg.PoisonTrackerSize())
+	if n <= cfg.PoisonThreshold() {
+		return false
+	}
+
+	c.quarantine(d, n, cfg.PoisonExchange(), cfg.PoisonRoutingKey())
+
+	return true
+}
+
+// deliveries returns the number of times the message has been delivered from the queue. It is the larger one of the
+// count known by the broker and the one tracked locally. The local tracker is created on first use and replaced once its size got
+// changed, a size of zero disables it.
+func (c *Consumer) deliveries(d delivery.Delivery, queue string, size int) int {
+	n := delivery.Count(d.Properties(), queue)
+	if size <= 0 {
+		return n
+	}
+
+	c.trackerMu.Lock()
+	if c.tracker == nil || c.trackerSize != size {
+		c.tracker = delivery.NewTracker(size)
+		c.trackerSize = size
+	}
+	t := c.tracker
+	c.trackerMu.Unlock()
+
+	if m := t.Track(d); m > n {
+		n = m
+	}
+
+	return n
+}
+
+// quarantine takes a poison message out of circulation. It is either diverted to the poison target or rejected without
+// requeueing, leaving it to the dead letter exchange of the queue. If diverting fails, the message is requeued.
+func (c *Consumer) quarantine(d delivery.Delivery, n int, exchange, key string) {
+	id := d.Properties().MessageID
+	if id == "" {
+		id = "without ID"
+	}
+
+	if exchange == "" && key == "" {
+		c.Log.Errorf("Rejecting poison message %s, delivered %d times.", id, n)
+		d.Reject(false)
+		return
+	}
+
+	c.Log.Errorf("Diverting poison message %s, delivered %d times...", id, n)
+	if err := c.divert(d, n, exchange, key); err != nil {
+		c.Log.Errorf("Failed to divert poison message: %v", err)
+		d.Nack(true)
+		return
+	}
+
+	d.Ack()
+}
+
+// divert publishes the poison message to the poison target, adding the number of deliveries.
+func (c *Consumer) divert(d delivery.Delivery, n int, exchange, key string) error {
+	if c.Publisher == nil {
+		return fmt.Errorf("no publisher available")
+	}
+
+	msg := d.Properties().Publishing(d.Body())
+	msg.Headers[DeliveriesHeader] = int64(n)
+
+	return c.Publisher.Publish(exchange, key, msg)
+}
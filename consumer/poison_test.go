@@ -0,0 +1,150 @@
+package consumer_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	log "github.com/corvus-ch/logr/buffered"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/config"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/consumer"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var poisonTests = []struct {
+	name    string
+	config  string
+	headers amqp.Table
+	setup   func(a *TestAmqpAcknowledger, pub *TestPublisher, p *TestProcessor, d amqp.Delivery)
+	output  string
+}{
+	{
+		"belowThreshold",
+		"[poison]\nthreshold = 3",
+		amqp.Table{"x-delivery-count": int64(2)},
+		func(a *TestAmqpAcknowledger, pub *TestPublisher, p *TestProcessor, d amqp.Delivery) {
+			p.On("Process", delivery.New(d)).Return(nil).Once()
+		},
+		"",
+	},
+	{
+		"disabled",
+		"",
+		amqp.Table{"x-delivery-count": int64(3)},
+		func(a *TestAmqpAcknowledger, pub *TestPublisher, p *TestProcessor, d amqp.Delivery) {
+			p.On("Process", delivery.New(d)).Return(nil).Once()
+		},
+		"",
+	},
+	{
+		"reject",
+		"[poison]\nthreshold = 3",
+		amqp.Table{"x-delivery-count": int64(3)},
+		func(a *TestAmqpAcknowledger, pub *TestPublisher, p *TestProcessor, d amqp.Delivery) {
+			a.On("Reject", uint64(1), false).Return(nil).Once()
+		},
+		"ERROR Rejecting poison message 42, delivered 4 times.\n",
+	},
+	{
+		"divert",
+		"[poison]\nthreshold = 3\nexchange = poison\nroutingkey = mail",
+		amqp.Table{"x-delivery-count": int64(3)},
+		func(a *TestAmqpAcknowledger, pub *TestPublisher, p *TestProcessor, d amqp.Delivery) {
+			pub.On("Publish", "poison", "mail", mock.MatchedBy(func(msg amqp.Publishing) bool {
+				return msg.Headers[consumer.DeliveriesHeader] == int64(4) && msg.MessageId == "42"
+			})).Return(nil).Once()
+			a.On("Ack", uint64(1), false).Return(nil).Once()
+		},
+		"ERROR Diverting poison message 42, delivered 4 times...\n",
+	},
+	{
+		"divertError",
+		"[poison]\nthreshold = 3\nroutingkey = mail.poison",
+		amqp.Table{"x-delivery-count": int64(3)},
+		func(a *TestAmqpAcknowledger, pub *TestPublisher, p *TestProcessor, d amqp.Delivery) {
+			pub.On("Publish", "", "mail.poison", mock.Anything).Return(errors.New("channel closed")).Once()
+			a.On("Nack", uint64(1), false, true).Return(nil).Once()
+		},
+		"ERROR Diverting poison message 42, delivered 4 times...\nERROR Failed to divert poison message: channel closed\n",
+	},
+	{
+		"batch",
+		"[poison]\nthreshold = 3\n[batch]\nsize = 2\ntimeout = 10ms",
+		amqp.Table{"x-delivery-count": int64(3)},
+		func(a *TestAmqpAcknowledger, pub *TestPublisher, p *TestProcessor, d amqp.Delivery) {
+			a.On("Reject", uint64(1), false).Return(nil).Once()
+		},
+		"ERROR Rejecting poison message 42, delivered 4 times.\n",
+	},
+}
+
+// TestConsumer_Poison checks poison messages are taken out of circulation before they reach the processor, whatever
+// kind of processor it is.
+func TestConsumer_Poison(t *testing.T) {
+	for _, test := range poisonTests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := config.CreateFromString(test.config)
+			if err != nil {
+				t.Fatalf("failed to load config: %v", err)
+			}
+
+			a := new(TestAmqpAcknowledger)
+			pub := new(TestPublisher)
+			p := new(TestProcessor)
+			d := amqp.Delivery{Acknowledger: a, DeliveryTag: 1, MessageId: "42", Headers: test.headers}
+			msgs := make(chan amqp.Delivery, 1)
+			msgs <- d
+			close(msgs)
+
+			ch := new(TestChannel)
+			ch.On("Qos", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+			ch.On("Consume", "", "", false, false, false, false, nilAmqpTable).Return(msgs, nil).Once()
+			test.setup(a, pub, p, d)
+
+			l := log.New(0)
+			c := consumer.New(nil, ch, p, l)
+			c.Publisher = pub
+			c.BatchSize = cfg.BatchSize()
+			c.BatchTimeout = cfg.BatchTimeout()
+			assert.Nil(t, c.Update(cfg, p))
+
+			assert.Nil(t, c.Consume(context.Background()))
+			assert.Equal(t, "INFO Setting QoS... \nINFO Succeeded setting QoS.\nINFO Registering consumer... \nINFO Succeeded registering consumer.\nINFO Waiting for messages...\n"+test.output, l.Buf().String())
+			a.AssertExpectations(t)
+			pub.AssertExpectations(t)
+			p.AssertExpectations(t)
+		})
+	}
+}
+
+func TestConsumer_PoisonTracked(t *testing.T) {
+	cfg, err := config.CreateFromString("[poison]\nthreshold = 1")
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	a := new(TestAmqpAcknowledger)
+	p := new(TestProcessor)
+	msgs := make(chan amqp.Delivery, 2)
+	first := amqp.Delivery{Acknowledger: a, DeliveryTag: 1, MessageId: "42", Redelivered: true}
+	second := amqp.Delivery{Acknowledger: a, DeliveryTag: 2, MessageId: "42", Redelivered: true}
+	msgs <- first
+	msgs <- second
+	close(msgs)
+
+	ch := new(TestChannel)
+	ch.On("Qos", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	ch.On("Consume", "", "", false, false, false, false, nilAmqpTable).Return(msgs, nil).Once()
+	p.On("Process", delivery.New(first)).Return(nil).Once()
+	a.On("Reject", uint64(2), false).Return(nil).Once()
+
+	c := consumer.New(nil, ch, p, log.New(0))
+	assert.Nil(t, c.Update(cfg, p))
+
+	assert.Nil(t, c.Consume(context.Background()))
+	a.AssertExpectations(t)
+	p.AssertExpectations(t)
+}
@@ -0,0 +1,102 @@
+package consumer
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+// Publisher publishes messages on a channel of its own, waiting for the broker to confirm each message. The channel is
+// opened on first use and reopened once it got closed.
+type Publisher struct {
+	// Open opens the channel used for publishing.
+	Open     func() (Channel, error)
+	mu       sync.Mutex
+	ch       Channel
+	confirms chan amqp.Confirmation
+}
+
+// NewPublisher creates a new publisher using a channel on the connection of the given connector.
+func NewPublisher(conn *Connector) *Publisher {
+	return &Publisher{
+		Open: func() (Channel, error) {
+			return conn.Channel()
+		},
+	}
+}
+
+// Publish publishes the message and waits for the broker to confirm it. It is safe to be called concurrently.
+func (p *Publisher) Publish(exchange, key string, msg amqp.Publishing) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// A channel closed since the last call is only noticed when publishing, hence one retry on a fresh channel.
+	for retry := true; ; retry = false {
+		if err := p.channel(); err != nil {
+			return err
+		}
+
+		err := p.ch.Publish(exchange, key, false, false, msg)
+		if err == nil {
+			break
+		}
+
+		p.reset()
+		if err != amqp.ErrClosed || !retry {
+			return fmt.Errorf("failed to publish message: %v", err)
+		}
+	}
+
+	c, ok := <-p.confirms
+	if !ok {
+		p.reset()
+		return fmt.Errorf("failed to publish message: channel closed before confirmation")
+	}
+	if !c.Ack {
+		return fmt.Errorf("failed to publish message: rejected by broker")
+	}
+
+	return nil
+}
+
+// Close closes the channel, if there is one.
+func (p *Publisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.ch == nil {
+		return nil
+	}
+
+	err := p.ch.Close()
+	p.reset()
+
+	return err
+}
+
+func (p *Publisher) channel() error {
+	if p.ch != nil {
+		return nil
+	}
+
+	ch, err := p.Open()
+	if err != nil {
+		return err
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return fmt.Errorf("failed to enable publisher confirms: %v", err)
+	}
+
+	p.ch = ch
+	p.confirms = ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+
+	return nil
+}
+
+func (p *Publisher) reset() {
+	p.ch = nil
+	p.confirms = nil
+}
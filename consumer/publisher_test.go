@@ -0,0 +1,93 @@
+package consumer_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/consumer"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var msg = amqp.Publishing{Body: []byte("reply")}
+
+var publisherTests = []struct {
+	name  string
+	setup func(ch *TestChannel, confirm func(bool))
+	opens int
+	err   string
+}{
+	{
+		"confirmed",
+		func(ch *TestChannel, confirm func(bool)) {
+			ch.On("Publish", "ex", "key", false, false, msg).Return(nil).Run(func(mock.Arguments) { confirm(true) })
+		},
+		1,
+		"",
+	},
+	{
+		"rejected",
+		func(ch *TestChannel, confirm func(bool)) {
+			ch.On("Publish", "ex", "key", false, false, msg).Return(nil).Run(func(mock.Arguments) { confirm(false) })
+		},
+		1,
+		"failed to publish message: rejected by broker",
+	},
+	{
+		"reopen",
+		func(ch *TestChannel, confirm func(bool)) {
+			ch.On("Publish", "ex", "key", false, false, msg).Return(amqp.ErrClosed).Once()
+			ch.On("Publish", "ex", "key", false, false, msg).Return(nil).Run(func(mock.Arguments) { confirm(true) })
+		},
+		2,
+		"",
+	},
+	{
+		"error",
+		func(ch *TestChannel, confirm func(bool)) {
+			ch.On("Publish", "ex", "key", false, false, msg).Return(fmt.Errorf("boom"))
+		},
+		1,
+		"failed to publish message: boom",
+	},
+}
+
+func TestPublisher_Publish(t *testing.T) {
+	for _, test := range publisherTests {
+		t.Run(test.name, func(t *testing.T) {
+			var confirms chan amqp.Confirmation
+			ch := new(TestChannel)
+			ch.On("Confirm", false).Return(nil)
+			ch.On("NotifyPublish", mock.Anything).Return(func(c chan amqp.Confirmation) { confirms = c })
+			test.setup(ch, func(ack bool) { confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: ack} })
+
+			opens := 0
+			p := &consumer.Publisher{
+				Open: func() (consumer.Channel, error) {
+					opens++
+					return ch, nil
+				},
+			}
+
+			err := p.Publish("ex", "key", msg)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+			} else {
+				assert.Nil(t, err)
+			}
+			assert.Equal(t, test.opens, opens)
+			ch.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPublisher_Publish_OpenError(t *testing.T) {
+	p := &consumer.Publisher{
+		Open: func() (consumer.Channel, error) {
+			return nil, fmt.Errorf("failed connecting RabbitMQ")
+		},
+	}
+
+	assert.EqualError(t, p.Publish("ex", "key", msg), "failed connecting RabbitMQ")
+}
@@ -2,6 +2,8 @@ package consumer
 
 import (
 	"fmt"
+	"time"
+
 	"github.com/bketelsen/logr"
 	"github.com/streadway/amqp"
 )
@@ -18,6 +20,14 @@ func Setup(cfg Config, ch Channel, l logr.Logger) error {
 		}
 	}
 
+	// The retry queues are declared even if the queue is not, as messages would get lost when published to a retry
+	// queue not existing.
+	if cfg.HasRetry() {
+		if err := declareRetryQueues(cfg, ch, l); err != nil {
+			return err
+		}
+	}
+
 	// Empty Exchange name means default, no need to declare
 	if cfg.HasExchange() {
 		if err := declareExchange(cfg, ch, l); err != nil {
@@ -57,6 +67,29 @@ func declareQueue(cfg Config, ch Channel, l logr.Logger) error {
 	return nil
 }
 
+// declareRetryQueues declares a retry queue per delay and the parking lot queue. Messages expire from the retry queues
+// after the delay and get dead lettered back into the queue using the default exchange.
+func declareRetryQueues(cfg Config, ch Channel, l logr.Logger) error {
+	delays := cfg.RetryDelays()
+	for i, name := range cfg.RetryQueues() {
+		l.Infof("Declaring retry queue \"%s\"...", name)
+		if _, err := ch.QueueDeclare(name, true, false, false, false, amqp.Table{
+			"x-message-ttl":             int64(delays[i] / time.Millisecond),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": cfg.QueueName(),
+		}); err != nil {
+			return fmt.Errorf("failed to declare retry queue: %v", err)
+		}
+	}
+
+	l.Infof("Declaring parking lot queue \"%s\"...", cfg.ParkingLotQueue())
+	if _, err := ch.QueueDeclare(cfg.ParkingLotQueue(), true, false, false, false, amqp.Table{}); err != nil {
+		return fmt.Errorf("failed to declare parking lot queue: %v", err)
+	}
+
+	return nil
+}
+
 func declareExchange(cfg Config, ch Channel, l logr.Logger) error {
 	l.Infof("Declaring exchange \"%s\"...", cfg.ExchangeName())
 	if err := ch.ExchangeDeclare(
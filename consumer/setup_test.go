@@ -19,6 +19,8 @@ const (
 	noRoutingKeyConfig        = "no_routing"
 	oneEmptyRoutingKeyConfig  = "empty_routing"
 	priorityConfig            = "priority"
+	retryConfig               = "retry"
+	retryNoDeclareConfig      = "retry_nodeclare"
 	qosConfig                 = "qos"
 	routingConfig             = "routing"
 	simpleExchangeConfig      = "exchange"
@@ -109,6 +111,41 @@ var queueTests = []struct {
 		},
 		nil,
 	},
+	// Declare retry queues and parking lot.
+	{
+		"retryQueues",
+		retryConfig,
+		func(ch *TestChannel) {
+			ch.On("Qos", 3, 0, false).Return(nil).Once()
+			ch.On("QueueDeclare", "retryQueue", true, false, false, false, emptyAmqpTable).Return(amqp.Queue{}, nil).Once()
+			ch.On("QueueDeclare", "retryQueue.retry.10s", true, false, false, false, amqp.Table{"x-message-ttl": int64(10000), "x-dead-letter-exchange": "", "x-dead-letter-routing-key": "retryQueue"}).Return(amqp.Queue{}, nil).Once()
+			ch.On("QueueDeclare", "retryQueue.retry.1m", true, false, false, false, amqp.Table{"x-message-ttl": int64(60000), "x-dead-letter-exchange": "", "x-dead-letter-routing-key": "retryQueue"}).Return(amqp.Queue{}, nil).Once()
+			ch.On("QueueDeclare", "parkedQueue", true, false, false, false, emptyAmqpTable).Return(amqp.Queue{}, nil).Once()
+		},
+		nil,
+	},
+	// Declare retry queues and parking lot without declaring the queue.
+	{
+		"retryQueuesNoDeclare",
+		retryNoDeclareConfig,
+		func(ch *TestChannel) {
+			ch.On("Qos", 3, 0, false).Return(nil).Once()
+			ch.On("QueueDeclare", "retryQueue.retry.10s", true, false, false, false, amqp.Table{"x-message-ttl": int64(10000), "x-dead-letter-exchange": "", "x-dead-letter-routing-key": "retryQueue"}).Return(amqp.Queue{}, nil).Once()
+			ch.On("QueueDeclare", "parkedQueue", true, false, false, false, emptyAmqpTable).Return(amqp.Queue{}, nil).Once()
+		},
+		nil,
+	},
+	// Declare retry queue fails.
+	{
+		"retryQueueFail",
+		retryConfig,
+		func(ch *TestChannel) {
+			ch.On("Qos", 3, 0, false).Return(nil).Once()
+			ch.On("QueueDeclare", "retryQueue", true, false, false, false, emptyAmqpTable).Return(amqp.Queue{}, nil).Once()
+			ch.On("QueueDeclare", "retryQueue.retry.10s", true, false, false, false, amqp.Table{"x-message-ttl": int64(10000), "x-dead-letter-exchange": "", "x-dead-letter-routing-key": "retryQueue"}).Return(amqp.Queue{}, fmt.Errorf("queue error")).Once()
+		},
+		fmt.Errorf("failed to declare retry queue: queue error"),
+	},
 	// Set QoS.
 	{
 		"setQos",
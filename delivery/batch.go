@@ -0,0 +1,149 @@
+package delivery
+
+import (
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// Batcher is an amqp.Acknowledger coalescing the positive acknowledgements of contiguous deliveries into a single
+// acknowledgement of multiple deliveries.
+//
+// Acknowledgements are held back until either the number of acknowledged deliveries reaches the configured size or the
+// configured interval has passed. Only deliveries up to the lowest delivery tag which is still being processed are
+// acknowledged. Negative acknowledgements and rejects are passed on immediately.
+type Batcher struct {
+	size     int
+	interval time.Duration
+	mu       sync.Mutex
+	ack      amqp.Acknowledger
+	pending  []uint64
+	acked    map[uint64]bool
+	timer    *time.Timer
+}
+
+// NewBatcher creates a new Batcher flushing after size acknowledgements or after interval, whichever comes first.
+func NewBatcher(size int, interval time.Duration) *Batcher {
+	return &Batcher{
+		size:     size,
+		interval: interval,
+		acked:    make(map[uint64]bool),
+	}
+}
+
+// Track registers the deliveries read from msgs with the batcher and passes them on to the returned channel. The
+// deliveries must all originate from the same AMQP channel.
+func (b *Batcher) Track(msgs <-chan amqp.Delivery) <-chan amqp.Delivery {
+	out := make(chan amqp.Delivery)
+	go func() {
+		defer close(out)
+		for m := range msgs {
+			b.mu.Lock()
+			b.ack = m.Acknowledger
+			b.pending = append(b.pending, m.DeliveryTag)
+			b.mu.Unlock()
+
+			m.Acknowledger = b
+			out <- m
+		}
+	}()
+
+	return out
+}
+
+// Ack is part of amqp.Acknowledger.
+func (b *Batcher) Ack(tag uint64, multiple bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if multiple {
+		b.settle(tag, true)
+		return b.ack.Ack(tag, true)
+	}
+
+	b.acked[tag] = true
+	if len(b.acked) >= b.size {
+		return b.flush()
+	}
+
+	b.schedule()
+
+	return nil
+}
+
+// Nack is part of amqp.Acknowledger.
+func (b *Batcher) Nack(tag uint64, multiple bool, requeue bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.settle(tag, multiple)
+
+	return b.ack.Nack(tag, multiple, requeue)
+}
+
+// Reject is part of amqp.Acknowledger.
+func (b *Batcher) Reject(tag uint64, requeue bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.settle(tag, false)
+
+	return b.ack.Reject(tag, requeue)
+}
+
+// Flush sends the acknowledgements held back so far.
+func (b *Batcher) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.flush()
+}
+
+// flush acknowledges the longest run of acknowledged deliveries starting with the lowest pending delivery tag.
+func (b *Batcher) flush() error {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+
+	n := 0
+	for n < len(b.pending) && b.acked[b.pending[n]] {
+		n++
+	}
+
+	var err error
+	if n > 0 {
+		tag := b.pending[n-1]
+		b.settle(tag, true)
+		err = b.ack.Ack(tag, n > 1)
+	}
+
+	// Acknowledgements blocked by a delivery still being processed, are flushed later on.
+	b.schedule()
+
+	return err
+}
+
+// schedule starts the timer flushing the held back acknowledgements, unless there are none or it is already running.
+func (b *Batcher) schedule() {
+	if len(b.acked) > 0 && b.timer == nil {
+		b.timer = time.AfterFunc(b.interval, func() {
+			b.Flush()
+		})
+	}
+}
+
+// settle removes the given delivery tag, or all delivery tags up to the given one if multiple is set, from the list of
+// pending deliveries.
+func (b *Batcher) settle(tag uint64, multiple bool) {
+	pending := b.pending[:0]
+	for _, t := range b.pending {
+		if t == tag || (multiple && t < tag) {
+			delete(b.acked, t)
+			continue
+		}
+		pending = append(pending, t)
+	}
+	b.pending = pending
+}
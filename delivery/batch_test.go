@@ -0,0 +1,130 @@
+package delivery_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var batcherTests = []struct {
+	name  string
+	size  int
+	count uint64
+	setup func(a *TestAcknowledger)
+	call  func(dd []delivery.Delivery)
+}{
+	{
+		"contiguous",
+		3,
+		3,
+		func(a *TestAcknowledger) {
+			a.On("Ack", uint64(3), true).Once().Return(nil)
+		},
+		func(dd []delivery.Delivery) {
+			dd[0].Ack()
+			dd[1].Ack()
+			dd[2].Ack()
+		},
+	},
+	{
+		"single",
+		1,
+		1,
+		func(a *TestAcknowledger) {
+			a.On("Ack", uint64(1), false).Once().Return(nil)
+		},
+		func(dd []delivery.Delivery) {
+			dd[0].Ack()
+		},
+	},
+	{
+		"blocked by running",
+		2,
+		3,
+		func(a *TestAcknowledger) {
+			a.On("Ack", uint64(3), true).Once().Return(nil)
+		},
+		func(dd []delivery.Delivery) {
+			dd[1].Ack()
+			dd[2].Ack()
+			dd[0].Ack()
+		},
+	},
+	{
+		"nack passed on",
+		2,
+		3,
+		func(a *TestAcknowledger) {
+			a.On("Nack", uint64(2), false, true).Once().Return(nil)
+			a.On("Ack", uint64(3), true).Once().Return(nil)
+		},
+		func(dd []delivery.Delivery) {
+			dd[1].Nack(true)
+			dd[0].Ack()
+			dd[2].Ack()
+		},
+	},
+	{
+		"reject passed on",
+		3,
+		2,
+		func(a *TestAcknowledger) {
+			a.On("Reject", uint64(1), false).Once().Return(nil)
+			a.On("Ack", uint64(2), false).Once().Return(nil)
+		},
+		func(dd []delivery.Delivery) {
+			dd[0].Reject(false)
+			dd[1].Ack()
+		},
+	},
+}
+
+func TestBatcher(t *testing.T) {
+	for _, test := range batcherTests {
+		t.Run(test.name, func(t *testing.T) {
+			a := &TestAcknowledger{}
+			test.setup(a)
+			b := delivery.NewBatcher(test.size, time.Hour)
+			dd := track(b, a, test.count)
+			test.call(dd)
+			b.Flush()
+			a.AssertExpectations(t)
+		})
+	}
+}
+
+func TestBatcher_Interval(t *testing.T) {
+	a := &TestAcknowledger{}
+	flushed := make(chan bool)
+	a.On("Ack", uint64(1), false).Once().Return(nil).Run(func(_ mock.Arguments) {
+		flushed <- true
+	})
+	b := delivery.NewBatcher(10, time.Millisecond)
+	dd := track(b, a, 1)
+	assert.Nil(t, dd[0].Ack())
+	select {
+	case <-flushed:
+		// Intentionally left blank.
+	case <-time.After(5 * time.Second):
+		t.Error("Timeout because acknowledgement was not flushed")
+	}
+	a.AssertExpectations(t)
+}
+
+// track passes count deliveries through the batcher.
+func track(b *delivery.Batcher, a amqp.Acknowledger, count uint64) []delivery.Delivery {
+	msgs := make(chan amqp.Delivery)
+	out := b.Track(msgs)
+	dd := make([]delivery.Delivery, count)
+	for i := uint64(0); i < count; i++ {
+		msgs <- amqp.Delivery{Acknowledger: a, DeliveryTag: i + 1}
+		dd[i] = delivery.New(<-out)
+	}
+	close(msgs)
+
+	return dd
+}
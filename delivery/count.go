@@ -0,0 +1,115 @@
+package delivery
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+// Count returns the number of times the message has been delivered from the queue according to its headers, including
+// the current delivery. It takes the header x-delivery-count, set by quorum queues, and the x-death history of dead
+// lettered messages into account. Of the latter, only the rejections from the queue count. Messages expiring from
+// another queue, like a retry queue, have not been delivered in between.
+func Count(p Properties, queue string) int {
+	count := 1
+	if n, ok := toInt(p.Headers["x-delivery-count"]); ok && n+1 > count {
+		count = n + 1
+	}
+
+	if deaths, ok := p.Headers["x-death"].([]interface{}); ok {
+		var n int
+		for _, v := range deaths {
+			if death, ok := v.(amqp.Table); ok && death["reason"] == "rejected" && death["queue"] == queue {
+				c, _ := toInt(death["count"])
+				n += c
+			}
+		}
+		if n+1 > count {
+			count = n + 1
+		}
+	}
+
+	return count
+}
+
+// Key returns the key identifying a message, which is its message ID or, if not set, the hash of its body.
+func Key(d Delivery) string {
+	if id := d.Properties().MessageID; id != "" {
+		return id
+	}
+
+	sum := sha256.Sum256(d.Body())
+	return hex.EncodeToString(sum[:])
+}
+
+// Tracker counts the deliveries of messages locally, for queues not providing the count by themselves. It keeps track
+// of a limited number of messages, forgetting the oldest ones first.
+type Tracker struct {
+	mu     sync.Mutex
+	size   int
+	counts map[string]int
+	keys   []string
+	next   int
+}
+
+// NewTracker creates a new tracker keeping track of up to size messages.
+func NewTracker(size int) *Tracker {
+	if size < 1 {
+		size = 1
+	}
+
+	return &Tracker{size: size, counts: make(map[string]int, size)}
+}
+
+// Track records the delivery of the message and returns the number of times it has been delivered. As long as the
+// broker does not flag the message as redelivered, it is considered to be delivered for the first time.
+func (t *Tracker) Track(d Delivery) int {
+	key := Key(d)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	count, known := t.counts[key]
+	if !d.Info().Redelivered {
+		count = 0
+	}
+	count++
+
+	if !known {
+		t.add(key)
+	}
+	t.counts[key] = count
+
+	return count
+}
+
+// add adds a key, evicting the oldest one once the tracker is full.
+func (t *Tracker) add(key string) {
+	if len(t.keys) < t.size {
+		t.keys = append(t.keys, key)
+		return
+	}
+
+	delete(t.counts, t.keys[t.next])
+	t.keys[t.next] = key
+	t.next = (t.next + 1) % t.size
+}
+
+func toInt(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case int8:
+		return int(n), true
+	case int16:
+		return int(n), true
+	case int32:
+		return int(n), true
+	case int64:
+		return int(n), true
+	case int:
+		return n, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,61 @@
+package delivery_test
+
+import (
+	"testing"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+var countTests = []struct {
+	name    string
+	headers amqp.Table
+	count   int
+}{
+	{"none", nil, 1},
+	{"deliveryCount", amqp.Table{"x-delivery-count": int64(3)}, 4},
+	{"death", amqp.Table{"x-death": []interface{}{
+		amqp.Table{"count": int64(2), "reason": "rejected", "queue": "mail"},
+	}}, 3},
+	{"mixedDeaths", amqp.Table{"x-death": []interface{}{
+		amqp.Table{"count": int64(2), "reason": "expired", "queue": "mail.retry.10s"},
+		amqp.Table{"count": int64(2), "reason": "rejected", "queue": "mail"},
+		amqp.Table{"count": int64(1), "reason": "expired", "queue": "mail"},
+		amqp.Table{"count": int64(3), "reason": "rejected", "queue": "letters"},
+	}}, 3},
+	{"larger", amqp.Table{"x-delivery-count": int32(5), "x-death": []interface{}{amqp.Table{"count": int64(2), "reason": "rejected", "queue": "mail"}}}, 6},
+}
+
+func TestCount(t *testing.T) {
+	for _, test := range countTests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.count, delivery.Count(delivery.Properties{Headers: test.headers}, "mail"))
+		})
+	}
+}
+
+func TestKey(t *testing.T) {
+	assert.Equal(t, "42", delivery.Key(delivery.New(amqp.Delivery{MessageId: "42", Body: []byte("foo")})))
+	assert.Equal(t, "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", delivery.Key(delivery.New(amqp.Delivery{Body: []byte("foo")})))
+}
+
+func TestTracker_Track(t *testing.T) {
+	tr := delivery.NewTracker(2)
+	a := delivery.New(amqp.Delivery{MessageId: "a"})
+	aAgain := delivery.New(amqp.Delivery{MessageId: "a", Redelivered: true})
+	b := delivery.New(amqp.Delivery{MessageId: "b"})
+	c := delivery.New(amqp.Delivery{MessageId: "c"})
+
+	assert.Equal(t, 1, tr.Track(a))
+	assert.Equal(t, 2, tr.Track(aAgain))
+	assert.Equal(t, 3, tr.Track(aAgain))
+
+	// Not redelivered, hence a new message with the same ID.
+	assert.Equal(t, 1, tr.Track(a))
+
+	// Tracking b and c evicts a.
+	assert.Equal(t, 1, tr.Track(b))
+	assert.Equal(t, 1, tr.Track(c))
+	assert.Equal(t, 1, tr.Track(aAgain))
+}
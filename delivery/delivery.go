@@ -23,14 +23,14 @@ type delivery struct {
 	d amqp.Delivery
 }
 
-// Ack acknowledges the message.
+// Ack acknowledges the message. Other deliveries on the same channel are not affected.
 func (r delivery) Ack() error {
-	return r.d.Ack(true)
+	return r.d.Ack(false)
 }
 
-// Nack negatively acknowledges the message.
+// Nack negatively acknowledges the message. Other deliveries on the same channel are not affected.
 func (r delivery) Nack(requeue bool) error {
-	return r.d.Nack(true, requeue)
+	return r.d.Nack(false, requeue)
 }
 
 // Reject rejects the message.
@@ -22,7 +22,7 @@ var ackTests = []struct {
 		"ack",
 		"Ack",
 		3,
-		[]interface{}{true},
+		[]interface{}{false},
 		nil,
 		func(d delivery.Delivery) error { return d.Ack() },
 	},
@@ -30,7 +30,7 @@ var ackTests = []struct {
 		"ackError",
 		"Ack",
 		7,
-		[]interface{}{true},
+		[]interface{}{false},
 		fmt.Errorf("ack"),
 		func(d delivery.Delivery) error { return d.Ack() },
 	},
@@ -38,7 +38,7 @@ var ackTests = []struct {
 		"nack",
 		"Nack",
 		11,
-		[]interface{}{true, false},
+		[]interface{}{false, false},
 		nil,
 		func(d delivery.Delivery) error { return d.Nack(false) },
 	},
@@ -46,7 +46,7 @@ var ackTests = []struct {
 		"nackRequeue",
 		"Nack",
 		17,
-		[]interface{}{true, true},
+		[]interface{}{false, true},
 		nil,
 		func(d delivery.Delivery) error { return d.Nack(true) },
 	},
@@ -54,7 +54,7 @@ var ackTests = []struct {
 		"nackError",
 		"Nack",
 		19,
-		[]interface{}{true, true},
+		[]interface{}{false, true},
 		fmt.Errorf("nack"),
 		func(d delivery.Delivery) error { return d.Nack(true) },
 	},
@@ -41,3 +41,28 @@ func NewProperties(d amqp.Delivery) Properties {
 		UserID:          d.UserId,
 	}
 }
+
+// Publishing creates a message with the given body and these properties, e.g. to republish a received message. The
+// user ID is omitted as the broker rejects messages whose user ID does not match the user of the connection.
+func (p Properties) Publishing(body []byte) amqp.Publishing {
+	headers := make(amqp.Table, len(p.Headers))
+	for k, v := range p.Headers {
+		headers[k] = v
+	}
+
+	return amqp.Publishing{
+		Headers:         headers,
+		ContentType:     p.ContentType,
+		ContentEncoding: p.ContentEncoding,
+		DeliveryMode:    p.DeliveryMode,
+		Priority:        p.Priority,
+		CorrelationId:   p.CorrelationID,
+		ReplyTo:         p.ReplyTo,
+		Expiration:      p.Expiration,
+		MessageId:       p.MessageID,
+		Timestamp:       p.Timestamp,
+		Type:            p.Type,
+		AppId:           p.AppID,
+		Body:            body,
+	}
+}
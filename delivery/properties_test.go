@@ -0,0 +1,39 @@
+package delivery_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProperties_Publishing(t *testing.T) {
+	ts := time.Date(2018, 4, 1, 12, 0, 0, 0, time.UTC)
+	p := delivery.NewProperties(amqp.Delivery{
+		Headers:       amqp.Table{"foo": "bar"},
+		ContentType:   "text/plain",
+		DeliveryMode:  amqp.Persistent,
+		CorrelationId: "123",
+		ReplyTo:       "replies",
+		MessageId:     "abc",
+		Timestamp:     ts,
+		UserId:        "guest",
+	})
+
+	msg := p.Publishing([]byte("body"))
+	msg.Headers["baz"] = "qux"
+
+	assert.Equal(t, amqp.Publishing{
+		Headers:       amqp.Table{"foo": "bar", "baz": "qux"},
+		ContentType:   "text/plain",
+		DeliveryMode:  amqp.Persistent,
+		CorrelationId: "123",
+		ReplyTo:       "replies",
+		MessageId:     "abc",
+		Timestamp:     ts,
+		Body:          []byte("body"),
+	}, msg)
+	assert.Equal(t, amqp.Table{"foo": "bar"}, p.Headers)
+}
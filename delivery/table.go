@@ -0,0 +1,41 @@
+package delivery
+
+import (
+	"encoding/json"
+
+	"github.com/streadway/amqp"
+)
+
+// NewTable converts JSON decoded using json.Decoder.UseNumber into values accepted as AMQP headers. Integral numbers
+// become int64, all other numbers float64 and nested objects tables.
+func NewTable(m map[string]interface{}) amqp.Table {
+	t := make(amqp.Table, len(m))
+	for k, v := range m {
+		t[k] = tableValue(v)
+	}
+
+	return t
+}
+
+func tableValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		f, _ := t.Float64()
+		return f
+
+	case map[string]interface{}:
+		return NewTable(t)
+
+	case []interface{}:
+		for i := range t {
+			t[i] = tableValue(t[i])
+		}
+		return t
+
+	default:
+		return v
+	}
+}
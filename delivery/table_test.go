@@ -0,0 +1,28 @@
+package delivery_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTable(t *testing.T) {
+	var m map[string]interface{}
+	dec := json.NewDecoder(strings.NewReader(`{"int":42,"float":1.5,"string":"foo","list":[1,"bar"],"nested":{"bool":true}}`))
+	dec.UseNumber()
+	if err := dec.Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, amqp.Table{
+		"int":    int64(42),
+		"float":  1.5,
+		"string": "foo",
+		"list":   []interface{}{int64(1), "bar"},
+		"nested": amqp.Table{"bool": true},
+	}, delivery.NewTable(m))
+}
@@ -0,0 +1,224 @@
+package fastcgi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Record types, see https://fastcgi-archives.github.io/FastCGI_Specification.html.
+const (
+	typeBeginRequest = 1
+	typeEndRequest   = 3
+	typeParams       = 4
+	typeStdin        = 5
+	typeStdout       = 6
+	typeStderr       = 7
+)
+
+const (
+	version       = 1
+	roleResponder = 1
+	// requestID is the ID of the only request sent per connection.
+	requestID = 1
+	// maxContent is the maximum length of the content of a single record.
+	maxContent = 65535
+	// statusRequestComplete is the protocol status of a request being processed.
+	statusRequestComplete = 0
+)
+
+// Response is the response of a FastCGI responder, parsed like the output of a CGI script.
+type Response struct {
+	// Status is the HTTP status of the response, taken from the Status header. Defaults to 200.
+	Status int
+	Header http.Header
+	Body   []byte
+	// Stderr holds what the responder wrote to its error stream.
+	Stderr []byte
+}
+
+// ParseAddress splits the address of a responder into network and address. Addresses prefixed by "unix:" or being an
+// absolute path refer to a unix domain socket, all others to a TCP address like "127.0.0.1:9000".
+func ParseAddress(s string) (string, string) {
+	if strings.HasPrefix(s, "unix:") {
+		return "unix", strings.TrimPrefix(s, "unix:")
+	}
+
+	if strings.HasPrefix(s, "/") {
+		return "unix", s
+	}
+
+	return "tcp", s
+}
+
+// Do sends a request with the params and the body to the responder on the other end of the connection and reads its
+// response. The body of the response is limited to max bytes, the rest is discarded. The connection can not be reused
+// afterwards.
+func Do(conn io.ReadWriter, params map[string]string, body []byte, max int) (*Response, error) {
+	w := bufio.NewWriter(conn)
+	// The role followed by the flags, not keeping the connection open, and five reserved bytes.
+	if err := writeRecord(w, typeBeginRequest, []byte{0, roleResponder, 0, 0, 0, 0, 0, 0}); err != nil {
+		return nil, err
+	}
+	if err := writeStream(w, typeParams, encodeParams(params)); err != nil {
+		return nil, err
+	}
+	if err := writeStream(w, typeStdin, body); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	stdout, stderr, err := readResponse(bufio.NewReader(conn), max)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := parseResponse(stdout)
+	if err != nil {
+		return nil, err
+	}
+	res.Stderr = stderr
+
+	return res, nil
+}
+
+// writeStream writes the content as records of the given type, terminated by an empty record.
+func writeStream(w io.Writer, typ byte, content []byte) error {
+	for len(content) > 0 {
+		n := len(content)
+		if n > maxContent {
+			n = maxContent
+		}
+		if err := writeRecord(w, typ, content[:n]); err != nil {
+			return err
+		}
+		content = content[n:]
+	}
+
+	return writeRecord(w, typ, nil)
+}
+
+// writeRecord writes a single record, padding its content to a multiple of eight bytes.
+func writeRecord(w io.Writer, typ byte, content []byte) error {
+	padding := -len(content) & 7
+	h := [8]byte{version, typ}
+	binary.BigEndian.PutUint16(h[2:], requestID)
+	binary.BigEndian.PutUint16(h[4:], uint16(len(content)))
+	h[6] = byte(padding)
+
+	if _, err := w.Write(h[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(content); err != nil {
+		return err
+	}
+
+	_, err := w.Write(make([]byte, padding))
+	return err
+}
+
+// encodeParams encodes the params as name-value pairs, sorted by name.
+func encodeParams(params map[string]string) []byte {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		writeLength(&buf, len(name))
+		writeLength(&buf, len(params[name]))
+		buf.WriteString(name)
+		buf.WriteString(params[name])
+	}
+
+	return buf.Bytes()
+}
+
+// writeLength writes the length of a name or value, using one byte for lengths up to 127 and four bytes otherwise.
+func writeLength(buf *bytes.Buffer, n int) {
+	if n < 128 {
+		buf.WriteByte(byte(n))
+		return
+	}
+
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(n)|1<<31)
+	buf.Write(b[:])
+}
+
+// readResponse reads the records sent by the responder until the request ended.
+func readResponse(r io.Reader, max int) ([]byte, []byte, error) {
+	var stdout, stderr bytes.Buffer
+	for {
+		var h [8]byte
+		if _, err := io.ReadFull(r, h[:]); err != nil {
+			return nil, nil, fmt.Errorf("failed to read response: %w", err)
+		}
+
+		content := make([]byte, int(binary.BigEndian.Uint16(h[4:]))+int(h[6]))
+		if _, err := io.ReadFull(r, content); err != nil {
+			return nil, nil, fmt.Errorf("failed to read response: %w", err)
+		}
+		content = content[:binary.BigEndian.Uint16(h[4:])]
+
+		switch h[1] {
+		case typeStdout:
+			if n := max - stdout.Len(); n < len(content) {
+				content = content[:n]
+			}
+			stdout.Write(content)
+
+		case typeStderr:
+			if n := max - stderr.Len(); n < len(content) {
+				content = content[:n]
+			}
+			stderr.Write(content)
+
+		case typeEndRequest:
+			if len(content) < 5 {
+				return nil, nil, fmt.Errorf("malformed end of request")
+			}
+			if content[4] != statusRequestComplete {
+				return nil, nil, fmt.Errorf("request rejected with protocol status %d", content[4])
+			}
+
+			return stdout.Bytes(), stderr.Bytes(), nil
+		}
+	}
+}
+
+// parseResponse parses the output of the responder, consisting of the headers followed by the body.
+func parseResponse(b []byte) (*Response, error) {
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(b)))
+	h, err := r.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("malformed response: %v", err)
+	}
+
+	res := &Response{Status: http.StatusOK, Header: http.Header(h)}
+	if s := res.Header.Get("Status"); s != "" {
+		code := strings.SplitN(strings.TrimSpace(s), " ", 2)[0]
+		if res.Status, err = strconv.Atoi(code); err != nil {
+			return nil, fmt.Errorf("malformed status %q", s)
+		}
+		res.Header.Del("Status")
+	}
+
+	if res.Body, err = ioutil.ReadAll(r.R); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
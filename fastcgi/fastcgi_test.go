@@ -0,0 +1,101 @@
+package fastcgi_test
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"strings"
+	"testing"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/fastcgi"
+	"github.com/stretchr/testify/assert"
+)
+
+func serve(t *testing.T, h http.HandlerFunc) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go fcgi.Serve(l, h)
+
+	return l
+}
+
+var params = map[string]string{
+	"REQUEST_METHOD":   "POST",
+	"SERVER_PROTOCOL":  "HTTP/1.1",
+	"REQUEST_URI":      "/consumer.php",
+	"SCRIPT_FILENAME":  "/var/www/consumer.php",
+	"AMQP_ROUTING_KEY": "mail.send",
+	"AMQP_HEADER_LONG": strings.Repeat("x", 300),
+}
+
+func TestDo(t *testing.T) {
+	body := []byte(strings.Repeat("body", 20000))
+	l := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		env := fcgi.ProcessEnv(r)
+		assert.Equal(t, "mail.send", env["AMQP_ROUTING_KEY"])
+		assert.Equal(t, params["AMQP_HEADER_LONG"], env["AMQP_HEADER_LONG"])
+		assert.Equal(t, "/var/www/consumer.php", env["SCRIPT_FILENAME"])
+		b, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, body, b)
+
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("busy"))
+	})
+	defer l.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	res, err := fastcgi.Do(conn, params, body, 1024)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, res.Status)
+	assert.Equal(t, "30", res.Header.Get("Retry-After"))
+	assert.Empty(t, res.Header.Get("Status"))
+	assert.Equal(t, "busy", string(res.Body))
+}
+
+func TestDo_Limit(t *testing.T) {
+	l := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 2048)))
+	})
+	defer l.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	res, err := fastcgi.Do(conn, params, nil, 1024)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.Status)
+	assert.True(t, len(res.Body) < 1024)
+}
+
+var addressTests = []struct {
+	address string
+	network string
+	want    string
+}{
+	{"127.0.0.1:9000", "tcp", "127.0.0.1:9000"},
+	{"php-fpm:9000", "tcp", "php-fpm:9000"},
+	{"/run/php/php-fpm.sock", "unix", "/run/php/php-fpm.sock"},
+	{"unix:/run/php/php-fpm.sock", "unix", "/run/php/php-fpm.sock"},
+}
+
+func TestParseAddress(t *testing.T) {
+	for _, test := range addressTests {
+		t.Run(test.address, func(t *testing.T) {
+			network, address := fastcgi.ParseAddress(test.address)
+			assert.Equal(t, test.network, network)
+			assert.Equal(t, test.want, address)
+		})
+	}
+}
@@ -5,36 +5,80 @@ import (
 	"io"
 	stdlog "log"
 	"os"
+	"sync"
 
 	"github.com/bketelsen/logr"
 	log "github.com/corvus-ch/logr/std"
 	"github.com/corvus-ch/rabbitmq-cli-consumer/config"
 )
 
-// NewFromConfig crates a logger according to the given config.
-func NewFromConfig(cfg *config.Config) (logr.Logger, io.Writer, io.Writer, error) {
+// NewFromConfig crates a logger according to the given config. The returned logs allow to change the log settings
+// while the logger is in use.
+func NewFromConfig(cfg *config.Config) (logr.Logger, *Logs, error) {
 	errW, err := newWriter(cfg.Logs.Error, cfg.IsVerbose(), os.Stderr)
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("failed creating error log: %s", err)
+		return nil, nil, fmt.Errorf("failed creating error log: %s", err)
 	}
 
 	outW, err := newWriter(cfg.Logs.Info, cfg.IsVerbose(), os.Stdout)
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("failed creating info log: %s", err)
+		errW.Close()
+		return nil, nil, fmt.Errorf("failed creating info log: %s", err)
 	}
 
 	infL := stdlog.New(outW, "", flag(cfg.WithDateTime()))
 	errL := stdlog.New(errW, "", flag(cfg.WithDateTime()))
 
-	return log.New(0, errL, infL), outW, errW, nil
+	return log.New(0, errL, infL), &Logs{Info: outW, Error: errW, loggers: []*stdlog.Logger{infL, errL}}, nil
+}
+
+// Logs holds the writers of a logger created by NewFromConfig.
+type Logs struct {
+	// Info and Error are the writers of the info and the error log.
+	Info  *Writer
+	Error *Writer
+
+	loggers []*stdlog.Logger
+}
+
+// Apply changes the log settings to the ones of the configuration. The log files are replaced by the ones configured,
+// closing the previous ones. Nothing is changed if any of the log files can not be opened.
+func (l *Logs) Apply(cfg *config.Config) error {
+	errW, err := newWriter(cfg.Logs.Error, cfg.IsVerbose(), os.Stderr)
+	if err != nil {
+		return fmt.Errorf("failed creating error log: %s", err)
+	}
+
+	outW, err := newWriter(cfg.Logs.Info, cfg.IsVerbose(), os.Stdout)
+	if err != nil {
+		errW.Close()
+		return fmt.Errorf("failed creating info log: %s", err)
+	}
+
+	l.Error.swap(errW)
+	l.Info.swap(outW)
+	for _, logger := range l.loggers {
+		logger.SetFlags(flag(cfg.WithDateTime()))
+	}
+
+	return nil
+}
+
+// Close closes the log files.
+func (l *Logs) Close() error {
+	l.Error.Close()
+
+	return l.Info.Close()
 }
 
 // newWriter creates a new writer for the given file.
 // If verbose is set to true, in addition to the file, the logger will also write to writer passed as the out argument.
-func newWriter(filename string, verbose bool, out io.Writer) (io.Writer, error) {
+func newWriter(filename string, verbose bool, out io.Writer) (*Writer, error) {
+	var file *os.File
 	writers := make([]io.Writer, 0)
 	if len(filename) > 0 || !verbose {
-		file, err := os.OpenFile(filename, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0660)
+		var err error
+		file, err = os.OpenFile(filename, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0660)
 
 		if err != nil {
 			return nil, err
@@ -47,7 +91,46 @@ func newWriter(filename string, verbose bool, out io.Writer) (io.Writer, error)
 		writers = append(writers, out)
 	}
 
-	return io.MultiWriter(writers...), nil
+	return &Writer{w: io.MultiWriter(writers...), file: file}, nil
+}
+
+// Writer writes to the log file, if any, and to the output in verbose mode. Closing it closes the file, but not the
+// output.
+type Writer struct {
+	mu   sync.Mutex
+	w    io.Writer
+	file *os.File
+}
+
+// Write is part of io.Writer.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.w.Write(p)
+}
+
+// Close is part of io.Closer.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+
+	return w.file.Close()
+}
+
+// swap makes the writer write to where n writes to and closes the previous file.
+func (w *Writer) swap(n *Writer) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file != nil {
+		w.file.Close()
+	}
+	w.w, w.file = n.w, n.file
 }
 
 func flag(dateTime bool) int {
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"reflect"
 	"syscall"
 	"testing"
@@ -112,7 +113,7 @@ func TestLoggers(t *testing.T) {
 	for _, test := range loggersTests {
 		t.Run(test.name, func(t *testing.T) {
 			cfg, _ := config.CreateFromString(test.config)
-			l, _, _, err := NewFromConfig(cfg)
+			l, _, err := NewFromConfig(cfg)
 			assert.Equal(t, test.err, err)
 			if l != nil {
 				ll := reflect.ValueOf(l).Elem().FieldByName("loggers")
@@ -124,6 +125,66 @@ func TestLoggers(t *testing.T) {
 	}
 }
 
+func TestLogs_Apply(t *testing.T) {
+	if _, err := ioutil.ReadDir("/proc/self/fd"); err != nil {
+		t.Skip("open files can not be listed")
+	}
+
+	dir, err := ioutil.TempDir("", "logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	l, logs, err := NewFromConfig(logsConfig(t, dir, "initial"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logs.Close()
+	info, n := logs.Info, openFiles(t)
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, logs.Apply(logsConfig(t, dir, fmt.Sprintf("%d", i))))
+		l.Infof("Applied %d", i)
+	}
+
+	assert.Equal(t, info, logs.Info)
+	assert.Equal(t, n, openFiles(t))
+	b, err := ioutil.ReadFile(filepath.Join(dir, "9.info.log"))
+	assert.Nil(t, err)
+	assert.Equal(t, "Applied 9\n", string(b))
+
+	assert.Error(t, logs.Apply(logsConfig(t, filepath.Join(dir, "missing"), "10")))
+	l.Info("Kept")
+	b, err = ioutil.ReadFile(filepath.Join(dir, "9.info.log"))
+	assert.Nil(t, err)
+	assert.Equal(t, "Applied 9\nKept\n", string(b))
+}
+
+// logsConfig creates a configuration logging to the files with the given prefix, without date and time.
+func logsConfig(t *testing.T, dir, prefix string) *config.Config {
+	cfg, err := config.CreateFromString(fmt.Sprintf(
+		"[logs]\ninfo = %s\nerror = %s\nnodatetime = On\n",
+		filepath.Join(dir, prefix+".info.log"),
+		filepath.Join(dir, prefix+".error.log"),
+	))
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	return cfg
+}
+
+// openFiles counts the file descriptors of the process.
+func openFiles(t *testing.T) int {
+	files, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return len(files)
+}
+
 func createWriter(name string, verbose bool) (io.Writer, *os.File, *bytes.Buffer, error) {
 	buf := &bytes.Buffer{}
 
@@ -3,11 +3,14 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	stdlog "log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -36,7 +39,7 @@ var (
 var flags []cli.Flag = []cli.Flag{
 	cli.StringFlag{
 		Name:   "url, u",
-		Usage:  "Connect with RabbitMQ using `URL`",
+		Usage:  "Connect with RabbitMQ using `URL`. Separate the URLs of several cluster nodes by comma",
 		EnvVar: "AMQP_URL",
 	},
 	cli.StringFlag{
@@ -63,6 +66,18 @@ var flags []cli.Flag = []cli.Flag{
 		Name:  "include, i",
 		Usage: "Include metadata. Passes message as JSON data including headers, properties and message body. This flag will be ignored when `-pipe` is used.",
 	},
+	cli.BoolFlag{
+		Name:  "response",
+		Usage: "Enable the executable to write a JSON response to fd4, taking precedence over its exit code. Requires `-pipe`.",
+	},
+	cli.BoolFlag{
+		Name:  "rpc",
+		Usage: "Publish the output of the executable as reply to the queue named by the reply_to property of the message.",
+	},
+	cli.StringFlag{
+		Name:  "publish",
+		Usage: "Publish the messages the executable writes as JSON lines to `SOURCE`, which is either stdout or fd (fd5).",
+	},
 	cli.BoolFlag{
 		Name:  "strict-exit-code",
 		Usage: "Strict exit code processing will rise a fatal error if exit code is different from allowed onces.",
@@ -71,6 +86,59 @@ var flags []cli.Flag = []cli.Flag{
 		Name:  "queue-name, q",
 		Usage: "Optional queue name to which can be passed in, without needing to define it in config, if set will override config queue name",
 	},
+	cli.IntFlag{
+		Name:  "concurrency",
+		Usage: "Number of messages to be processed in parallel. If set, will override config concurrency",
+	},
+	cli.IntFlag{
+		Name:  "batch-size",
+		Usage: "Pass up to `N` messages at once to the executable, as JSON Lines via STDIN. If set, will override config batch size",
+	},
+	cli.DurationFlag{
+		Name:  "batch-timeout",
+		Usage: "Maximum `DURATION` to wait for a batch to be completed. If set, will override config batch timeout",
+	},
+	cli.StringFlag{
+		Name:  "webhook",
+		Usage: "POST the messages to `URL` instead of running an executable. The HTTP status determines the acknowledgement.",
+	},
+	cli.StringFlag{
+		Name:  "fastcgi",
+		Usage: "Pass the messages to the FastCGI responder at `ADDRESS`, e.g. PHP-FPM, instead of running an executable. Either host:port or the path of a unix socket.",
+	},
+	cli.StringFlag{
+		Name:  "fastcgi-script",
+		Usage: "The `PATH` of the script the FastCGI responder runs for each message.",
+	},
+	cli.StringFlag{
+		Name:  "socket",
+		Usage: "Pass the messages to the daemon listening on the unix socket at `PATH` instead of running an executable.",
+	},
+	cli.StringFlag{
+		Name:  "gateway",
+		Usage: "Hand the messages out to HTTP clients polling `ADDRESS`, e.g. 127.0.0.1:8080, instead of running an executable.",
+	},
+	cli.BoolFlag{
+		Name:  "worker",
+		Usage: "Keep the executable running as worker, passing it one message after the other as frames via STDIN.",
+	},
+	cli.IntFlag{
+		Name:  "max-messages",
+		Usage: "Exit with code 12 after `N` messages have been processed.",
+	},
+	cli.DurationFlag{
+		Name:  "max-lifetime",
+		Usage: "Exit with code 12 once the consumer has been running for `DURATION`, e.g. 1h.",
+	},
+	cli.BoolFlag{
+		Name:  "exit-when-idle, drain",
+		Usage: "Process messages until the queue is empty and no message arrived for the idle period, then exit.",
+	},
+	cli.DurationFlag{
+		Name:  "idle-period, drain-idle",
+		Usage: "The idle `DURATION` after which the consumer exits when started with --exit-when-idle.",
+		Value: 5 * time.Second,
+	},
 	cli.BoolFlag{
 		Name:  "no-datetime",
 		Usage: "prevents the output of date and time in the logs.",
@@ -97,6 +165,26 @@ var flags []cli.Flag = []cli.Flag{
 
 var ll logr.Logger
 
+// gateways holds the gateways by the address they are served at. They are kept serving across reloads of the
+// configuration, so clients can settle the messages leased before, until the last processor using them got closed.
+var (
+	gateways = make(map[string]*servedGateway)
+	// gatewayUsers holds the address of the gateway used by each processor.
+	gatewayUsers = make(map[processor.Processor]string)
+	gatewaysMu   sync.Mutex
+)
+
+// servedGateway is a gateway along with its server and the number of processors using it.
+type servedGateway struct {
+	*processor.Gateway
+	srv   *http.Server
+	users int
+}
+
+// ExitRetired is the exit code signaling the consumer exited on reaching the maximum number of messages or its
+// maximum lifetime.
+const ExitRetired = 12
+
 func main() {
 	NewApp().Run(os.Args)
 }
@@ -125,26 +213,21 @@ func Action(c *cli.Context) error {
 		return err
 	}
 
-	l, infW, errW, err := log.NewFromConfig(cfg)
+	l, logs, err := log.NewFromConfig(cfg)
 	if err != nil {
 		return err
 	}
 	ll = l
 
-	b := CreateBuilder(c.Bool("pipe"), cfg.RabbitMq.Compression, c.Bool("include"))
-	builder, err := command.NewBuilder(b, c.String("executable"), c.Bool("output"), l, infW, errW)
-	if err != nil {
-		return fmt.Errorf("failed to create command builder: %v", err)
+	var counter *processor.Counter
+	if n := c.Int("max-messages"); n > 0 {
+		counter = processor.NewCounter(n)
 	}
 
-	ack := acknowledger.NewFromConfig(cfg)
-	p := processor.New(builder, ack, l)
-
-	client, err := consumer.NewFromConfig(cfg, p, l)
+	s, err := newSession(c, cfg, counter, l, logs)
 	if err != nil {
 		return err
 	}
-	defer client.Close()
 
 	errs := make(chan error)
 
@@ -158,12 +241,412 @@ func Action(c *cli.Context) error {
 	}
 
 	go func() {
-		errs <- consume(client, l)
+		errs <- consume(c, s, retire(counter, c.Duration("max-lifetime")))
 	}()
 
 	return <-errs
 }
 
+// session holds the connection and the consumers created from one configuration.
+type session struct {
+	cfg *config.Config
+	log logr.Logger
+	// logs holds the log writers, shared with the next session.
+	logs    *log.Logs
+	conn    *consumer.Connector
+	clients []*consumer.Consumer
+	counter *processor.Counter
+	// pubs holds the publishers by consumer name, shared by the processors replacing each other on reload.
+	pubs map[string]*consumer.Publisher
+	// delayers holds the delayed requeues by consumer name, for them to survive a reload.
+	delayers map[string]*acknowledger.Delayer
+	// idle is the idle period after which the consumers exit, zero to keep them running.
+	idle time.Duration
+}
+
+// newSession connects to the broker and creates the consumers according to the configuration.
+// The processors are wrapped by the counter, if not nil.
+func newSession(c *cli.Context, cfg *config.Config, counter *processor.Counter, l logr.Logger, logs *log.Logs) (*session, error) {
+	var idle time.Duration
+	if c.Bool("exit-when-idle") {
+		if idle = c.Duration("idle-period"); idle <= 0 {
+			return nil, fmt.Errorf("exiting when idle requires a positive idle period")
+		}
+	}
+
+	conn, err := consumer.NewConnectorFromConfig(cfg, l)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &session{
+		cfg:      cfg,
+		log:      l,
+		logs:     logs,
+		conn:     conn,
+		counter:  counter,
+		pubs:     make(map[string]*consumer.Publisher),
+		delayers: make(map[string]*acknowledger.Delayer),
+		idle:     idle,
+	}
+	specs, err := consumerSpecs(c, cfg, l)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	for _, spec := range specs {
+		client, err := s.createConsumer(spec)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		s.clients = append(s.clients, client)
+	}
+
+	return s, nil
+}
+
+// Close closes the processors and the connection of the session.
+func (s *session) Close() error {
+	for _, client := range s.clients {
+		closeProcessor(client.CurrentProcessor())
+	}
+
+	return s.conn.Close()
+}
+
+// closeProcessor closes the processor, if supported. The gateway used by the processor stops being served, unless
+// still used by another one.
+func closeProcessor(p processor.Processor) {
+	if c, ok := p.(io.Closer); ok {
+		c.Close()
+	}
+	releaseGateway(p)
+}
+
+// consumerSpec describes a consumer to be created.
+type consumerSpec struct {
+	cfg        *config.Config
+	builder    command.Builder
+	executable string
+	output     bool
+	log        logr.Logger
+}
+
+// consumerSpecs describes one consumer for each named consumer in the configuration. Without named consumers, a single
+// consumer is described using the command line flags.
+func consumerSpecs(c *cli.Context, cfg *config.Config, l logr.Logger) ([]consumerSpec, error) {
+	if !cfg.HasConsumers() {
+		if err := cfg.Validate(); err != nil {
+			return nil, err
+		}
+
+		return []consumerSpec{{
+			cfg:        cfg,
+			builder:    createBuilder(cfg, c.Bool("pipe"), c.Bool("include")),
+			executable: c.String("executable"),
+			output:     c.Bool("output"),
+			log:        l,
+		}}, nil
+	}
+
+	var specs []consumerSpec
+	for _, name := range cfg.ConsumerNames() {
+		ccfg, err := cfg.ForConsumer(name)
+		if err != nil {
+			return nil, err
+		}
+
+		cc := cfg.Consumer[name]
+		specs = append(specs, consumerSpec{
+			cfg:        ccfg,
+			builder:    createBuilder(ccfg, cc.Mode == config.ModePipe, cc.Mode == config.ModeInclude),
+			executable: cc.Executable,
+			output:     cc.Output,
+			log:        l.NewWithPrefix(fmt.Sprintf("[%s] ", name)),
+		})
+	}
+
+	return specs, nil
+}
+
+// createBuilder creates the builder according to the mode of passing messages. In batch mode, messages are passed as
+// JSON Lines, regardless of the mode.
+func createBuilder(cfg *config.Config, pipe, metadata bool) command.Builder {
+	if cfg.BatchSize() > 1 {
+		return &command.JSONLinesBuilder{}
+	}
+
+	return CreateBuilder(pipe, cfg.RabbitMq.Compression, metadata)
+}
+
+// createConsumer creates a consumer, using its own channel on the connection of the session.
+func (s *session) createConsumer(spec consumerSpec) (*consumer.Consumer, error) {
+	p, err := s.createProcessor(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := consumer.NewFromConnector(s.conn, spec.cfg, p, spec.log)
+	if err != nil {
+		return nil, err
+	}
+	client.IdleTimeout = s.idle
+	client.Delayer = s.delayer(spec.cfg.ConsumerName())
+	// The publisher opens its channel on first use, which is once a poison message is diverted.
+	client.Publisher = s.publisher(spec.cfg.ConsumerName())
+
+	return client, nil
+}
+
+// createProcessor creates the processor running the executable of a consumer. In worker mode, the executable is run by
+// a pool of long-lived workers instead. With a webhook, a FastCGI responder, a socket or a gateway configured, messages
+// are passed to it instead.
+func (s *session) createProcessor(spec consumerSpec) (processor.Processor, error) {
+	cfg, b := spec.cfg, spec.builder
+	ack, err := acknowledger.NewFromConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create acknowledger: %v", err)
+	}
+
+	var pub acknowledger.Publisher
+	if needsPublisher(cfg) {
+		pub = s.publisher(cfg.ConsumerName())
+	}
+
+	_, batch := b.(command.BatchBuilder)
+	worker := cfg.WorkerMode()
+	webhook := cfg.WebhookURL() != ""
+	fastCGI := cfg.FastCGIAddress() != ""
+	socket := cfg.SocketPath() != ""
+	gateway := cfg.GatewayAddress() != ""
+	if cfg.RabbitMq.Response && !batch && !worker && !webhook && !fastCGI && !socket && !gateway {
+		pb, ok := b.(*command.PipeBuilder)
+		if !ok {
+			return nil, fmt.Errorf("responses require the pipe mode")
+		}
+		pb.Response = true
+	}
+
+	// The results of a batch, a worker, a webhook, a FastCGI responder, a socket or a gateway are handled like
+	// responses.
+	if cfg.RabbitMq.Response || batch || worker || webhook || fastCGI || socket || gateway {
+		ack = &acknowledger.Responding{
+			Acknowledger:         ack,
+			Publisher:            pub,
+			DeadLetterExchange:   cfg.DeadLetterExchange(),
+			DeadLetterRoutingKey: cfg.DeadLetterRoutingKey(),
+			Delayer:              s.delayer(cfg.ConsumerName()),
+		}
+	}
+
+	if cfg.HasRetry() {
+		ack = &acknowledger.Retrying{
+			Acknowledger: ack,
+			Publisher:    pub,
+			Queues:       cfg.RetryQueues(),
+			ParkingLot:   cfg.ParkingLotQueue(),
+		}
+	}
+
+	var p processor.Processor
+	switch {
+	case webhook:
+		p, err = processor.NewWebhookFromConfig(cfg, ack, spec.log)
+	case fastCGI:
+		p, err = processor.NewFastCGIFromConfig(cfg, ack, spec.log)
+	case socket:
+		p, err = processor.NewSocketFromConfig(cfg, ack, spec.log)
+	case gateway:
+		var g *processor.Gateway
+		if g, err = serveGateway(cfg.GatewayAddress(), s.log); err == nil {
+			p = processor.NewGatewayFromConfig(cfg, g, ack, spec.log)
+		}
+	case worker:
+		p, err = processor.NewPoolFromConfig(cfg, spec.executable, spec.output, ack, spec.log, s.logs.Error)
+	default:
+		var builder command.Builder
+		builder, err = command.NewBuilder(b, spec.executable, spec.output, spec.log, s.logs.Info, s.logs.Error)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create command builder: %v", err)
+		}
+		p, err = processor.NewFromConfig(cfg, builder, ack, pub, spec.log)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.counter != nil {
+		p = s.counter.Wrap(p)
+	}
+	if gateway {
+		useGateway(cfg.GatewayAddress(), p)
+	}
+
+	return p, nil
+}
+
+// publisher returns the publisher of the consumer, creating it on first use. Reusing it on reload avoids opening
+// another channel for each new processor.
+func (s *session) publisher(name string) *consumer.Publisher {
+	pub, ok := s.pubs[name]
+	if !ok {
+		pub = consumer.NewPublisher(s.conn)
+		s.pubs[name] = pub
+	}
+
+	return pub
+}
+
+// delayer returns the delayer keeping track of the delayed requeues of the consumer, creating it on first use.
+func (s *session) delayer(name string) *acknowledger.Delayer {
+	d, ok := s.delayers[name]
+	if !ok {
+		d = &acknowledger.Delayer{}
+		s.delayers[name] = d
+	}
+
+	return d
+}
+
+// reload applies the configuration to the session. If it only changes settings applicable in place, the consumers are
+// updated to use new processors and nil is returned. Otherwise, a new session is returned, to which the consumers have
+// to resubscribe. Nothing is changed if the configuration turns out to be invalid.
+func (s *session) reload(c *cli.Context, cfg *config.Config) (*session, error) {
+	if s.cfg.RequiresResubscribe(cfg) {
+		next, err := newSession(c, cfg, s.counter, s.log, s.logs)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.applyLogs(cfg); err != nil {
+			next.Close()
+			return nil, err
+		}
+
+		return next, nil
+	}
+
+	specs, err := consumerSpecs(c, cfg, s.log)
+	if err != nil {
+		return nil, err
+	}
+
+	procs := make([]processor.Processor, len(specs))
+	for i, spec := range specs {
+		if procs[i], err = s.createProcessor(spec); err != nil {
+			for _, p := range procs[:i] {
+				closeProcessor(p)
+			}
+			return nil, err
+		}
+	}
+
+	if err := s.applyLogs(cfg); err != nil {
+		for _, p := range procs {
+			closeProcessor(p)
+		}
+		return nil, err
+	}
+
+	for i, client := range s.clients {
+		old := client.CurrentProcessor()
+		if err := client.Update(specs[i].cfg, procs[i]); err != nil {
+			specs[i].log.Errorf("Failed to apply prefetch settings: %v", err)
+		}
+		// Closing waits for the messages still being processed by the old processor.
+		go closeProcessor(old)
+	}
+	s.cfg = cfg
+
+	return nil, nil
+}
+
+// applyLogs replaces the log files, if the log settings of the configuration differ from the ones of the session.
+func (s *session) applyLogs(cfg *config.Config) error {
+	if cfg.Logs == s.cfg.Logs {
+		return nil
+	}
+
+	return s.logs.Apply(cfg)
+}
+
+// needsPublisher checks if any of the enabled features publishes messages.
+func needsPublisher(cfg *config.Config) bool {
+	return cfg.RabbitMq.Response ||
+		cfg.BatchSize() > 1 ||
+		cfg.WorkerMode() ||
+		cfg.SocketPath() != "" ||
+		cfg.GatewayAddress() != "" ||
+		cfg.IsRpc() ||
+		cfg.PublishSource() != "" ||
+		cfg.HasRetry() ||
+		cfg.RepublishExchange() != "" ||
+		cfg.RepublishRoutingKey() != ""
+}
+
+// serveGateway returns the gateway served at the address, starting to serve it if not done before.
+func serveGateway(addr string, l logr.Logger) (*processor.Gateway, error) {
+	gatewaysMu.Lock()
+	defer gatewaysMu.Unlock()
+
+	if g, ok := gateways[addr]; ok {
+		return g.Gateway, nil
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serve gateway: %v", err)
+	}
+
+	g := processor.NewGateway()
+	// There is no write timeout, as leasing waits for messages as long as requested by the client.
+	srv := &http.Server{
+		Handler:     g,
+		ReadTimeout: time.Second * 15,
+		IdleTimeout: time.Second * 60,
+	}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			l.Errorf("Failed to serve gateway: %v", err)
+		}
+	}()
+
+	l.Infof("Serving gateway at %v", ln.Addr())
+	gateways[addr] = &servedGateway{Gateway: g, srv: srv}
+
+	return g, nil
+}
+
+// useGateway records the processor as using the gateway served at the address.
+func useGateway(addr string, p processor.Processor) {
+	gatewaysMu.Lock()
+	defer gatewaysMu.Unlock()
+
+	gateways[addr].users++
+	gatewayUsers[p] = addr
+}
+
+// releaseGateway records the processor as no longer using its gateway, if any. The gateway stops being served once
+// no processor uses it anymore.
+func releaseGateway(p processor.Processor) {
+	gatewaysMu.Lock()
+	defer gatewaysMu.Unlock()
+
+	addr, ok := gatewayUsers[p]
+	if !ok {
+		return
+	}
+	delete(gatewayUsers, p)
+
+	g := gateways[addr]
+	if g.users--; g.users == 0 {
+		g.srv.Close()
+		delete(gateways, addr)
+	}
+}
+
 func setupAndServeMetrics(addr string, path string) error {
 	srv := &http.Server{
 		Addr: addr,
@@ -175,7 +658,9 @@ func setupAndServeMetrics(addr string, path string) error {
 
 	prometheus.MustRegister(collector.ProcessCounter)
 	prometheus.MustRegister(collector.ProcessDuration)
+	prometheus.MustRegister(collector.ProcessTimeouts)
 	prometheus.MustRegister(collector.MessageDuration)
+	prometheus.MustRegister(collector.ConnectedNode)
 
 	http.Handle(path, promhttp.Handler())
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -195,26 +680,184 @@ func setupAndServeMetrics(addr string, path string) error {
 	return nil
 }
 
-func consume(client *consumer.Consumer, l logr.Logger) error {
-	done := make(chan error)
+// consume runs the consumers of the session until one of them fails or a shutdown signal is received. On SIGHUP, the
+// configuration is reloaded. If that requires to resubscribe, the consumers of the session are canceled and the ones of
+// the new session are started once they are done.
+//
+// Once a message is received from retire, the consumers are canceled like on shutdown and the application exits with
+// ExitRetired.
+func consume(c *cli.Context, s *session, retire <-chan string) error {
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGTERM)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGHUP)
+	defer signal.Stop(sig)
+
+	for {
+		next, err := s.consume(sig, retire, func() (*session, error) {
+			cfg, err := LoadConfiguration(c)
+			if err != nil {
+				return nil, err
+			}
+
+			return s.reload(c, cfg)
+		})
+		s.Close()
+		if next == nil {
+			return err
+		}
+
+		s = next
+		ll = s.log
+	}
+}
 
+// consume runs the consumers until one of them fails, a shutdown signal or a reason to retire is received, or reloading
+// the configuration returns a new session. In the latter case, the new session is returned once all consumers are done. On shutdown, the
+// running processes get the time until the shutdown timeout to finish before they get terminated. A second signal
+// kills them and exits immediately. Otherwise, the first error, if any, is returned once all consumers are done.
+func (s *session) consume(sig <-chan os.Signal, retire <-chan string, reload func() (*session, error)) (*session, error) {
+	done := make(chan error, len(s.clients))
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	go func() {
-		done <- client.Consume(ctx)
-	}()
+	for _, client := range s.clients {
+		go func(client *consumer.Consumer) {
+			done <- client.Consume(ctx)
+		}(client)
+	}
 
-	select {
-	case <-sig:
-		l.Info("Cancel consumption of messages.")
-		cancel()
-		return checkConsumeError(<-done)
+	var err error
+	var next *session
+	var retired bool
+	running := len(s.clients)
+	for ctx.Err() == nil {
+		select {
+		case reason := <-retire:
+			s.log.Infof("%s, cancel consumption of messages.", reason)
+			retired = true
+			cancel()
+
+		case sg := <-sig:
+			if sg == syscall.SIGHUP {
+				if next = s.tryReload(reload); next != nil {
+					cancel()
+				}
+				continue
+			}
+
+			s.log.Infof("Received %v, cancel consumption of messages.", sg)
+			cancel()
+
+		case err = <-done:
+			running--
+			// When exiting once idle, each consumer stops on its own. The others keep going until idle as well.
+			if err != nil || s.idle == 0 || running == 0 {
+				cancel()
+			}
+		}
+	}
+
+	var expired <-chan time.Time
+	if timeout := s.cfg.ShutdownTimeout(); timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
 
-	case err := <-done:
-		return checkConsumeError(err)
+	for running > 0 {
+		select {
+		case cerr := <-done:
+			running--
+			if err == nil {
+				err = cerr
+			}
+
+		case <-expired:
+			s.log.Errorf("Shutdown timeout of %v exceeded, terminating running processes...", s.cfg.ShutdownTimeout())
+			s.stopProcessors(false)
+
+		case sg := <-sig:
+			if sg == syscall.SIGHUP {
+				s.log.Infof("Ignoring %v while shutting down.", sg)
+				continue
+			}
+
+			if next != nil {
+				s.log.Infof("Received %v, cancel consumption of messages.", sg)
+				next.Close()
+				next = nil
+				continue
+			}
+
+			s.log.Errorf("Received %v again, exiting immediately.", sg)
+			s.stopProcessors(true)
+			return nil, cli.NewExitError("", 128+int(sg.(syscall.Signal)))
+		}
+	}
+
+	if err != nil && next != nil {
+		next.Close()
+		next = nil
+	}
+
+	if err == nil && retired {
+		return nil, cli.NewExitError("", ExitRetired)
+	}
+
+	return next, checkConsumeError(err)
+}
+
+// tryReload reloads the configuration, keeping the current one if that fails. It returns the session to resubscribe
+// to, if any.
+func (s *session) tryReload(reload func() (*session, error)) *session {
+	s.log.Info("Reloading configuration...")
+	next, err := reload()
+	if err != nil {
+		s.log.Errorf("Failed to reload configuration, keeping the current one: %v", err)
+		return nil
+	}
+
+	if next != nil {
+		s.log.Info("Configuration changed, resubscribing...")
+		return next
+	}
+
+	s.log.Info("Configuration reloaded.")
+	return nil
+}
+
+// retire creates a channel receiving the reason to retire the consumer once the counter reached its limit or the
+// lifetime is exceeded. A nil counter and a lifetime of zero disable the respective limit.
+func retire(counter *processor.Counter, lifetime time.Duration) <-chan string {
+	ch := make(chan string, 2)
+	if counter != nil {
+		go func() {
+			<-counter.Reached()
+			ch <- "Reached the maximum number of messages"
+		}()
+	}
+
+	if lifetime > 0 {
+		time.AfterFunc(lifetime, func() {
+			ch <- fmt.Sprintf("Reached the maximum lifetime of %v", lifetime)
+		})
+	}
+
+	return ch
+}
+
+// stopProcessors stops the processors of all consumers. With kill set, the running processes are killed right away.
+func (s *session) stopProcessors(kill bool) {
+	for _, client := range s.clients {
+		p, ok := client.CurrentProcessor().(processor.Stopper)
+		if !ok {
+			continue
+		}
+
+		if kill {
+			p.Kill()
+		} else {
+			p.Stop()
+		}
 	}
 }
 
@@ -277,7 +920,8 @@ func LoadConfiguration(c *cli.Context) (*config.Config, error) {
 	url := c.String("url")
 	queue := c.String("queue-name")
 
-	if file == "" && url == "" && queue == "" && c.String("executable") == "" {
+	if file == "" && url == "" && queue == "" && c.String("executable") == "" && c.String("webhook") == "" &&
+		c.String("fastcgi") == "" && c.String("socket") == "" && c.String("gateway") == "" {
 		cli.ShowAppHelp(c)
 		return nil, cli.NewExitError("", 1)
 	}
@@ -288,13 +932,49 @@ func LoadConfiguration(c *cli.Context) (*config.Config, error) {
 	}
 
 	if len(url) > 0 {
-		cfg.RabbitMq.AmqpUrl = url
+		cfg.RabbitMq.AmqpUrl = []string{url}
 	}
 
 	if queue != "" {
 		cfg.RabbitMq.Queue = queue
 	}
 
+	if c.IsSet("concurrency") {
+		cfg.RabbitMq.Concurrency = c.Int("concurrency")
+	}
+
+	if c.IsSet("batch-size") {
+		cfg.Batch.Size = c.Int("batch-size")
+	}
+
+	if c.IsSet("batch-timeout") {
+		cfg.Batch.Timeout = config.Duration(c.Duration("batch-timeout"))
+	}
+
+	if c.IsSet("webhook") {
+		cfg.Webhook.Url = c.String("webhook")
+	}
+
+	if c.IsSet("fastcgi") {
+		cfg.FastCGI.Address = c.String("fastcgi")
+	}
+
+	if c.IsSet("fastcgi-script") {
+		cfg.FastCGI.Script = c.String("fastcgi-script")
+	}
+
+	if c.IsSet("socket") {
+		cfg.Socket.Path = c.String("socket")
+	}
+
+	if c.IsSet("gateway") {
+		cfg.Gateway.Address = c.String("gateway")
+	}
+
+	if c.IsSet("worker") {
+		cfg.Worker.Enabled = c.Bool("worker")
+	}
+
 	if c.IsSet("no-datetime") {
 		cfg.Logs.NoDateTime = c.Bool("no-datetime")
 	}
@@ -303,6 +983,18 @@ func LoadConfiguration(c *cli.Context) (*config.Config, error) {
 		cfg.Logs.Verbose = c.Bool("verbose")
 	}
 
+	if c.IsSet("response") {
+		cfg.RabbitMq.Response = c.Bool("response")
+	}
+
+	if c.IsSet("rpc") {
+		cfg.Rpc.Enabled = c.Bool("rpc")
+	}
+
+	if c.IsSet("publish") {
+		cfg.Publish.Source = c.String("publish")
+	}
+
 	if c.IsSet("strict-exit-code") {
 		cfg.RabbitMq.Stricfailure = c.Bool("strict-exit-code")
 	}
@@ -0,0 +1,111 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/bketelsen/logr"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/acknowledger"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/collector"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// backend holds what the processors passing the messages to a service instead of running an executable have in common.
+type backend struct {
+	name      string
+	timeout   time.Duration
+	onTimeout acknowledger.Action
+	ack       acknowledger.Acknowledger
+	log       logr.Logger
+	// ctx is canceled once the processor got stopped, aborting the running requests.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// newBackend creates the common part of a backend processor, handling requests exceeding the timeout according to the
+// timeout action.
+func newBackend(name string, timeout time.Duration, timeoutAction string, a acknowledger.Acknowledger, l logr.Logger) (backend, error) {
+	onTimeout, err := acknowledger.ParseAction(timeoutAction)
+	if err != nil {
+		return backend{}, fmt.Errorf("invalid timeout action: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return backend{
+		name:      name,
+		timeout:   timeout,
+		onTimeout: onTimeout,
+		ack:       a,
+		log:       l,
+		ctx:       ctx,
+		cancel:    cancel,
+	}, nil
+}
+
+// failed acknowledges the message of a request that got no response. Unless the timeout got exceeded, the message is
+// put back into the queue.
+func (b *backend) failed(d delivery.Delivery, err error) error {
+	if b.ctx.Err() != nil {
+		b.log.Info("Requeueing message of interrupted request.")
+		d.Nack(true)
+		return nil
+	}
+
+	if isTimeout(err) {
+		b.log.Errorf("Timeout of %v exceeded: %v", b.timeout, err)
+		collector.ProcessTimeouts.With(prometheus.Labels{"consumer": b.name}).Inc()
+		if err := b.onTimeout.Apply(d); err != nil {
+			return NewAcknowledgmentError(err)
+		}
+
+		return nil
+	}
+
+	b.log.Errorf("Request failed: %v", err)
+	d.Nack(true)
+
+	return nil
+}
+
+// respond acknowledges the message according to the response, passing the status as exit code to the acknowledger.
+// Without a response, the message is acknowledged according to the status.
+func (b *backend) respond(d delivery.Delivery, status int, r *acknowledger.Response) error {
+	collector.ProcessCounter.With(prometheus.Labels{"consumer": b.name, "exit_code": strconv.Itoa(status)}).Inc()
+
+	var err error
+	if ra, ok := b.ack.(acknowledger.ResponseAcknowledger); ok && r != nil {
+		err = ra.AckResponse(d, status, r)
+	} else if r != nil {
+		err = r.Action.Apply(d)
+	} else {
+		err = b.ack.Ack(d, status)
+	}
+	if err != nil {
+		return NewAcknowledgmentError(err)
+	}
+
+	return nil
+}
+
+// Stop aborts the running requests.
+func (b *backend) Stop() {
+	b.cancel()
+}
+
+// Kill aborts the running requests, same as Stop.
+func (b *backend) Kill() {
+	b.cancel()
+}
+
+// observe records the time processing the message took as well as the time since the message got published.
+func observe(name string, d delivery.Delivery, start time.Time) {
+	labels := prometheus.Labels{"consumer": name}
+	collector.ProcessDuration.With(labels).Observe(time.Since(start).Seconds())
+	if !d.Properties().Timestamp.IsZero() {
+		collector.MessageDuration.With(labels).Observe(time.Since(d.Properties().Timestamp).Seconds())
+	}
+}
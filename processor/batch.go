@@ -0,0 +1,145 @@
+package processor
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/acknowledger"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/collector"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/command"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ProcessBatch runs the command once for all the messages, if the builder supports it. Otherwise, the messages are
+// processed one after the other.
+//
+// The command writes one result per message, in the order of the messages. A result has the same format as the
+// response of a single message and is handled like it. Messages without a result are acknowledged according to the
+// exit code. If the command exceeds the execution timeout, all messages are acknowledged according to the timeout
+// action. The timeout of a batch is the smallest one of its messages, as set by the TimeoutHeader.
+//
+// Unlike single messages, batches are not retried locally. The messages can not be republished, so the republish action
+// rejects them instead.
+func (p *processor) ProcessBatch(ds []delivery.Delivery) error {
+	bb, ok := p.builder.(command.BatchBuilder)
+	if !ok {
+		for _, d := range ds {
+			if err := p.Process(d); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if p.stopped() {
+		return nackAll(ds)
+	}
+
+	cmd, results, err := bb.GetBatchCommand(ds)
+	if err != nil {
+		nackAll(ds)
+		return NewCreateCommandError(err)
+	}
+
+	r := newPipe(results, int64(maxResponseSize*len(ds)))
+	defer r.close()
+
+	p.log.Infof("Processing batch of %d messages...", len(ds))
+	start := time.Now()
+	code, timedOut := p.run(cmd, p.batchTimeout(ds), nil, nil)
+
+	labels := prometheus.Labels{"consumer": p.name}
+	collector.ProcessCounter.With(prometheus.Labels{"consumer": p.name, "exit_code": strconv.Itoa(code)}).Inc()
+	collector.ProcessDuration.With(labels).Observe(time.Since(start).Seconds())
+
+	if p.stopped() && code != 0 {
+		p.log.Info("Requeueing messages of interrupted process.")
+		return nackAll(ds)
+	}
+
+	for _, d := range ds {
+		if !d.Properties().Timestamp.IsZero() {
+			collector.MessageDuration.With(labels).Observe(time.Since(d.Properties().Timestamp).Seconds())
+		}
+	}
+
+	if timedOut {
+		collector.ProcessTimeouts.With(labels).Inc()
+		for _, d := range ds {
+			if err := p.onTimeout.Apply(d); err != nil {
+				return NewAcknowledgmentError(err)
+			}
+		}
+
+		return nil
+	}
+
+	res := p.results(r.wait(), len(ds))
+	ra, ok := p.ack.(acknowledger.ResponseAcknowledger)
+	for i, d := range ds {
+		if ok && res[i] != nil {
+			err = ra.AckResponse(d, code, res[i])
+		} else {
+			err = p.ack.Ack(d, code)
+		}
+		if err != nil {
+			return NewAcknowledgmentError(err)
+		}
+	}
+
+	return nil
+}
+
+// batchTimeout returns the execution timeout of a batch, which is the smallest one of its messages. Messages whose
+// timeout is disabled do not limit the batch.
+func (p *processor) batchTimeout(ds []delivery.Delivery) time.Duration {
+	var timeout time.Duration
+	for _, d := range ds {
+		if t := p.timeoutFor(d.Properties()); t > 0 && (timeout == 0 || t < timeout) {
+			timeout = t
+		}
+	}
+
+	return timeout
+}
+
+// results parses the results written by the command, one line per message. Lines which can not be parsed as well as
+// missing lines result in nil.
+func (p *processor) results(b []byte, n int) []*acknowledger.Response {
+	res := make([]*acknowledger.Response, n)
+	lines := bytes.Split(bytes.TrimRight(b, "\n"), []byte("\n"))
+	if len(bytes.TrimSpace(b)) == 0 {
+		lines = nil
+	}
+	if len(lines) > n {
+		p.log.Errorf("Ignoring %d results exceeding the batch of %d messages.", len(lines)-n, n)
+		lines = lines[:n]
+	}
+
+	for i, line := range lines {
+		r, err := acknowledger.ParseResponse(line)
+		if err != nil {
+			p.log.Errorf("Ignoring result %d: %v", i+1, err)
+			continue
+		}
+
+		if r != nil && r.Reason != "" {
+			p.log.Infof("Reason %d: %s", i+1, r.Reason)
+		}
+		res[i] = r
+	}
+
+	return res
+}
+
+// nackAll puts the messages back into the queue.
+func nackAll(ds []delivery.Delivery) error {
+	for _, d := range ds {
+		d.Nack(true)
+	}
+
+	return nil
+}
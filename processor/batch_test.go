@@ -0,0 +1,173 @@
+package processor
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	log "github.com/corvus-ch/logr/buffered"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/acknowledger"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+	"github.com/stretchr/testify/assert"
+)
+
+type TestBatchBuilder struct {
+	TestBuilder
+}
+
+func (b *TestBatchBuilder) GetBatchCommand(ds []delivery.Delivery) (*exec.Cmd, io.ReadCloser, error) {
+	argsT := b.Called(ds)
+
+	return argsT.Get(0).(*exec.Cmd), argsT.Get(1).(io.ReadCloser), argsT.Error(2)
+}
+
+var batchTests = []struct {
+	name    string
+	cmd     *exec.Cmd
+	timeout time.Duration
+	setup   func(a *TestAcknowledger, ds []*TestDelivery)
+	output  string
+}{
+	{
+		"results",
+		testCommand("respond", true, "{\"action\":\"ack\"}\n\n{\"action\":\"reject\",\"reason\":\"invalid\"}\n"),
+		0,
+		func(a *TestAcknowledger, ds []*TestDelivery) {
+			a.On("AckResponse", ds[0], 0, &acknowledger.Response{Action: acknowledger.ActionAck}).Return(nil)
+			a.On("Ack", ds[1], 0).Return(nil)
+			a.On("AckResponse", ds[2], 0, &acknowledger.Response{Action: acknowledger.ActionReject, Reason: "invalid"}).Return(nil)
+		},
+		"INFO Processing batch of 3 messages...\nINFO Processing message...\nINFO Processed!\nINFO Reason 3: invalid\n",
+	},
+	{
+		"missing",
+		testCommand("respond", true, "{\"action\":\"ack\"}"),
+		0,
+		func(a *TestAcknowledger, ds []*TestDelivery) {
+			a.On("AckResponse", ds[0], 0, &acknowledger.Response{Action: acknowledger.ActionAck}).Return(nil)
+			a.On("Ack", ds[1], 0).Return(nil)
+			a.On("Ack", ds[2], 0).Return(nil)
+		},
+		"INFO Processing batch of 3 messages...\nINFO Processing message...\nINFO Processed!\n",
+	},
+	{
+		"invalid",
+		testCommand("respond", true, "{}\nnope\n{}\n{}\n"),
+		0,
+		func(a *TestAcknowledger, ds []*TestDelivery) {
+			a.On("AckResponse", ds[0], 0, &acknowledger.Response{}).Return(nil)
+			a.On("Ack", ds[1], 0).Return(nil)
+			a.On("AckResponse", ds[2], 0, &acknowledger.Response{}).Return(nil)
+		},
+		"INFO Processing batch of 3 messages...\nINFO Processing message...\nINFO Processed!\nERROR Ignoring 1 results exceeding the batch of 3 messages.\nERROR Ignoring result 2: failed to parse response: invalid character 'o' in literal null (expecting 'u')\n",
+	},
+	{
+		"failed",
+		testCommand("fail", true, "3", "failed"),
+		0,
+		func(a *TestAcknowledger, ds []*TestDelivery) {
+			for _, d := range ds {
+				a.On("Ack", d, 3).Return(nil)
+			}
+		},
+		"INFO Processing batch of 3 messages...\nINFO Processing message...\nINFO Failed. Check error log for details.\nERROR Error: exit status 3\nINFO Processed!\n",
+	},
+	{
+		"timeout",
+		testCommand("sleep", true),
+		200 * time.Millisecond,
+		func(a *TestAcknowledger, ds []*TestDelivery) {
+			for _, d := range ds {
+				d.On("Reject", false).Return(nil)
+			}
+		},
+		"INFO Processing batch of 3 messages...\nINFO Processing message...\nERROR Timeout of 200ms exceeded, terminating process...\nINFO Failed. Check error log for details.\nERROR Error: signal: terminated\nINFO Processed!\n",
+	},
+}
+
+func TestProcessor_ProcessBatch(t *testing.T) {
+	for _, test := range batchTests {
+		t.Run(test.name, func(t *testing.T) {
+			r, w, err := os.Pipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.cmd.ExtraFiles = []*os.File{w}
+
+			l := log.New(0)
+			a := new(TestAcknowledger)
+			b := new(TestBatchBuilder)
+			p := &processor{builder: b, ack: a, log: l, timeout: test.timeout, grace: 100 * time.Millisecond, onTimeout: acknowledger.ActionReject}
+
+			tds := []*TestDelivery{new(TestDelivery), new(TestDelivery), new(TestDelivery)}
+			ds := make([]delivery.Delivery, len(tds))
+			for i, d := range tds {
+				d.On("Properties").Return(properties)
+				ds[i] = d
+			}
+			b.On("GetBatchCommand", ds).Return(test.cmd, r, nil)
+			test.setup(a, tds)
+
+			assert.Nil(t, p.ProcessBatch(ds))
+			assert.Equal(t, test.output, l.Buf().String())
+			a.AssertExpectations(t)
+			b.AssertExpectations(t)
+			for _, d := range tds {
+				d.AssertExpectations(t)
+			}
+		})
+	}
+}
+
+func TestProcessor_Process_Batch(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := testCommand("respond", true, "")
+	cmd.ExtraFiles = []*os.File{w}
+
+	a := new(TestAcknowledger)
+	b := new(TestBatchBuilder)
+	d := new(TestDelivery)
+	p := New(b, a, log.New(0))
+
+	d.On("Properties").Return(properties)
+	b.On("GetBatchCommand", []delivery.Delivery{d}).Return(cmd, r, nil)
+	a.On("Ack", d, 0).Return(nil)
+
+	assert.Nil(t, p.Process(d))
+	a.AssertExpectations(t)
+	b.AssertExpectations(t)
+	d.AssertExpectations(t)
+}
+
+var batchTimeoutTests = []struct {
+	name    string
+	headers []map[string]interface{}
+	timeout time.Duration
+}{
+	{"configured", []map[string]interface{}{nil, nil}, time.Minute},
+	{"smallest", []map[string]interface{}{{TimeoutHeader: "2m"}, {TimeoutHeader: int32(1500)}, nil}, 1500 * time.Millisecond},
+	{"disabled", []map[string]interface{}{{TimeoutHeader: int64(0)}, {TimeoutHeader: "2m"}}, 2 * time.Minute},
+	{"all disabled", []map[string]interface{}{{TimeoutHeader: int64(0)}, {TimeoutHeader: int64(0)}}, 0},
+}
+
+func TestProcessor_BatchTimeout(t *testing.T) {
+	for _, test := range batchTimeoutTests {
+		t.Run(test.name, func(t *testing.T) {
+			p := processor{log: log.New(0), timeout: time.Minute}
+
+			ds := make([]delivery.Delivery, len(test.headers))
+			for i, h := range test.headers {
+				d := new(TestDelivery)
+				d.On("Properties").Return(delivery.Properties{Headers: h})
+				ds[i] = d
+			}
+
+			assert.Equal(t, test.timeout, p.batchTimeout(ds))
+		})
+	}
+}
@@ -1,6 +1,10 @@
 package processor
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"net"
+)
 
 // NewCreateCommandError creates a new CreateCommandError from the given error.
 func NewCreateCommandError(err error) error {
@@ -31,3 +35,9 @@ type AcknowledgmentError struct {
 func (e AcknowledgmentError) Error() string {
 	return fmt.Sprintf("failed to aknowledge message: %v", e.err)
 }
+
+// isTimeout checks if the error is caused by exceeding a deadline of a network operation.
+func isTimeout(err error) bool {
+	var nerr net.Error
+	return errors.As(err, &nerr) && nerr.Timeout()
+}
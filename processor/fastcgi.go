@@ -0,0 +1,176 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bketelsen/logr"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/acknowledger"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/fastcgi"
+)
+
+// FastCGIHeaderPrefix prefixes the params passing the message headers to the FastCGI script.
+const FastCGIHeaderPrefix = "AMQP_HEADER_"
+
+// paramNameReplacer matches the characters of a header name not allowed in a param name.
+var paramNameReplacer = regexp.MustCompile("[^A-Z0-9_]")
+
+// FastCGIConfig defines the interface to present configurations to the FastCGI processor.
+type FastCGIConfig interface {
+	ConsumerName() string
+	FastCGIAddress() string
+	FastCGIScript() string
+	FastCGIStatusMap() []string
+	FastCGITimeout() time.Duration
+	TimeoutAction() string
+}
+
+// NewFastCGIFromConfig creates a processor passing the messages to a FastCGI responder like PHP-FPM instead of running
+// an executable, see fastCGI.Process.
+func NewFastCGIFromConfig(cfg FastCGIConfig, a acknowledger.Acknowledger, l logr.Logger) (Processor, error) {
+	b, err := newBackend(cfg.ConsumerName(), cfg.FastCGITimeout(), cfg.TimeoutAction(), a, l)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses, err := newStatusMap(cfg.FastCGIStatusMap())
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.FastCGIScript() == "" {
+		return nil, fmt.Errorf("no FastCGI script configured")
+	}
+
+	network, address := fastcgi.ParseAddress(cfg.FastCGIAddress())
+
+	return &fastCGI{
+		backend:  b,
+		network:  network,
+		address:  address,
+		script:   cfg.FastCGIScript(),
+		statuses: statuses,
+	}, nil
+}
+
+type fastCGI struct {
+	backend
+	network  string
+	address  string
+	script   string
+	statuses statusMap
+}
+
+// Process passes the message as request to the FastCGI responder and acknowledges it according to the status of the
+// response, like a webhook does. The body of the message is the body of the request, its metadata is passed as params.
+// Each message is passed using a connection of its own.
+//
+// Once the processor got stopped, the running requests are aborted and their messages, like all further messages, are
+// put back into the queue.
+func (p *fastCGI) Process(d delivery.Delivery) error {
+	if p.ctx.Err() != nil {
+		d.Nack(true)
+		return nil
+	}
+
+	p.log.Info("Passing message to FastCGI responder...")
+	start := time.Now()
+	res, err := p.do(d)
+	observe(p.name, d, start)
+
+	if err != nil {
+		return p.failed(d, err)
+	}
+
+	if len(res.Stderr) > 0 {
+		p.log.Errorf("Responder wrote: %s", bytes.TrimSpace(res.Stderr))
+	}
+
+	r := p.statuses.response(res.Status, res.Header)
+	if r.Action != acknowledger.ActionAck {
+		p.log.Errorf("Responder responded with %d: %s", res.Status, bytes.TrimSpace(res.Body))
+	} else {
+		p.log.Info("Processed!")
+	}
+
+	return p.respond(d, res.Status, r)
+}
+
+// do connects to the responder and passes the message. The connection gets closed once the processor got stopped.
+func (p *fastCGI) do(d delivery.Delivery) (*fastcgi.Response, error) {
+	dialer := net.Dialer{Timeout: p.timeout}
+	conn, err := dialer.DialContext(p.ctx, p.network, p.address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if p.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(p.timeout))
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-p.ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	return fastcgi.Do(conn, p.params(d), d.Body(), maxResponseSize)
+}
+
+// params creates the params passing the message to the script. Besides the CGI variables required by PHP-FPM, the
+// delivery info and properties are passed as params prefixed by AMQP_, e.g. AMQP_ROUTING_KEY, and the headers as
+// params prefixed by AMQP_HEADER_, with their names converted to upper case and special chars replaced by underscores.
+func (p *fastCGI) params(d delivery.Delivery) map[string]string {
+	props, info := d.Properties(), d.Info()
+	name := "/" + filepath.Base(p.script)
+
+	params := map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"SERVER_SOFTWARE":   "rabbitmq-cli-consumer",
+		"SERVER_PROTOCOL":   "HTTP/1.1",
+		"REQUEST_METHOD":    "POST",
+		"SCRIPT_FILENAME":   p.script,
+		"SCRIPT_NAME":       name,
+		"REQUEST_URI":       name,
+		"CONTENT_LENGTH":    strconv.Itoa(len(d.Body())),
+		"CONTENT_TYPE":      props.ContentType,
+
+		"AMQP_CONSUMER_TAG":     info.ConsumerTag,
+		"AMQP_DELIVERY_TAG":     strconv.FormatUint(info.DeliveryTag, 10),
+		"AMQP_REDELIVERED":      strconv.FormatBool(info.Redelivered),
+		"AMQP_EXCHANGE":         info.Exchange,
+		"AMQP_ROUTING_KEY":      info.RoutingKey,
+		"AMQP_CONTENT_TYPE":     props.ContentType,
+		"AMQP_CONTENT_ENCODING": props.ContentEncoding,
+		"AMQP_DELIVERY_MODE":    strconv.Itoa(int(props.DeliveryMode)),
+		"AMQP_PRIORITY":         strconv.Itoa(int(props.Priority)),
+		"AMQP_CORRELATION_ID":   props.CorrelationID,
+		"AMQP_REPLY_TO":         props.ReplyTo,
+		"AMQP_EXPIRATION":       props.Expiration,
+		"AMQP_MESSAGE_ID":       props.MessageID,
+		"AMQP_TYPE":             props.Type,
+		"AMQP_USER_ID":          props.UserID,
+		"AMQP_APP_ID":           props.AppID,
+	}
+	if !props.Timestamp.IsZero() {
+		params["AMQP_TIMESTAMP"] = strconv.FormatInt(props.Timestamp.Unix(), 10)
+	}
+
+	for k, v := range props.Headers {
+		params[FastCGIHeaderPrefix+paramNameReplacer.ReplaceAllString(strings.ToUpper(k), "_")] = fmt.Sprint(v)
+	}
+
+	return params
+}
@@ -0,0 +1,191 @@
+package processor
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/corvus-ch/logr/buffered"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/acknowledger"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+type testFastCGIConfig struct {
+	address string
+	timeout time.Duration
+}
+
+func (c testFastCGIConfig) ConsumerName() string          { return "" }
+func (c testFastCGIConfig) FastCGIAddress() string        { return c.address }
+func (c testFastCGIConfig) FastCGIScript() string         { return "/var/www/consumer.php" }
+func (c testFastCGIConfig) FastCGIStatusMap() []string    { return nil }
+func (c testFastCGIConfig) FastCGITimeout() time.Duration { return c.timeout }
+func (c testFastCGIConfig) TimeoutAction() string         { return "reject" }
+
+// serveFastCGI serves FastCGI requests on a local TCP port, responding like webhookHandler.
+func serveFastCGI(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go fcgi.Serve(l, webhookHandler(t))
+
+	return l
+}
+
+var fastCGITests = []struct {
+	name     string
+	body     string
+	response *acknowledger.Response
+	code     int
+	output   string
+}{
+	{
+		"ok",
+		"200",
+		&acknowledger.Response{Action: acknowledger.ActionAck},
+		200,
+		"INFO Passing message to FastCGI responder...\nINFO Processed!\n",
+	},
+	{
+		"clientError",
+		"404",
+		&acknowledger.Response{Action: acknowledger.ActionReject},
+		404,
+		"INFO Passing message to FastCGI responder...\nERROR Responder responded with 404: nope\n",
+	},
+	{
+		"tooManyRequests",
+		"429",
+		&acknowledger.Response{Action: acknowledger.ActionRequeue, Delay: 30 * time.Second},
+		429,
+		"INFO Passing message to FastCGI responder...\nERROR Responder responded with 429: nope\n",
+	},
+}
+
+func TestFastCGI_Process(t *testing.T) {
+	srv := serveFastCGI(t)
+	defer srv.Close()
+
+	for _, test := range fastCGITests {
+		t.Run(test.name, func(t *testing.T) {
+			l := log.New(0)
+			a := new(TestAcknowledger)
+			d := new(TestDelivery)
+			p, err := NewFastCGIFromConfig(testFastCGIConfig{address: srv.Addr().String(), timeout: time.Second}, a, l)
+			if err != nil {
+				t.Fatalf("failed to create processor: %v", err)
+			}
+
+			d.On("Body").Return([]byte(test.body))
+			d.On("Properties").Return(properties)
+			d.On("Info").Return(info)
+			a.On("AckResponse", d, test.code, test.response).Return(nil)
+
+			assert.Nil(t, p.Process(d))
+			assert.Equal(t, test.output, l.Buf().String())
+			a.AssertExpectations(t)
+			d.AssertExpectations(t)
+		})
+	}
+}
+
+func TestFastCGI_Params(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fastcgi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var env map[string]string
+	socket := filepath.Join(dir, "php-fpm.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+	go fcgi.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		env = fcgi.ProcessEnv(r)
+	}))
+
+	a := new(TestAcknowledger)
+	d := new(TestDelivery)
+	p, err := NewFastCGIFromConfig(testFastCGIConfig{address: "unix:" + socket, timeout: time.Second}, a, log.New(0))
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+
+	props := delivery.Properties{
+		Headers:   amqp.Table{"x-tenant": "acme", "Retry-Count": int32(2)},
+		MessageID: "42",
+		Timestamp: time.Unix(1500000000, 0),
+	}
+	d.On("Body").Return([]byte(t.Name()))
+	d.On("Properties").Return(props)
+	d.On("Info").Return(delivery.Info{RoutingKey: "mail.send", DeliveryTag: 7, Redelivered: true})
+	a.On("AckResponse", d, 200, &acknowledger.Response{Action: acknowledger.ActionAck}).Return(nil)
+
+	assert.Nil(t, p.Process(d))
+	assert.Equal(t, "/var/www/consumer.php", env["SCRIPT_FILENAME"])
+	assert.Equal(t, "mail.send", env["AMQP_ROUTING_KEY"])
+	assert.Equal(t, "7", env["AMQP_DELIVERY_TAG"])
+	assert.Equal(t, "true", env["AMQP_REDELIVERED"])
+	assert.Equal(t, "42", env["AMQP_MESSAGE_ID"])
+	assert.Equal(t, "1500000000", env["AMQP_TIMESTAMP"])
+	assert.Equal(t, "acme", env["AMQP_HEADER_X_TENANT"])
+	assert.Equal(t, "2", env["AMQP_HEADER_RETRY_COUNT"])
+	a.AssertExpectations(t)
+}
+
+func TestFastCGI_Timeout(t *testing.T) {
+	srv := serveFastCGI(t)
+	defer srv.Close()
+
+	l := log.New(0)
+	a := new(TestAcknowledger)
+	d := new(TestDelivery)
+	p, err := NewFastCGIFromConfig(testFastCGIConfig{address: srv.Addr().String(), timeout: 100 * time.Millisecond}, a, l)
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+
+	d.On("Body").Return([]byte("sleep"))
+	d.On("Properties").Return(properties)
+	d.On("Info").Return(info)
+	d.On("Reject", false).Return(nil)
+
+	assert.Nil(t, p.Process(d))
+	assert.Contains(t, l.Buf().String(), "ERROR Timeout of 100ms exceeded: ")
+	d.AssertExpectations(t)
+}
+
+func TestFastCGI_Stop(t *testing.T) {
+	srv := serveFastCGI(t)
+	defer srv.Close()
+
+	l := log.New(0)
+	a := new(TestAcknowledger)
+	d := new(TestDelivery)
+	p, err := NewFastCGIFromConfig(testFastCGIConfig{address: srv.Addr().String(), timeout: time.Minute}, a, l)
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+
+	d.On("Body").Return([]byte("sleep"))
+	d.On("Properties").Return(properties)
+	d.On("Info").Return(info)
+	d.On("Nack", true).Return(nil).Twice()
+
+	time.AfterFunc(100*time.Millisecond, p.(Stopper).Stop)
+	assert.Nil(t, p.Process(d))
+	assert.Nil(t, p.Process(d))
+	assert.Equal(t, "INFO Passing message to FastCGI responder...\nINFO Requeueing message of interrupted request.\n", l.Buf().String())
+	d.AssertExpectations(t)
+}
@@ -0,0 +1,464 @@
+package processor
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bketelsen/logr"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/acknowledger"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/collector"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gatewayActions maps the actions of the settle requests to the actions applied to the messages.
+var gatewayActions = map[string]acknowledger.Action{
+	"ack":    acknowledger.ActionAck,
+	"nack":   acknowledger.ActionRequeue,
+	"reject": acknowledger.ActionReject,
+}
+
+// Gateway hands the messages passed to its processors out to HTTP clients, see Gateway.ServeHTTP. A gateway outlives
+// its processors, so messages leased via a processor replaced by reloading the configuration can still be settled.
+type Gateway struct {
+	// ready passes the messages waiting to be leased to the clients.
+	ready chan *lease
+
+	mu     sync.Mutex
+	leases map[string]*lease
+}
+
+// NewGateway creates a gateway without any messages.
+func NewGateway() *Gateway {
+	return &Gateway{
+		ready:  make(chan *lease),
+		leases: make(map[string]*lease),
+	}
+}
+
+// lease is a message waiting to be leased or leased to a client.
+type lease struct {
+	id         string
+	d          delivery.Delivery
+	ack        acknowledger.Acknowledger
+	log        logr.Logger
+	name       string
+	visibility time.Duration
+	// timer requeues the message once the visibility timeout is exceeded. It is nil until the message got leased.
+	timer *time.Timer
+	// done receives the result of settling the message.
+	done chan error
+}
+
+// ServeHTTP serves the API to lease and settle messages:
+//
+//	GET /messages?max=N&wait=DURATION
+//	POST /messages/{id}/ack
+//	POST /messages/{id}/nack
+//	POST /messages/{id}/reject
+//
+// Leasing waits up to wait, defaulting to zero, for a message and responds with a JSON array of up to max messages,
+// defaulting to one. Each message holds its id, the properties, the delivery info and the body encoded as base64. A
+// leased message is not handed out again unless it is put back into the queue.
+//
+// Settling acknowledges the message, puts it back into the queue or rejects it. The request body may hold a structured
+// response, e.g. to delay requeueing the message, of which the action is ignored. Messages not settled within the
+// visibility timeout are put back into the queue.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/messages" {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		g.fetch(w, r)
+		return
+	}
+
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) != 4 || parts[1] != "messages" || parts[2] == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	action, ok := gatewayActions[parts[3]]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	g.settle(w, r, parts[2], action)
+}
+
+// fetch leases the messages to the client.
+func (g *Gateway) fetch(w http.ResponseWriter, r *http.Request) {
+	max, wait, err := parseFetch(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	var leased []*lease
+	take := func(l *lease) {
+		if g.lease(l) {
+			leased = append(leased, l)
+		}
+	}
+
+collect:
+	for len(leased) < max {
+		select {
+		case l := <-g.ready:
+			take(l)
+			continue
+		default:
+		}
+
+		if len(leased) > 0 {
+			break
+		}
+
+		select {
+		case l := <-g.ready:
+			take(l)
+		case <-timer.C:
+			break collect
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	type message struct {
+		ID           string              `json:"id"`
+		Properties   delivery.Properties `json:"properties"`
+		DeliveryInfo delivery.Info       `json:"delivery_info"`
+		Body         []byte              `json:"body"`
+	}
+	msgs := make([]message, len(leased))
+	for i, l := range leased {
+		msgs[i] = message{ID: l.id, Properties: l.d.Properties(), DeliveryInfo: l.d.Info(), Body: l.d.Body()}
+	}
+
+	b, err := json.Marshal(msgs)
+	if err != nil {
+		for _, l := range leased {
+			g.requeue(l.id)
+		}
+		http.Error(w, fmt.Sprintf("failed to marshall messages: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// parseFetch parses the maximum number of messages and the time to wait for them from the query.
+func parseFetch(r *http.Request) (int, time.Duration, error) {
+	q := r.URL.Query()
+
+	max := 1
+	if s := q.Get("max"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return 0, 0, fmt.Errorf("invalid max %q", s)
+		}
+		max = n
+	}
+
+	var wait time.Duration
+	if s := q.Get("wait"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return 0, 0, fmt.Errorf("invalid wait %q", s)
+		}
+		wait = d
+	}
+
+	return max, wait, nil
+}
+
+// settle acknowledges the leased message according to the action and the structured response in the request body.
+func (g *Gateway) settle(w http.ResponseWriter, r *http.Request, id string, action acknowledger.Action) {
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, maxResponseSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res, err := acknowledger.ParseResponse(b)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if res == nil {
+		res = &acknowledger.Response{}
+	}
+	res.Action = action
+
+	l := g.take(id)
+	if l == nil {
+		http.Error(w, "unknown or expired lease", http.StatusNotFound)
+		return
+	}
+
+	if res.Reason != "" {
+		l.log.Infof("Reason: %s", res.Reason)
+	}
+	l.log.Info("Processed!")
+
+	if ra, ok := l.ack.(acknowledger.ResponseAcknowledger); ok {
+		err = ra.AckResponse(l.d, 0, res)
+	} else {
+		err = res.Action.Apply(l.d)
+	}
+	l.done <- err
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to aknowledge message: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// add makes the message available to be leased.
+func (g *Gateway) add(l *lease) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.leases[l.id] = l
+}
+
+// lease starts the visibility timeout of the message. It returns false if the message got taken back meanwhile.
+func (g *Gateway) lease(l *lease) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.leases[l.id] != l {
+		return false
+	}
+	l.timer = time.AfterFunc(l.visibility, func() {
+		if g.take(l.id) == nil {
+			return
+		}
+
+		l.log.Errorf("Visibility timeout of %v exceeded, requeueing message.", l.visibility)
+		collector.ProcessTimeouts.With(prometheus.Labels{"consumer": l.name}).Inc()
+		l.done <- l.d.Nack(true)
+	})
+
+	return true
+}
+
+// take removes the message from the gateway, returning nil if it got taken by someone else already.
+func (g *Gateway) take(id string) *lease {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	l, ok := g.leases[id]
+	if !ok {
+		return nil
+	}
+	delete(g.leases, id)
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+
+	return l
+}
+
+// requeue puts the message back into the queue, if not taken by someone else already.
+func (g *Gateway) requeue(id string) {
+	if l := g.take(id); l != nil {
+		l.done <- l.d.Nack(true)
+	}
+}
+
+// GatewayConfig defines the interface to present configurations to the gateway processor.
+type GatewayConfig interface {
+	ConsumerName() string
+	GatewayVisibilityTimeout() time.Duration
+}
+
+// NewGatewayFromConfig creates a processor handing the messages out to the clients of the gateway instead of running
+// an executable, see leasing.Process.
+func NewGatewayFromConfig(cfg GatewayConfig, g *Gateway, a acknowledger.Acknowledger, l logr.Logger) Processor {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &leasing{
+		name:       cfg.ConsumerName(),
+		visibility: cfg.GatewayVisibilityTimeout(),
+		gateway:    g,
+		ack:        a,
+		log:        l,
+		released:   make(chan struct{}),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+type leasing struct {
+	name       string
+	visibility time.Duration
+	gateway    *Gateway
+	ack        acknowledger.Acknowledger
+	log        logr.Logger
+	// released is closed once the processor got released.
+	released chan struct{}
+	once     sync.Once
+	// mu guards releasing the processor against messages starting to be processed, so busy can be waited for.
+	mu   sync.RWMutex
+	busy sync.WaitGroup
+	// ctx is canceled once the processor got stopped, putting the leased messages back into the queue.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Process waits for the message to be leased by a client of the gateway and to be settled by it. The number of
+// messages available to the clients at once is limited by the concurrency, which is the prefetch count with a gateway.
+//
+// Once the processor got released, the messages not leased yet, like all further messages, are put back into the
+// queue. Once it got stopped, the leased messages are put back into the queue as well.
+func (p *leasing) Process(d delivery.Delivery) error {
+	if !p.begin() {
+		d.Nack(true)
+		return nil
+	}
+	defer p.busy.Done()
+
+	id, err := newLeaseID()
+	if err != nil {
+		d.Nack(true)
+		return NewCreateCommandError(err)
+	}
+
+	l := &lease{
+		id:         id,
+		d:          d,
+		ack:        p.ack,
+		log:        p.log,
+		name:       p.name,
+		visibility: p.visibility,
+		done:       make(chan error, 1),
+	}
+	p.gateway.add(l)
+
+	p.log.Info("Waiting for message to be leased...")
+	select {
+	case p.gateway.ready <- l:
+	case <-p.released:
+		return p.requeue(l)
+	case <-p.ctx.Done():
+		return p.requeue(l)
+	}
+
+	start := time.Now()
+	err = p.wait(l)
+	observe(p.name, d, start)
+
+	if err != nil {
+		return NewAcknowledgmentError(err)
+	}
+
+	return nil
+}
+
+// begin counts the message as being processed, unless the processor got released or stopped.
+func (p *leasing) begin() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	select {
+	case <-p.released:
+		return false
+	case <-p.ctx.Done():
+		return false
+	default:
+	}
+	p.busy.Add(1)
+
+	return true
+}
+
+// wait waits for the leased message to be settled or its lease to expire.
+func (p *leasing) wait(l *lease) error {
+	select {
+	case err := <-l.done:
+		return err
+	case <-p.ctx.Done():
+		if p.gateway.take(l.id) != nil {
+			p.log.Info("Requeueing message of interrupted lease.")
+			l.d.Nack(true)
+			return nil
+		}
+		return <-l.done
+	}
+}
+
+// requeue puts the message not leased back into the queue.
+func (p *leasing) requeue(l *lease) error {
+	p.gateway.take(l.id)
+	p.log.Info("Requeueing message not leased.")
+	l.d.Nack(true)
+
+	return nil
+}
+
+// Release puts the messages not leased yet back into the queue, as well as all further messages.
+func (p *leasing) Release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.once.Do(func() {
+		close(p.released)
+	})
+}
+
+// Close releases the processor, putting the messages not leased yet back into the queue, and waits for the leased
+// messages to be settled or their lease to expire.
+func (p *leasing) Close() error {
+	p.Release()
+	p.busy.Wait()
+
+	return nil
+}
+
+// Stop puts the leased messages back into the queue, as well as all further messages.
+func (p *leasing) Stop() {
+	p.cancel()
+}
+
+// Kill puts the leased messages back into the queue, same as Stop.
+func (p *leasing) Kill() {
+	p.cancel()
+}
+
+// newLeaseID creates a random id for a lease.
+func newLeaseID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to create lease id: %v", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
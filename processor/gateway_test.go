@@ -0,0 +1,249 @@
+package processor
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/corvus-ch/logr/buffered"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/acknowledger"
+	"github.com/stretchr/testify/assert"
+)
+
+type testGatewayConfig struct {
+	visibility time.Duration
+}
+
+func (c testGatewayConfig) ConsumerName() string                    { return "" }
+func (c testGatewayConfig) GatewayVisibilityTimeout() time.Duration { return c.visibility }
+
+type leasedMessage struct {
+	ID   string `json:"id"`
+	Body []byte `json:"body"`
+}
+
+// fetch leases the messages from the gateway.
+func fetch(t *testing.T, srv *httptest.Server, query string) []leasedMessage {
+	res, err := http.Get(srv.URL + "/messages?" + query)
+	if err != nil {
+		t.Fatalf("failed to fetch messages: %v", err)
+	}
+	defer res.Body.Close()
+
+	var msgs []leasedMessage
+	if err := json.NewDecoder(res.Body).Decode(&msgs); err != nil {
+		t.Fatalf("failed to decode messages: %v", err)
+	}
+
+	return msgs
+}
+
+// settle settles the leased message, returning the HTTP status.
+func settle(t *testing.T, srv *httptest.Server, id, action, body string) int {
+	res, err := http.Post(srv.URL+"/messages/"+id+"/"+action, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to settle message: %v", err)
+	}
+	res.Body.Close()
+
+	return res.StatusCode
+}
+
+var gatewayTests = []struct {
+	name     string
+	action   string
+	body     string
+	response *acknowledger.Response
+}{
+	{"ack", "ack", "", &acknowledger.Response{Action: acknowledger.ActionAck}},
+	{"nack", "nack", `{"delay":"30s"}`, &acknowledger.Response{Action: acknowledger.ActionRequeue, Delay: 30 * time.Second}},
+	{"reject", "reject", `{"action":"ack","reason":"invalid"}`, &acknowledger.Response{Action: acknowledger.ActionReject, Reason: "invalid"}},
+}
+
+func TestGateway_Settle(t *testing.T) {
+	g := NewGateway()
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+
+	for _, test := range gatewayTests {
+		t.Run(test.name, func(t *testing.T) {
+			l := log.New(0)
+			a := new(TestAcknowledger)
+			d := new(TestDelivery)
+			p := NewGatewayFromConfig(testGatewayConfig{visibility: time.Minute}, g, a, l)
+
+			d.On("Body").Return([]byte(test.name))
+			d.On("Properties").Return(properties)
+			d.On("Info").Return(info)
+			a.On("AckResponse", d, 0, test.response).Return(nil)
+
+			done := make(chan error)
+			go func() {
+				done <- p.Process(d)
+			}()
+
+			msgs := fetch(t, srv, "max=5&wait=1s")
+			if assert.Len(t, msgs, 1) {
+				assert.Equal(t, test.name, string(msgs[0].Body))
+				assert.Equal(t, http.StatusNoContent, settle(t, srv, msgs[0].ID, test.action, test.body))
+				assert.Equal(t, http.StatusNotFound, settle(t, srv, msgs[0].ID, test.action, test.body))
+			}
+
+			assert.Nil(t, <-done)
+			a.AssertExpectations(t)
+			d.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGateway_Expire(t *testing.T) {
+	g := NewGateway()
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+
+	l := log.New(0)
+	a := new(TestAcknowledger)
+	d := new(TestDelivery)
+	p := NewGatewayFromConfig(testGatewayConfig{visibility: 100 * time.Millisecond}, g, a, l)
+
+	d.On("Body").Return([]byte("expire"))
+	d.On("Properties").Return(properties)
+	d.On("Info").Return(info)
+	d.On("Nack", true).Return(nil)
+
+	done := make(chan error)
+	go func() {
+		done <- p.Process(d)
+	}()
+
+	msgs := fetch(t, srv, "wait=1s")
+	assert.Nil(t, <-done)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, http.StatusNotFound, settle(t, srv, msgs[0].ID, "ack", ""))
+	}
+	assert.Equal(t, "INFO Waiting for message to be leased...\nERROR Visibility timeout of 100ms exceeded, requeueing message.\n", l.Buf().String())
+	d.AssertExpectations(t)
+}
+
+func TestGateway_Empty(t *testing.T) {
+	srv := httptest.NewServer(NewGateway())
+	defer srv.Close()
+
+	assert.Empty(t, fetch(t, srv, "wait=10ms"))
+}
+
+var gatewayRequestTests = []struct {
+	name   string
+	method string
+	path   string
+	status int
+}{
+	{"invalidMax", http.MethodGet, "/messages?max=0", http.StatusBadRequest},
+	{"invalidWait", http.MethodGet, "/messages?wait=soon", http.StatusBadRequest},
+	{"postMessages", http.MethodPost, "/messages", http.StatusMethodNotAllowed},
+	{"getSettle", http.MethodGet, "/messages/42/ack", http.StatusMethodNotAllowed},
+	{"unknownAction", http.MethodPost, "/messages/42/retry", http.StatusNotFound},
+	{"unknownLease", http.MethodPost, "/messages/42/ack", http.StatusNotFound},
+	{"unknownPath", http.MethodGet, "/", http.StatusNotFound},
+}
+
+func TestGateway_ServeHTTP(t *testing.T) {
+	g := NewGateway()
+	for _, test := range gatewayRequestTests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			g.ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+			assert.Equal(t, test.status, w.Code)
+		})
+	}
+}
+
+func TestGateway_Release(t *testing.T) {
+	l := log.New(0)
+	a := new(TestAcknowledger)
+	d := new(TestDelivery)
+	p := NewGatewayFromConfig(testGatewayConfig{visibility: time.Minute}, NewGateway(), a, l)
+
+	d.On("Nack", true).Return(nil).Twice()
+
+	time.AfterFunc(100*time.Millisecond, p.(Releaser).Release)
+	assert.Nil(t, p.Process(d))
+	assert.Nil(t, p.Process(d))
+	assert.Equal(t, "INFO Waiting for message to be leased...\nINFO Requeueing message not leased.\n", l.Buf().String())
+	d.AssertExpectations(t)
+}
+
+func TestGateway_Stop(t *testing.T) {
+	g := NewGateway()
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+
+	l := log.New(0)
+	a := new(TestAcknowledger)
+	d := new(TestDelivery)
+	p := NewGatewayFromConfig(testGatewayConfig{visibility: time.Minute}, g, a, l)
+
+	d.On("Body").Return([]byte("stop"))
+	d.On("Properties").Return(properties)
+	d.On("Info").Return(info)
+	d.On("Nack", true).Return(nil)
+
+	done := make(chan error)
+	go func() {
+		done <- p.Process(d)
+	}()
+
+	msgs := fetch(t, srv, "wait=1s")
+	p.(Stopper).Stop()
+	assert.Nil(t, <-done)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, http.StatusNotFound, settle(t, srv, msgs[0].ID, "ack", ""))
+	}
+	assert.Equal(t, "INFO Waiting for message to be leased...\nINFO Requeueing message of interrupted lease.\n", l.Buf().String())
+	d.AssertExpectations(t)
+}
+
+func TestGateway_Close(t *testing.T) {
+	g := NewGateway()
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+
+	l := log.New(0)
+	a := new(TestAcknowledger)
+	d := new(TestDelivery)
+	p := NewGatewayFromConfig(testGatewayConfig{visibility: time.Minute}, g, a, l)
+
+	d.On("Body").Return([]byte("close"))
+	d.On("Properties").Return(properties)
+	d.On("Info").Return(info)
+	a.On("AckResponse", d, 0, &acknowledger.Response{Action: acknowledger.ActionAck}).Return(nil)
+
+	done := make(chan error)
+	go func() {
+		done <- p.Process(d)
+	}()
+
+	msgs := fetch(t, srv, "wait=1s")
+	closed := make(chan error)
+	go func() {
+		closed <- p.(io.Closer).Close()
+	}()
+
+	select {
+	case <-closed:
+		t.Fatal("closed before the leased message got settled")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, http.StatusNoContent, settle(t, srv, msgs[0].ID, "ack", ""))
+	}
+	assert.Nil(t, <-done)
+	assert.Nil(t, <-closed)
+	a.AssertExpectations(t)
+	d.AssertExpectations(t)
+}
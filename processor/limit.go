@@ -0,0 +1,118 @@
+package processor
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+)
+
+// Counter counts the messages passed to the processors it wrapped, across all of them, and limits their number.
+type Counter struct {
+	max     int64
+	count   int64
+	reached chan struct{}
+	once    sync.Once
+}
+
+// NewCounter creates a new counter allowing max messages to be processed.
+func NewCounter(max int) *Counter {
+	return &Counter{max: int64(max), reached: make(chan struct{})}
+}
+
+// Reached returns a channel being closed once the last allowed message is passed on for processing.
+func (c *Counter) Reached() <-chan struct{} {
+	return c.reached
+}
+
+// take counts a message and checks if it is allowed to be processed.
+func (c *Counter) take() bool {
+	n := atomic.AddInt64(&c.count, 1)
+	if n == c.max {
+		c.once.Do(func() {
+			close(c.reached)
+		})
+	}
+
+	return n <= c.max
+}
+
+// Wrap creates a processor counting the messages before passing them on to p. Messages exceeding the limit are
+// requeued without being processed.
+func (c *Counter) Wrap(p Processor) Processor {
+	return &limited{Processor: p, counter: c}
+}
+
+// limited is a processor counting the messages it processes.
+type limited struct {
+	Processor
+	counter *Counter
+}
+
+// Process passes the message on to the wrapped processor, unless the limit has been exceeded.
+func (p *limited) Process(d delivery.Delivery) error {
+	if !p.counter.take() {
+		return d.Nack(true)
+	}
+
+	return p.Processor.Process(d)
+}
+
+// ProcessBatch passes the messages on to the wrapped processor, requeueing those exceeding the limit.
+func (p *limited) ProcessBatch(ds []delivery.Delivery) error {
+	var allowed []delivery.Delivery
+	for _, d := range ds {
+		if !p.counter.take() {
+			d.Nack(true)
+			continue
+		}
+		allowed = append(allowed, d)
+	}
+
+	if len(allowed) == 0 {
+		return nil
+	}
+
+	if bp, ok := p.Processor.(BatchProcessor); ok {
+		return bp.ProcessBatch(allowed)
+	}
+
+	for _, d := range allowed {
+		if err := p.Processor.Process(d); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Stop stops the wrapped processor, if supported.
+func (p *limited) Stop() {
+	if s, ok := p.Processor.(Stopper); ok {
+		s.Stop()
+	}
+}
+
+// Kill kills the commands of the wrapped processor, if supported.
+func (p *limited) Kill() {
+	if s, ok := p.Processor.(Stopper); ok {
+		s.Kill()
+	}
+}
+
+// Release releases the wrapped processor, if supported.
+func (p *limited) Release() {
+	if r, ok := p.Processor.(Releaser); ok {
+		r.Release()
+	}
+}
+
+// Close closes the wrapped processor, if supported.
+func (p *limited) Close() error {
+	if c, ok := p.Processor.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package processor
+
+import (
+	"testing"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+	"github.com/stretchr/testify/assert"
+)
+
+type processorFunc func(delivery.Delivery) error
+
+func (f processorFunc) Process(d delivery.Delivery) error {
+	return f(d)
+}
+
+func TestCounter(t *testing.T) {
+	var processed int
+	c := NewCounter(2)
+	p := processorFunc(func(delivery.Delivery) error {
+		processed++
+		return nil
+	})
+	a, b := c.Wrap(p), c.Wrap(p)
+
+	d := new(TestDelivery)
+	d.On("Nack", true).Return(nil).Once()
+
+	assert.Nil(t, a.Process(d))
+	assertNotReached(t, c)
+	assert.Nil(t, b.Process(d))
+	assert.Nil(t, a.Process(d))
+	assert.Equal(t, 2, processed)
+	d.AssertExpectations(t)
+
+	select {
+	case <-c.Reached():
+	default:
+		t.Error("limit not reached")
+	}
+}
+
+func assertNotReached(t *testing.T, c *Counter) {
+	select {
+	case <-c.Reached():
+		t.Error("limit reached too early")
+	default:
+	}
+}
+
+func TestCounter_Batch(t *testing.T) {
+	var processed int
+	c := NewCounter(2)
+	p := c.Wrap(processorFunc(func(delivery.Delivery) error {
+		processed++
+		return nil
+	}))
+
+	d := new(TestDelivery)
+	d.On("Nack", true).Return(nil).Once()
+
+	assert.Nil(t, p.(BatchProcessor).ProcessBatch([]delivery.Delivery{d, d, d}))
+	assert.Equal(t, 2, processed)
+	d.AssertExpectations(t)
+}
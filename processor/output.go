@@ -0,0 +1,171 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+	"github.com/streadway/amqp"
+)
+
+// Sources of the messages to be published.
+const (
+	// PublishStdout reads the messages to be published from STDOUT.
+	PublishStdout = "stdout"
+	// PublishFd reads the messages to be published from OutputFd.
+	PublishFd = "fd"
+)
+
+// OutputFd is the file descriptor the executable writes the messages to be published to.
+const OutputFd = 5
+
+// maxOutputSize limits the size of the output read from the executable.
+const maxOutputSize = 16 * 1024 * 1024
+
+// Message is a message written by the executable to be published. The executable writes one JSON object per message.
+// Messages are published persistently. If Base64 is set, the body is decoded before publishing.
+type Message struct {
+	Exchange    string                 `json:"exchange"`
+	RoutingKey  string                 `json:"routing_key"`
+	Headers     map[string]interface{} `json:"headers"`
+	ContentType string                 `json:"content_type"`
+	Body        string                 `json:"body"`
+	Base64      bool                   `json:"base64"`
+}
+
+// Publishing converts the message into an AMQP message.
+func (m Message) Publishing() (amqp.Publishing, error) {
+	body := []byte(m.Body)
+	if m.Base64 {
+		b, err := base64.StdEncoding.DecodeString(m.Body)
+		if err != nil {
+			return amqp.Publishing{}, fmt.Errorf("invalid body: %v", err)
+		}
+		body = b
+	}
+
+	msg := amqp.Publishing{
+		ContentType:  m.ContentType,
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    time.Now(),
+		Body:         body,
+	}
+	if len(m.Headers) > 0 {
+		msg.Headers = delivery.NewTable(m.Headers)
+	}
+
+	return msg, nil
+}
+
+// ParseMessages parses the messages written by the executable, e.g. one JSON object per line.
+func ParseMessages(b []byte) ([]Message, error) {
+	var msgs []Message
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	for {
+		var m Message
+		err := dec.Decode(&m)
+		if err == io.EOF {
+			return msgs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse message %d: %v", len(msgs)+1, err)
+		}
+		msgs = append(msgs, m)
+	}
+}
+
+// output collects what the command writes to be published, either from STDOUT or from a pipe.
+type output struct {
+	buf  *bytes.Buffer
+	pipe *pipe
+}
+
+// stdout returns the writer to be attached to the commands STDOUT, if any.
+func (o *output) stdout() io.Writer {
+	if o == nil || o.buf == nil {
+		return nil
+	}
+
+	return o.buf
+}
+
+// bytes returns the collected output once the command exited.
+func (o *output) bytes() []byte {
+	if o.pipe != nil {
+		return o.pipe.wait()
+	}
+
+	return o.buf.Bytes()
+}
+
+// close releases the pipe, if any.
+func (o *output) close() {
+	if o != nil && o.pipe != nil {
+		o.pipe.close()
+	}
+}
+
+// newOutputPipe creates a pipe whose write end is passed to the command as OutputFd.
+func newOutputPipe(cmd *exec.Cmd) (*output, error) {
+	if len(cmd.ExtraFiles) > OutputFd-3 {
+		return nil, fmt.Errorf("file descriptor %d already in use", OutputFd)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output pipe: %v", err)
+	}
+
+	// Unused file descriptors in between are closed in the child.
+	for len(cmd.ExtraFiles) < OutputFd-3 {
+		cmd.ExtraFiles = append(cmd.ExtraFiles, nil)
+	}
+	cmd.ExtraFiles = append(cmd.ExtraFiles, w)
+
+	return &output{pipe: newPipe(r, maxOutputSize)}, nil
+}
+
+// pipe reads from the read end of a pipe until the command closed its end.
+type pipe struct {
+	r    io.ReadCloser
+	data chan []byte
+}
+
+// newPipe starts reading from r. Everything beyond the maximum size is discarded, so the command does not block while
+// writing.
+func newPipe(r io.ReadCloser, max int64) *pipe {
+	p := &pipe{r: r, data: make(chan []byte, 1)}
+	go func() {
+		b, _ := ioutil.ReadAll(io.LimitReader(r, max))
+		io.Copy(ioutil.Discard, r)
+		p.data <- b
+	}()
+
+	return p
+}
+
+// wait returns what has been read once the command exited. It only waits for a limited time, as a child of the command
+// may have inherited the file descriptor and still be running.
+func (p *pipe) wait() []byte {
+	select {
+	case b := <-p.data:
+		return b
+
+	case <-time.After(pipeGrace):
+		p.r.Close()
+		return <-p.data
+	}
+}
+
+func (p *pipe) close() {
+	p.r.Close()
+}
@@ -0,0 +1,383 @@
+package processor
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/bketelsen/logr"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/acknowledger"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/collector"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PoolConfig defines the interface to present configurations to the worker pool.
+type PoolConfig interface {
+	Concurrency() int
+	ConsumerName() string
+	ExecutionTimeout() time.Duration
+	TimeoutAction() string
+	TimeoutGrace() time.Duration
+	WorkerCrashAction() string
+	WorkerMaxMemory() int64
+	WorkerMaxMessages() int
+}
+
+// NewPoolFromConfig creates a processor passing the messages to a pool of long-lived workers, see pool.Process. The
+// pool holds one worker per message processed concurrently. The output of the workers on STDERR is written to errW if
+// capture is set.
+func NewPoolFromConfig(cfg PoolConfig, executable string, capture bool, a acknowledger.Acknowledger, l logr.Logger, errW io.Writer) (Processor, error) {
+	onTimeout, err := acknowledger.ParseAction(cfg.TimeoutAction())
+	if err != nil {
+		return nil, fmt.Errorf("invalid timeout action: %v", err)
+	}
+
+	onCrash, err := acknowledger.ParseAction(cfg.WorkerCrashAction())
+	if err != nil {
+		return nil, fmt.Errorf("invalid crash action: %v", err)
+	}
+
+	p := &pool{
+		name:        cfg.ConsumerName(),
+		ack:         a,
+		log:         l,
+		timeout:     cfg.ExecutionTimeout(),
+		grace:       cfg.TimeoutGrace(),
+		onTimeout:   onTimeout,
+		onCrash:     onCrash,
+		maxMessages: cfg.WorkerMaxMessages(),
+		maxMemory:   cfg.WorkerMaxMemory(),
+	}
+
+	if split := strings.Split(executable, " "); len(split) > 1 {
+		p.cmd, p.args = split[0], split[1:]
+	} else {
+		p.cmd = executable
+	}
+
+	if capture {
+		p.stderr = errW
+	}
+
+	p.init(cfg.Concurrency())
+
+	return p, nil
+}
+
+type pool struct {
+	name        string
+	cmd         string
+	args        []string
+	stderr      io.Writer
+	ack         acknowledger.Acknowledger
+	log         logr.Logger
+	timeout     time.Duration
+	grace       time.Duration
+	onTimeout   acknowledger.Action
+	onCrash     acknowledger.Action
+	maxMessages int
+	maxMemory   int64
+
+	// idle holds the workers waiting for a message. A nil entry is a slot for a worker yet to be started.
+	idle chan *worker
+	// stop is closed once the pool got stopped.
+	stop     chan struct{}
+	stopOnce sync.Once
+	// closed is closed once the pool got closed.
+	closed    chan struct{}
+	closeOnce sync.Once
+	mu        sync.Mutex
+	busy      map[*worker]struct{}
+	started   int32
+}
+
+func (p *pool) init(size int) {
+	if size < 1 {
+		size = 1
+	}
+
+	p.idle = make(chan *worker, size)
+	for i := 0; i < size; i++ {
+		p.idle <- nil
+	}
+	p.stop = make(chan struct{})
+	p.closed = make(chan struct{})
+	p.busy = make(map[*worker]struct{})
+}
+
+// Process passes the message to an idle worker, starting one if needed, and acknowledges it according to the result
+// of the worker. The result is a response, optionally including an exit code, which is handled by the acknowledger.
+//
+// A worker is recycled once it processed the maximum number of messages or exceeds the memory limit. If it crashes
+// while processing a message or answers with a result failing to parse, it gets killed and the message is
+// acknowledged according to the crash action. If it exceeds the execution timeout, which the message may override
+// with the TimeoutHeader, it gets terminated and the message is acknowledged according to the timeout action.
+//
+// Once the pool got stopped, the workers are terminated and their messages, like all further messages, are put back
+// into the queue. Once it got closed, all further messages are put back into the queue.
+func (p *pool) Process(d delivery.Delivery) error {
+	select {
+	case <-p.stop:
+		d.Nack(true)
+		return nil
+	case <-p.closed:
+		d.Nack(true)
+		return nil
+	default:
+	}
+
+	var w *worker
+	select {
+	case w = <-p.idle:
+	case <-p.stop:
+		d.Nack(true)
+		return nil
+	case <-p.closed:
+		d.Nack(true)
+		return nil
+	}
+	defer func() {
+		p.idle <- w
+	}()
+
+	if w != nil && w.exited() {
+		p.crashed(w)
+		w = nil
+	}
+
+	if w == nil {
+		var err error
+		if w, err = p.start(); err != nil {
+			d.Nack(true)
+			return NewCreateCommandError(err)
+		}
+	}
+
+	p.log.Infof("Processing message with worker %d...", w.id)
+	start := time.Now()
+	b, err, timedOut := p.exchange(w, d)
+	duration := time.Since(start)
+
+	labels := prometheus.Labels{"consumer": p.name}
+	collector.ProcessDuration.With(labels).Observe(duration.Seconds())
+	if !d.Properties().Timestamp.IsZero() {
+		collector.MessageDuration.With(labels).Observe(time.Since(d.Properties().Timestamp).Seconds())
+	}
+
+	if p.stopped() && (err != nil || timedOut) {
+		p.log.Info("Requeueing message of interrupted worker.")
+		w = nil
+		d.Nack(true)
+		return nil
+	}
+
+	if timedOut {
+		p.count(exitCode(w.err))
+		w = nil
+		collector.ProcessTimeouts.With(labels).Inc()
+		if err := p.onTimeout.Apply(d); err != nil {
+			return NewAcknowledgmentError(err)
+		}
+
+		return nil
+	}
+
+	var code int
+	var res *acknowledger.Response
+	if err == nil {
+		// A result failing to parse breaks the protocol, the worker is not to be trusted with further messages.
+		code, res, err = parseResult(b)
+	}
+
+	if err != nil {
+		p.log.Errorf("Worker %d failed: %v", w.id, err)
+		w.kill()
+		p.crashed(w)
+		p.count(exitCode(w.err))
+		w = nil
+		if err := p.onCrash.Apply(d); err != nil {
+			return NewAcknowledgmentError(err)
+		}
+
+		return nil
+	}
+
+	if res != nil && res.Reason != "" {
+		p.log.Infof("Reason: %s", res.Reason)
+	}
+	p.count(code)
+	p.log.Info("Processed!")
+
+	if p.exhausted(w) {
+		p.retire(w)
+		w = nil
+	}
+
+	if ra, ok := p.ack.(acknowledger.ResponseAcknowledger); ok && res != nil {
+		err = ra.AckResponse(d, code, res)
+	} else {
+		err = p.ack.Ack(d, code)
+	}
+	if err != nil {
+		return NewAcknowledgmentError(err)
+	}
+
+	return nil
+}
+
+// exchange passes the message to the worker and waits for its result. Once the timeout, as overridden by the message
+// header, is exceeded or the pool gets stopped, the worker gets terminated. The last return value tells if the timeout
+// was exceeded.
+func (p *pool) exchange(w *worker, d delivery.Delivery) ([]byte, error, bool) {
+	type result struct {
+		b   []byte
+		err error
+	}
+
+	p.track(w)
+	defer p.untrack(w)
+
+	done := make(chan result, 1)
+	go func() {
+		b, err := w.exchange(d)
+		done <- result{b, err}
+	}()
+
+	var expired <-chan time.Time
+	timeout := messageTimeout(d.Properties(), p.timeout, p.log)
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case r := <-done:
+		return r.b, r.err, false
+
+	case <-expired:
+		p.log.Errorf("Timeout of %v exceeded, terminating worker %d...", timeout, w.id)
+		w.terminate(p.grace)
+		return nil, nil, true
+
+	case <-p.stop:
+		p.log.Infof("Shutting down, terminating worker %d...", w.id)
+		w.terminate(p.grace)
+		r := <-done
+		return r.b, fmt.Errorf("worker terminated"), false
+	}
+}
+
+// start starts a new worker.
+func (p *pool) start() (*worker, error) {
+	id := int(atomic.AddInt32(&p.started, 1))
+	w, err := startWorker(id, p.cmd, p.args, p.stderr)
+	if err != nil {
+		return nil, err
+	}
+	p.log.Infof("Started worker %d.", w.id)
+
+	return w, nil
+}
+
+// exhausted checks if the worker processed the maximum number of messages or exceeds the memory limit.
+func (p *pool) exhausted(w *worker) bool {
+	if p.maxMessages > 0 && w.messages >= p.maxMessages {
+		p.log.Infof("Worker %d processed %d messages, recycling it.", w.id, w.messages)
+		return true
+	}
+
+	if p.maxMemory > 0 {
+		m, err := w.memory()
+		if err != nil {
+			return false
+		}
+		if m > p.maxMemory {
+			p.log.Infof("Worker %d uses %d bytes of memory, recycling it.", w.id, m)
+			return true
+		}
+	}
+
+	return false
+}
+
+// retire stops the worker in the background, letting it finish on its own.
+func (p *pool) retire(w *worker) {
+	go w.stop(p.grace)
+}
+
+// count records a processed message by its exit code. For workers which crashed or got terminated, it is the exit
+// code of their process.
+func (p *pool) count(code int) {
+	collector.ProcessCounter.With(prometheus.Labels{"consumer": p.name, "exit_code": strconv.Itoa(code)}).Inc()
+}
+
+// crashed logs the exit of a worker, including the last lines it wrote to STDERR.
+func (p *pool) crashed(w *worker) {
+	<-w.done
+	p.log.Errorf("Worker %d exited: %v", w.id, w.err)
+	if s := w.stderr.String(); s != "" {
+		p.log.Errorf("Worker %d wrote: %s", w.id, s)
+	}
+}
+
+// Close waits for the busy workers to finish, then stops all workers and waits for them to exit. Messages passed to
+// the pool afterwards are put back into the queue.
+func (p *pool) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+		for i := 0; i < cap(p.idle); i++ {
+			if w := <-p.idle; w != nil {
+				w.stop(p.grace)
+			}
+		}
+	})
+
+	return nil
+}
+
+// Stop terminates the busy workers like exceeding the timeout does. Their messages as well as all messages processed
+// afterwards are put back into the queue.
+func (p *pool) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+}
+
+// Kill stops the pool and kills the busy workers right away.
+func (p *pool) Kill() {
+	p.Stop()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for w := range p.busy {
+		signalGroup(w.cmd, syscall.SIGKILL)
+	}
+}
+
+func (p *pool) stopped() bool {
+	select {
+	case <-p.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *pool) track(w *worker) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.busy[w] = struct{}{}
+}
+
+func (p *pool) untrack(w *worker) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.busy, w)
+}
@@ -0,0 +1,243 @@
+package processor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bketelsen/logr"
+	log "github.com/corvus-ch/logr/buffered"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/acknowledger"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestPool(a acknowledger.Acknowledger, l logr.Logger) *pool {
+	p := &pool{
+		cmd:       "env",
+		args:      []string{"GO_WANT_HELPER_PROCESS=1", os.Args[0], "-test.run=TestHelperProcess", "--", "worker"},
+		ack:       a,
+		log:       l,
+		grace:     100 * time.Millisecond,
+		onTimeout: acknowledger.ActionRequeue,
+		onCrash:   acknowledger.ActionRequeue,
+	}
+	p.init(1)
+
+	return p
+}
+
+var poolTests = []struct {
+	name    string
+	body    string
+	timeout time.Duration
+	setup   func(*TestAcknowledger, *TestDelivery)
+	output  string
+}{
+	{
+		"response",
+		"ack",
+		0,
+		func(a *TestAcknowledger, d *TestDelivery) {
+			a.On("AckResponse", d, 0, &acknowledger.Response{Action: acknowledger.ActionAck}).Return(nil)
+		},
+		"INFO Started worker 1.\nINFO Processing message with worker 1...\nINFO Processed!\n",
+	},
+	{
+		"exitCode",
+		"fail",
+		0,
+		func(a *TestAcknowledger, d *TestDelivery) {
+			a.On("AckResponse", d, 3, &acknowledger.Response{}).Return(nil)
+		},
+		"INFO Started worker 1.\nINFO Processing message with worker 1...\nINFO Processed!\n",
+	},
+	{
+		"empty",
+		"",
+		0,
+		func(a *TestAcknowledger, d *TestDelivery) {
+			a.On("Ack", d, 0).Return(nil)
+		},
+		"INFO Started worker 1.\nINFO Processing message with worker 1...\nINFO Processed!\n",
+	},
+	{
+		"crash",
+		"crash",
+		0,
+		func(a *TestAcknowledger, d *TestDelivery) {
+			d.On("Nack", true).Return(nil)
+		},
+		"INFO Started worker 1.\nINFO Processing message with worker 1...\nERROR Worker 1 failed: EOF\nERROR Worker 1 exited: exit status 1\nERROR Worker 1 wrote: boom\n",
+	},
+	{
+		"garbled",
+		"garbled",
+		0,
+		func(a *TestAcknowledger, d *TestDelivery) {
+			d.On("Nack", true).Return(nil)
+		},
+		"INFO Started worker 1.\nINFO Processing message with worker 1...\nERROR Worker 1 failed: invalid response: unknown action \"bogus\"\nERROR Worker 1 exited: signal: killed\n",
+	},
+	{
+		"timeout",
+		"sleep",
+		100 * time.Millisecond,
+		func(a *TestAcknowledger, d *TestDelivery) {
+			d.On("Nack", true).Return(nil)
+		},
+		"INFO Started worker 1.\nINFO Processing message with worker 1...\nERROR Timeout of 100ms exceeded, terminating worker 1...\n",
+	},
+}
+
+func TestPool_Process(t *testing.T) {
+	for _, test := range poolTests {
+		t.Run(test.name, func(t *testing.T) {
+			l := log.New(0)
+			a := new(TestAcknowledger)
+			d := new(TestDelivery)
+			p := newTestPool(a, l)
+			p.timeout = test.timeout
+			defer p.Close()
+
+			d.On("Body").Return([]byte(test.body))
+			d.On("Properties").Return(properties)
+			d.On("Info").Return(info)
+			test.setup(a, d)
+
+			assert.Nil(t, p.Process(d))
+			assert.Equal(t, test.output, l.Buf().String())
+			a.AssertExpectations(t)
+			d.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPool_TimeoutHeader(t *testing.T) {
+	l := log.New(0)
+	a := new(TestAcknowledger)
+	d := new(TestDelivery)
+	p := newTestPool(a, l)
+	p.timeout = time.Minute
+	defer p.Close()
+
+	props := delivery.Properties{Headers: map[string]interface{}{TimeoutHeader: "100"}}
+	d.On("Body").Return([]byte("sleep"))
+	d.On("Properties").Return(props)
+	d.On("Info").Return(info)
+	d.On("Nack", true).Return(nil)
+
+	assert.Nil(t, p.Process(d))
+	assert.Equal(t, "INFO Started worker 1.\nINFO Processing message with worker 1...\nERROR Timeout of 100ms exceeded, terminating worker 1...\n", l.Buf().String())
+	d.AssertExpectations(t)
+}
+
+func TestPool_StartFailure(t *testing.T) {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("open files can not be listed")
+	}
+
+	_, err = startWorker(1, "/nonexistent", nil, nil)
+	assert.Error(t, err)
+	after, _ := ioutil.ReadDir("/proc/self/fd")
+	assert.Equal(t, len(fds), len(after))
+}
+
+func TestPool_Recycle(t *testing.T) {
+	l := log.New(0)
+	a := new(TestAcknowledger)
+	d := new(TestDelivery)
+	p := newTestPool(a, l)
+	p.maxMessages = 2
+	defer p.Close()
+
+	d.On("Body").Return([]byte("who"))
+	d.On("Properties").Return(properties)
+	d.On("Info").Return(info)
+	a.On("AckResponse", d, 0, &acknowledger.Response{Reason: "worker 1"}).Return(nil).Twice()
+	a.On("AckResponse", d, 0, &acknowledger.Response{Reason: "worker 2"}).Return(nil).Once()
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, p.Process(d))
+	}
+	assert.Equal(t, "INFO Started worker 1.\nINFO Processing message with worker 1...\nINFO Reason: worker 1\nINFO Processed!\nINFO Processing message with worker 1...\nINFO Reason: worker 1\nINFO Processed!\nINFO Worker 1 processed 2 messages, recycling it.\nINFO Started worker 2.\nINFO Processing message with worker 2...\nINFO Reason: worker 2\nINFO Processed!\n", l.Buf().String())
+	a.AssertExpectations(t)
+	d.AssertExpectations(t)
+}
+
+func TestPool_TimeoutKillsChildren(t *testing.T) {
+	l := log.New(0)
+	a := new(TestAcknowledger)
+	d := new(TestDelivery)
+	stderr := &tail{max: 64}
+	p := newTestPool(a, l)
+	p.timeout = 500 * time.Millisecond
+	p.stderr = stderr
+	defer p.Close()
+
+	d.On("Body").Return([]byte("spawn"))
+	d.On("Properties").Return(properties)
+	d.On("Info").Return(info)
+	d.On("Nack", true).Return(nil)
+
+	assert.Nil(t, p.Process(d))
+	pid, err := strconv.Atoi(stderr.String())
+	if err != nil {
+		t.Fatalf("no PID of the child written: %v", err)
+	}
+	assert.True(t, exited(pid, 5*time.Second), "child of the worker still running")
+	a.AssertExpectations(t)
+	d.AssertExpectations(t)
+}
+
+// exited waits for the process to exit, until the timeout is exceeded. Processes not reaped yet count as exited.
+func exited(pid int, timeout time.Duration) bool {
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil || strings.Contains(string(stat), ") Z ") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestPool_Stop(t *testing.T) {
+	l := log.New(0)
+	a := new(TestAcknowledger)
+	d := new(TestDelivery)
+	p := newTestPool(a, l)
+	defer p.Close()
+
+	d.On("Body").Return([]byte("sleep"))
+	d.On("Properties").Return(properties)
+	d.On("Info").Return(info)
+	d.On("Nack", true).Return(nil).Twice()
+
+	time.AfterFunc(200*time.Millisecond, p.Stop)
+	assert.Nil(t, p.Process(d))
+	assert.Nil(t, p.Process(d))
+	assert.Equal(t, "INFO Started worker 1.\nINFO Processing message with worker 1...\nINFO Shutting down, terminating worker 1...\nINFO Requeueing message of interrupted worker.\n", l.Buf().String())
+	a.AssertExpectations(t)
+	d.AssertExpectations(t)
+}
+
+func TestPool_Close(t *testing.T) {
+	l := log.New(0)
+	a := new(TestAcknowledger)
+	d := new(TestDelivery)
+	p := newTestPool(a, l)
+
+	d.On("Nack", true).Return(nil)
+
+	assert.Nil(t, p.Close())
+	assert.Nil(t, p.Process(d))
+	assert.Nil(t, p.Close())
+	assert.Equal(t, "", l.Buf().String())
+	d.AssertExpectations(t)
+}
@@ -1,6 +1,12 @@
 package processor
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
 	"os/exec"
 	"strconv"
 	"sync"
@@ -9,89 +15,671 @@ import (
 
 	"github.com/bketelsen/logr"
 	"github.com/corvus-ch/rabbitmq-cli-consumer/acknowledger"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/backoff"
 	"github.com/corvus-ch/rabbitmq-cli-consumer/collector"
 	"github.com/corvus-ch/rabbitmq-cli-consumer/command"
 	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/streadway/amqp"
 )
 
+// TimeoutHeader is the message header overriding the configured execution timeout. Its value is either a number of
+// milliseconds, possibly given as string, or a duration like "1m30s". A value of zero disables the timeout for the
+// message.
+const TimeoutHeader = "x-timeout"
+
+// AttemptEnv is the environment variable passing the number of the current attempt, counting from one, to the
+// executable.
+const AttemptEnv = "RABBITMQ_CLI_CONSUMER_ATTEMPT"
+
+// maxResponseSize limits the size of the response read from the executable.
+const maxResponseSize = 64 * 1024
+
+// pipeGrace is the time waited for the data written to a pipe after the executable exited. It only matters if a child
+// of the executable inherited the file descriptor and is still running.
+const pipeGrace = time.Second
+
 // Processor describes the interface used by the consumer to process messages.
 type Processor interface {
 	Process(delivery.Delivery) error
 }
 
+// BatchProcessor is implemented by processors able to process several messages with one run of the executable.
+type BatchProcessor interface {
+	Processor
+	ProcessBatch([]delivery.Delivery) error
+}
+
+// Stopper is implemented by processors able to terminate the commands they are running, e.g. on shutdown.
+type Stopper interface {
+	// Stop terminates the running commands, killing them after the grace period.
+	Stop()
+	// Kill kills the running commands immediately.
+	Kill()
+}
+
+// Releaser is implemented by processors holding back messages until they are asked for, e.g. by a client of the
+// gateway.
+type Releaser interface {
+	// Release puts the messages not handed out yet back into the queue, as well as all further messages.
+	Release()
+}
+
+// Config defines the interface to present configurations to the processor.
+type Config interface {
+	ConsumerName() string
+	ExecutionTimeout() time.Duration
+	IsRpc() bool
+	RepublishExchange() string
+	RepublishRoutingKey() string
+	LocalRetryExitCodes() []string
+	LocalRetryPolicy() backoff.Policy
+	PublishSource() string
+	ReplyContentType() string
+	TimeoutAction() string
+	TimeoutGrace() time.Duration
+}
+
 // New creates a new processor instance.
 func New(b command.Builder, a acknowledger.Acknowledger, l logr.Logger) Processor {
-	return &processor{builder: b, ack: a, log: l}
+	return &processor{builder: b, ack: a, log: l, stop: make(chan struct{})}
+}
+
+// NewFromConfig creates a new processor instance according to the configuration. The publisher is used to send replies
+// in RPC mode and may be nil otherwise.
+func NewFromConfig(cfg Config, b command.Builder, a acknowledger.Acknowledger, pub acknowledger.Publisher, l logr.Logger) (Processor, error) {
+	action, err := acknowledger.ParseAction(cfg.TimeoutAction())
+	if err != nil {
+		return nil, fmt.Errorf("invalid timeout action: %v", err)
+	}
+
+	var codes []acknowledger.CodeRange
+	for _, v := range cfg.LocalRetryExitCodes() {
+		c, err := acknowledger.ParseCodeRange(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid local retry exit code %q: %v", v, err)
+		}
+		codes = append(codes, c)
+	}
+
+	switch cfg.PublishSource() {
+	case "", PublishFd:
+	case PublishStdout:
+		if cfg.IsRpc() {
+			return nil, fmt.Errorf("publishing from %s conflicts with the RPC mode", PublishStdout)
+		}
+	default:
+		return nil, fmt.Errorf("unknown publish source %q", cfg.PublishSource())
+	}
+
+	return &processor{
+		name:       cfg.ConsumerName(),
+		builder:    b,
+		ack:        a,
+		log:        l,
+		timeout:    cfg.ExecutionTimeout(),
+		grace:      cfg.TimeoutGrace(),
+		onTimeout:  action,
+		publisher:  pub,
+		rpc:        cfg.IsRpc(),
+		replyType:  cfg.ReplyContentType(),
+		publish:    cfg.PublishSource(),
+		retry:      cfg.LocalRetryPolicy(),
+		retryCodes: codes,
+
+		republishExchange: cfg.RepublishExchange(),
+		republishKey:      cfg.RepublishRoutingKey(),
+
+		stop: make(chan struct{}),
+	}, nil
 }
 
 type processor struct {
 	Processor
-	builder command.Builder
-	ack     acknowledger.Acknowledger
-	log     logr.Logger
-	mu      sync.Mutex
-	cmd     *exec.Cmd
+	name      string
+	builder   command.Builder
+	ack       acknowledger.Acknowledger
+	log       logr.Logger
+	timeout   time.Duration
+	grace     time.Duration
+	onTimeout acknowledger.Action
+	publisher acknowledger.Publisher
+	rpc       bool
+	replyType string
+	publish   string
+	retry     backoff.Policy
+	// retryCodes are the exit codes for which the command is run again. Empty means all but zero.
+	retryCodes []acknowledger.CodeRange
+	// republishExchange and republishKey are the target of the republish action. If neither is set, the action
+	// rejects the message.
+	republishExchange string
+	republishKey      string
+
+	// stop is closed once the processor got stopped.
+	stop     chan struct{}
+	stopOnce sync.Once
+	mu       sync.Mutex
+	running  map[*exec.Cmd]struct{}
 }
 
 // Process creates a new exec command using the builder and executes the command. The message gets acknowledged
 // according to the commands exit code using the acknowledger.
+//
+// Process is safe to be called concurrently, each call running its own command.
+//
+// If the command runs longer than the execution timeout, it gets terminated and the message is acknowledged according
+// to the timeout action instead. If the builder and the acknowledger support it, the response written by the command
+// takes precedence over its exit code.
+//
+// Once the processor got stopped, the running command is terminated and its message, like all further messages, is
+// put back into the queue.
+//
+// With a local retry policy, a command exiting with a retryable exit code is run again after a backoff, until it
+// succeeds or the attempts are exhausted. Only the outcome of the last attempt is acknowledged.
+//
+// If the builder passes messages as batch, the message is processed as a batch of one message, see ProcessBatch.
+//
+// In RPC mode, the output of a successful command is published to the reply queue of the message. With a publish
+// source set, the messages written by a successful command are published. In both cases, the message is only
+// acknowledged once the broker confirmed the published messages. If publishing fails, the message is requeued, so the
+// published messages are delivered at least once.
 func (p *processor) Process(d delivery.Delivery) error {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+	if _, ok := p.builder.(command.BatchBuilder); ok {
+		return p.ProcessBatch([]delivery.Delivery{d})
+	}
 
-	var err error
+	if p.stopped() {
+		d.Nack(true)
+		return nil
+	}
 
-	p.cmd, err = p.builder.GetCommand(d.Properties(), d.Info(), d.Body())
-	defer func() {
-		p.cmd = nil
-	}()
-	if err != nil {
+	var res *execution
+	for attempt := 1; ; attempt++ {
+		var err error
+		if res, err = p.attempt(d, attempt); err != nil {
+			d.Nack(true)
+			return NewCreateCommandError(err)
+		}
+
+		if !p.retryable(res) || p.retry.Exhausted(attempt) || p.stopped() {
+			break
+		}
+
+		wait := p.retry.Duration(attempt)
+		p.log.Infof("Exit code %d, retrying in %v (attempt %d)...", res.code, wait, attempt+1)
+		select {
+		case <-time.After(wait):
+		case <-p.stop:
+		}
+		if p.stopped() {
+			break
+		}
+	}
+
+	if p.stopped() && res.code != 0 {
+		p.log.Info("Requeueing message of interrupted process.")
 		d.Nack(true)
-		return NewCreateCommandError(err)
+		return nil
 	}
 
-	start := time.Now()
-	exitCode := p.run()
+	if p.republishExchange != "" || p.republishKey != "" {
+		d = &failedDelivery{Delivery: d, p: p, res: res}
+	}
 
-	collector.ProcessCounter.With(prometheus.Labels{"exit_code": strconv.Itoa(exitCode)}).Inc()
-	collector.ProcessDuration.Observe(time.Since(start).Seconds())
 	if !d.Properties().Timestamp.IsZero() {
-		collector.MessageDuration.Observe(time.Since(d.Properties().Timestamp).Seconds())
+		collector.MessageDuration.With(prometheus.Labels{"consumer": p.name}).Observe(time.Since(d.Properties().Timestamp).Seconds())
+	}
+
+	if res.timedOut {
+		if err := p.onTimeout.Apply(d); err != nil {
+			return NewAcknowledgmentError(err)
+		}
+
+		return nil
+	}
+
+	if res.reply != nil && res.code == 0 {
+		if err := p.reply(d, res.reply.Bytes()); err != nil {
+			p.log.Errorf("Failed to reply: %v", err)
+			d.Nack(true)
+			return nil
+		}
 	}
 
-	if err := p.ack.Ack(d, exitCode); err != nil {
+	if res.publish && res.code == 0 {
+		if err := p.forward(res.output); err != nil {
+			p.log.Errorf("Failed to publish output: %v", err)
+			d.Nack(true)
+			return nil
+		}
+	}
+
+	if res.responded {
+		if ra, ok := p.ack.(acknowledger.ResponseAcknowledger); ok {
+			if err := ra.AckResponse(d, res.code, res.response); err != nil {
+				return NewAcknowledgmentError(err)
+			}
+
+			return nil
+		}
+	}
+
+	if err := p.ack.Ack(d, res.code); err != nil {
 		return NewAcknowledgmentError(err)
 	}
 
 	return nil
 }
 
-func (p *processor) run() int {
+// execution is the outcome of running the command once.
+type execution struct {
+	code      int
+	timedOut  bool
+	duration  time.Duration
+	stderr    string
+	reply     *bytes.Buffer
+	publish   bool
+	output    []byte
+	responded bool
+	response  *acknowledger.Response
+}
+
+// attempt creates the command for the message and runs it, passing the number of the attempt in AttemptEnv.
+func (p *processor) attempt(d delivery.Delivery, n int) (*execution, error) {
+	cmd, resp, err := p.command(d)
+	if err != nil {
+		return nil, err
+	}
+
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%d", AttemptEnv, n))
+
+	var response *pipe
+	if resp != nil {
+		response = newPipe(resp, maxResponseSize)
+		defer response.close()
+	}
+
+	out, err := p.output(cmd)
+	if err != nil {
+		closeFiles(cmd)
+		return nil, err
+	}
+	defer out.close()
+
+	res := &execution{publish: out != nil, responded: response != nil}
+	stdout := out.stdout()
+	if p.rpc && d.Properties().ReplyTo != "" {
+		res.reply = &bytes.Buffer{}
+		stdout = res.reply
+	}
+
+	stderr := &tail{max: maxStderrSize}
+
+	start := time.Now()
+	res.code, res.timedOut = p.run(cmd, p.timeoutFor(d.Properties()), stdout, stderr)
+	res.duration = time.Since(start)
+	res.stderr = stderr.String()
+
+	labels := prometheus.Labels{"consumer": p.name}
+	collector.ProcessCounter.With(prometheus.Labels{"consumer": p.name, "exit_code": strconv.Itoa(res.code)}).Inc()
+	collector.ProcessDuration.With(labels).Observe(res.duration.Seconds())
+
+	if res.timedOut {
+		collector.ProcessTimeouts.With(labels).Inc()
+		return res, nil
+	}
+
+	if res.publish && res.code == 0 {
+		res.output = out.bytes()
+	}
+
+	if response != nil {
+		res.response = p.response(response)
+	}
+
+	return res, nil
+}
+
+// retryable checks if the command is to be run again according to the outcome of the previous attempt. Timed out
+// commands and responses specifying an action are never retried.
+func (p *processor) retryable(res *execution) bool {
+	if res.code == 0 || res.timedOut || (res.response != nil && res.response.Action != "") {
+		return false
+	}
+
+	if len(p.retryCodes) == 0 {
+		return true
+	}
+
+	for _, c := range p.retryCodes {
+		if c.Contains(res.code) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// command creates the command for the message. If the builder supports it, a reader for the response of the command
+// is returned too.
+func (p *processor) command(d delivery.Delivery) (*exec.Cmd, io.ReadCloser, error) {
+	if rb, ok := p.builder.(command.ResponseBuilder); ok {
+		return rb.GetCommandWithResponse(d.Properties(), d.Info(), d.Body())
+	}
+
+	cmd, err := p.builder.GetCommand(d.Properties(), d.Info(), d.Body())
+
+	return cmd, nil, err
+}
+
+// output prepares collecting the messages written by the command according to the publish source. It returns nil if
+// publishing is disabled.
+func (p *processor) output(cmd *exec.Cmd) (*output, error) {
+	switch p.publish {
+	case PublishStdout:
+		return &output{buf: &bytes.Buffer{}}, nil
+
+	case PublishFd:
+		return newOutputPipe(cmd)
+
+	default:
+		return nil, nil
+	}
+}
+
+// forward publishes the messages written by the command, one after the other. All messages are converted before the
+// first one is published, so invalid output does not lead to some of the messages being published. Publishing itself
+// can still fail partway, in which case the messages published so far are published again once the requeued message
+// is processed again.
+func (p *processor) forward(b []byte) error {
+	if p.publisher == nil {
+		return fmt.Errorf("no publisher available")
+	}
+
+	msgs, err := ParseMessages(b)
+	if err != nil {
+		return err
+	}
+
+	pubs := make([]amqp.Publishing, len(msgs))
+	for i, m := range msgs {
+		if pubs[i], err = m.Publishing(); err != nil {
+			return fmt.Errorf("message %d: %v", i+1, err)
+		}
+	}
+
+	for i, m := range msgs {
+		if err := p.publisher.Publish(m.Exchange, m.RoutingKey, pubs[i]); err != nil {
+			return fmt.Errorf("message %d of %d: %v", i+1, len(msgs), err)
+		}
+	}
+
+	return nil
+}
+
+// reply publishes the output of the command to the reply queue of the message using the default exchange.
+func (p *processor) reply(d delivery.Delivery, body []byte) error {
+	if p.publisher == nil {
+		return fmt.Errorf("no publisher available")
+	}
+
+	return p.publisher.Publish("", d.Properties().ReplyTo, amqp.Publishing{
+		ContentType:   p.replyType,
+		CorrelationId: d.Properties().CorrelationID,
+		Timestamp:     time.Now(),
+		Body:          body,
+	})
+}
+
+// response waits for the response of the exited command and parses it.
+func (p *processor) response(r *pipe) *acknowledger.Response {
+	res, err := acknowledger.ParseResponse(r.wait())
+	if err != nil {
+		p.log.Errorf("Ignoring response: %v", err)
+		return nil
+	}
+
+	if res != nil && res.Reason != "" {
+		p.log.Infof("Reason: %s", res.Reason)
+	}
+
+	return res
+}
+
+// run executes the command and returns its exit code. With a timeout greater than zero, the command gets terminated
+// once the timeout is exceeded, in which case the second return value is true. If stdout or stderr are not nil, the
+// output of the command is written to them, in addition to any writer set by the builder.
+func (p *processor) run(cmd *exec.Cmd, timeout time.Duration, stdout, stderr io.Writer) (int, bool) {
 	p.log.Info("Processing message...")
 	defer p.log.Info("Processed!")
 
-	var out []byte
-	var err error
-	capture := p.cmd.Stdout == nil && p.cmd.Stderr == nil
-
+	var out bytes.Buffer
+	capture := cmd.Stdout == nil && cmd.Stderr == nil
 	if capture {
-		out, err = p.cmd.CombinedOutput()
-	} else {
-		err = p.cmd.Run()
+		// STDOUT and STDERR may be written to concurrently once they get different writers.
+		w := &syncWriter{w: &out}
+		cmd.Stdout = w
+		cmd.Stderr = w
 	}
 
+	if stdout != nil {
+		if capture || cmd.Stdout == nil {
+			cmd.Stdout = stdout
+		} else {
+			cmd.Stdout = io.MultiWriter(cmd.Stdout, stdout)
+		}
+	}
+
+	if stderr != nil {
+		if cmd.Stderr == nil {
+			cmd.Stderr = stderr
+		} else {
+			cmd.Stderr = io.MultiWriter(cmd.Stderr, stderr)
+		}
+	}
+
+	timedOut, err := p.execute(cmd, timeout)
 	if err != nil {
 		p.log.Info("Failed. Check error log for details.")
 		p.log.Errorf("Error: %s\n", err)
 		if capture {
-			p.log.Errorf("Failed: %s", string(out))
+			p.log.Errorf("Failed: %s", out.String())
+		}
+
+		return exitCode(err), timedOut
+	}
+
+	return 0, timedOut
+}
+
+// execute starts the command and waits for it to exit. Once the timeout is exceeded or the processor gets stopped, the
+// process gets a SIGTERM. If it is still running after the grace period, it gets killed. The signals are sent to the
+// process group of the command, reaching the processes it spawned as well. Output still held open by such processes
+// once the command exited is abandoned after the grace period. The first return value tells if the timeout was
+// exceeded.
+func (p *processor) execute(cmd *exec.Cmd, timeout time.Duration) (bool, error) {
+	newProcessGroup(cmd)
+	cmd.WaitDelay = p.grace
+	err := cmd.Start()
+
+	// The command got its own copies of the files passed, closing ours makes sure pipes get closed once the command
+	// exits.
+	closeFiles(cmd)
+
+	if err != nil {
+		return false, err
+	}
+
+	p.track(cmd)
+	defer p.untrack(cmd)
+
+	done := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		if errors.Is(err, exec.ErrWaitDelay) {
+			p.log.Errorf("Output still open %v after the process exited, abandoning it.", p.grace)
+			err = nil
+		}
+		done <- err
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	timedOut := false
+	select {
+	case err := <-done:
+		return false, err
+
+	case <-expired:
+		timedOut = true
+		p.log.Errorf("Timeout of %v exceeded, terminating process...", timeout)
+
+	case <-p.stop:
+		p.log.Info("Shutting down, terminating process...")
+	}
+
+	signalGroup(cmd, syscall.SIGTERM)
+
+	grace := time.NewTimer(p.grace)
+	defer grace.Stop()
+	select {
+	case err := <-done:
+		return timedOut, err
+
+	case <-grace.C:
+	}
+
+	p.log.Errorf("Process did not exit within %v, killing it...", p.grace)
+	signalGroup(cmd, syscall.SIGKILL)
+
+	return timedOut, <-done
+}
+
+// Stop terminates the running commands like exceeding the timeout does. Messages of terminated commands as well as all
+// messages processed afterwards are put back into the queue.
+func (p *processor) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+}
+
+// Kill stops the processor and kills the running commands right away.
+func (p *processor) Kill() {
+	p.Stop()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for cmd := range p.running {
+		signalGroup(cmd, syscall.SIGKILL)
+	}
+}
+
+func (p *processor) stopped() bool {
+	select {
+	case <-p.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *processor) track(cmd *exec.Cmd) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.running == nil {
+		p.running = make(map[*exec.Cmd]struct{})
+	}
+	p.running[cmd] = struct{}{}
+}
+
+func (p *processor) untrack(cmd *exec.Cmd) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.running, cmd)
+}
+
+// timeoutFor returns the execution timeout for a message, which is the configured one unless overridden by the
+// message header.
+func (p *processor) timeoutFor(props delivery.Properties) time.Duration {
+	return messageTimeout(props, p.timeout, p.log)
+}
+
+// messageTimeout returns the timeout set by the message header, if any, or the given one otherwise. An invalid header
+// is logged and ignored.
+func messageTimeout(props delivery.Properties, timeout time.Duration, l logr.Logger) time.Duration {
+	v, ok := props.Headers[TimeoutHeader]
+	if !ok {
+		return timeout
+	}
+
+	t, err := parseTimeout(v)
+	if err != nil {
+		l.Errorf("Ignoring header %s: %v", TimeoutHeader, err)
+		return timeout
+	}
+
+	return t
+}
+
+func parseTimeout(v interface{}) (time.Duration, error) {
+	var ms float64
+	switch t := v.(type) {
+	case string:
+		// Many clients can only set string headers, so numbers are taken as milliseconds as well.
+		if f, err := strconv.ParseFloat(t, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+			ms = f
+			break
 		}
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			return 0, err
+		}
+		if d < 0 {
+			return 0, fmt.Errorf("negative timeout %v", d)
+		}
+		return d, nil
+
+	case int8:
+		ms = float64(t)
+	case int16:
+		ms = float64(t)
+	case int32:
+		ms = float64(t)
+	case int64:
+		ms = float64(t)
+	case int:
+		ms = float64(t)
+	case float32:
+		ms = float64(t)
+	case float64:
+		ms = t
 
-		return exitCode(err)
+	default:
+		return 0, fmt.Errorf("unsupported value %v of type %T", v, v)
 	}
 
-	return 0
+	if ms < 0 {
+		return 0, fmt.Errorf("negative timeout %v", v)
+	}
+
+	return time.Duration(ms * float64(time.Millisecond)), nil
+}
+
+// closeFiles closes the extra files passed to the command.
+func closeFiles(cmd *exec.Cmd) {
+	for _, f := range cmd.ExtraFiles {
+		if f != nil {
+			f.Close()
+		}
+	}
 }
 
 func exitCode(err error) int {
@@ -1,20 +1,28 @@
 package processor
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/bketelsen/logr"
 	log "github.com/corvus-ch/logr/buffered"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/acknowledger"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/backoff"
 	"github.com/corvus-ch/rabbitmq-cli-consumer/command"
 	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
 	"github.com/sebdah/goldie"
+	"github.com/streadway/amqp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -45,14 +53,122 @@ func TestProcessor_Run(t *testing.T) {
 	for _, test := range execCommandRunTests {
 		t.Run(test.name, func(t *testing.T) {
 			l := log.New(0)
-			p := processor{log: l, cmd: test.cmd}
+			p := processor{log: l}
 
-			assert.Equal(t, p.run(), test.code)
+			code, timedOut := p.run(test.cmd, 0, nil, nil)
+			assert.Equal(t, code, test.code)
+			assert.False(t, timedOut)
 			goldie.Assert(t, t.Name(), l.Buf().Bytes())
 		})
 	}
 }
 
+var timeoutRunTests = []struct {
+	name     string
+	cmd      *exec.Cmd
+	timeout  time.Duration
+	code     int
+	timedOut bool
+	output   string
+}{
+	{
+		"inTime",
+		testCommand("echo", true),
+		time.Minute,
+		0,
+		false,
+		"INFO Processing message...\nINFO Processed!\n",
+	},
+	{
+		"terminated",
+		testCommand("sleep", true),
+		500 * time.Millisecond,
+		-1,
+		true,
+		"INFO Processing message...\nERROR Timeout of 500ms exceeded, terminating process...\nINFO Failed. Check error log for details.\nERROR Error: signal: terminated\nINFO Processed!\n",
+	},
+	{
+		"children",
+		testCommand("spawn", true),
+		500 * time.Millisecond,
+		-1,
+		true,
+		"INFO Processing message...\nERROR Timeout of 500ms exceeded, terminating process...\nINFO Failed. Check error log for details.\nERROR Error: signal: terminated\nINFO Processed!\n",
+	},
+	{
+		"killed",
+		testCommand("ignoreTerm", true),
+		500 * time.Millisecond,
+		-1,
+		true,
+		"INFO Processing message...\nERROR Timeout of 500ms exceeded, terminating process...\nERROR Process did not exit within 100ms, killing it...\nINFO Failed. Check error log for details.\nERROR Error: signal: killed\nINFO Processed!\n",
+	},
+}
+
+func TestProcessor_Run_Timeout(t *testing.T) {
+	for _, test := range timeoutRunTests {
+		t.Run(test.name, func(t *testing.T) {
+			l := log.New(0)
+			p := processor{log: l, grace: 100 * time.Millisecond}
+
+			code, timedOut := p.run(test.cmd, test.timeout, nil, nil)
+			assert.Equal(t, test.code, code)
+			assert.Equal(t, test.timedOut, timedOut)
+			assert.Equal(t, test.output, l.Buf().String())
+		})
+	}
+}
+
+var timeoutHeaderTests = []struct {
+	name    string
+	headers map[string]interface{}
+	timeout time.Duration
+	output  string
+}{
+	{"none", nil, time.Minute, ""},
+	{"milliseconds", map[string]interface{}{TimeoutHeader: int32(1500)}, 1500 * time.Millisecond, ""},
+	{"float", map[string]interface{}{TimeoutHeader: 0.5}, 500 * time.Microsecond, ""},
+	{"string", map[string]interface{}{TimeoutHeader: "5000"}, 5 * time.Second, ""},
+	{"stringFloat", map[string]interface{}{TimeoutHeader: "0.5"}, 500 * time.Microsecond, ""},
+	{"stringNegative", map[string]interface{}{TimeoutHeader: "-5000"}, time.Minute, "ERROR Ignoring header x-timeout: negative timeout -5000\n"},
+	{"duration", map[string]interface{}{TimeoutHeader: "2m"}, 2 * time.Minute, ""},
+	{"disabled", map[string]interface{}{TimeoutHeader: int64(0)}, 0, ""},
+	{"invalid", map[string]interface{}{TimeoutHeader: "soon"}, time.Minute, "ERROR Ignoring header x-timeout: time: invalid duration \"soon\"\n"},
+	{"negative", map[string]interface{}{TimeoutHeader: int16(-1)}, time.Minute, "ERROR Ignoring header x-timeout: negative timeout -1\n"},
+	{"unsupported", map[string]interface{}{TimeoutHeader: true}, time.Minute, "ERROR Ignoring header x-timeout: unsupported value true of type bool\n"},
+}
+
+func TestProcessor_TimeoutFor(t *testing.T) {
+	for _, test := range timeoutHeaderTests {
+		t.Run(test.name, func(t *testing.T) {
+			l := log.New(0)
+			p := processor{log: l, timeout: time.Minute}
+
+			assert.Equal(t, test.timeout, p.timeoutFor(delivery.Properties{Headers: test.headers}))
+			assert.Equal(t, test.output, l.Buf().String())
+		})
+	}
+}
+
+func TestProcessor_Process_Timeout(t *testing.T) {
+	a := new(TestAcknowledger)
+	b := new(TestBuilder)
+	d := new(TestDelivery)
+	p := &processor{builder: b, ack: a, log: log.New(0), grace: time.Second, onTimeout: acknowledger.ActionReject}
+
+	pr := delivery.Properties{Headers: map[string]interface{}{TimeoutHeader: "500ms"}}
+	d.On("Body").Return([]byte(t.Name()))
+	d.On("Properties").Return(pr)
+	d.On("Info").Return(info)
+	d.On("Reject", false).Return(nil)
+	b.On("GetCommand", pr, info, []byte(t.Name())).Return(testCommand("sleep", true), nil)
+
+	assert.Nil(t, p.Process(d))
+	a.AssertExpectations(t)
+	b.AssertExpectations(t)
+	d.AssertExpectations(t)
+}
+
 var properties = delivery.Properties{}
 var info = delivery.Info{}
 
@@ -140,6 +256,49 @@ func TestHelperProcess(t *testing.T) {
 	case "error":
 		helperProcessCmdEcho(args, 1)
 
+	case "respond":
+		// The response pipe is the first and only extra file passed, hence fd3.
+		f := os.NewFile(3, "response")
+		f.Write([]byte(args[0]))
+		f.Close()
+
+	case "publish":
+		f := os.NewFile(OutputFd, "output")
+		f.Write([]byte(args[0]))
+		f.Close()
+		helperProcessCmdEcho(args[1:], 0)
+
+	case "fail":
+		code, _ := strconv.Atoi(args[0])
+		fmt.Fprint(os.Stderr, args[1])
+		os.Exit(code)
+
+	case "failUntil":
+		// Exits with 75 until the given attempt is reached.
+		attempt, _ := strconv.Atoi(os.Getenv(AttemptEnv))
+		until, _ := strconv.Atoi(args[0])
+		if attempt < until {
+			os.Exit(75)
+		}
+
+	case "sleep":
+		time.Sleep(time.Minute)
+
+	case "spawn":
+		// Leaves a child behind, holding STDOUT open.
+		child := exec.Command(os.Args[0], "-test.run=TestHelperProcess", "--", "sleep")
+		child.Env = os.Environ()
+		child.Stdout = os.Stdout
+		child.Start()
+		time.Sleep(time.Minute)
+
+	case "ignoreTerm":
+		signal.Ignore(syscall.SIGTERM)
+		time.Sleep(time.Minute)
+
+	case "worker":
+		helperProcessWorker()
+
 	case "exit":
 		code, err := strconv.Atoi(args[0])
 		if err != nil {
@@ -173,6 +332,45 @@ func helperProcessAssertArgs(args []string) {
 	}
 }
 
+// helperProcessWorker answers the messages passed as frames according to their body until STDIN gets closed.
+func helperProcessWorker() {
+	in := bufio.NewReader(os.Stdin)
+	for {
+		if _, err := readFrame(in, maxFrameSize); err != nil {
+			os.Exit(0)
+		}
+		body, err := readFrame(in, maxFrameSize)
+		if err != nil {
+			os.Exit(1)
+		}
+
+		var result string
+		switch string(body) {
+		case "ack":
+			result = `{"action":"ack"}`
+		case "fail":
+			result = `{"exit_code":3}`
+		case "garbled":
+			result = `{"exit_code":1,"action":"bogus"}`
+		case "who":
+			result = fmt.Sprintf(`{"reason":"worker %s"}`, os.Getenv(WorkerEnv))
+		case "crash":
+			fmt.Fprint(os.Stderr, "boom")
+			os.Exit(1)
+		case "sleep":
+			time.Sleep(time.Minute)
+		case "spawn":
+			// Leaves a child behind, telling its PID on STDERR.
+			child := exec.Command(os.Args[0], "-test.run=TestHelperProcess", "--", "sleep")
+			child.Env = os.Environ()
+			child.Start()
+			fmt.Fprint(os.Stderr, child.Process.Pid)
+			time.Sleep(time.Minute)
+		}
+		writeFrame(os.Stdout, []byte(result))
+	}
+}
+
 func helperProcessCmdEcho(args []string, code int) {
 	for _, a := range args {
 		fmt.Println(a)
@@ -256,3 +454,455 @@ func (t *TestAcknowledger) Ack(d delivery.Delivery, code int) error {
 
 	return argsT.Error(0)
 }
+
+func (t *TestAcknowledger) AckResponse(d delivery.Delivery, code int, r *acknowledger.Response) error {
+	argsT := t.Called(d, code, r)
+
+	return argsT.Error(0)
+}
+
+type TestResponseBuilder struct {
+	TestBuilder
+}
+
+func (b *TestResponseBuilder) GetCommandWithResponse(p delivery.Properties, d delivery.Info, body []byte) (*exec.Cmd, io.ReadCloser, error) {
+	argsT := b.Called(p, d, body)
+
+	return argsT.Get(0).(*exec.Cmd), argsT.Get(1).(io.ReadCloser), argsT.Error(2)
+}
+
+var responseTests = []struct {
+	name     string
+	response string
+	exp      *acknowledger.Response
+	output   string
+}{
+	{
+		"response",
+		`{"action":"reject","reason":"invalid address"}`,
+		&acknowledger.Response{Action: acknowledger.ActionReject, Reason: "invalid address"},
+		"INFO Processing message...\nINFO Processed!\nINFO Reason: invalid address\n",
+	},
+	{
+		"none",
+		"",
+		nil,
+		"INFO Processing message...\nINFO Processed!\n",
+	},
+	{
+		"invalid",
+		"{",
+		nil,
+		"INFO Processing message...\nINFO Processed!\nERROR Ignoring response: failed to parse response: unexpected EOF\n",
+	},
+}
+
+func TestProcessor_Process_Response(t *testing.T) {
+	for _, test := range responseTests {
+		t.Run(test.name, func(t *testing.T) {
+			r, w, err := os.Pipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cmd := testCommand("respond", true, test.response)
+			cmd.ExtraFiles = []*os.File{w}
+
+			l := log.New(0)
+			a := new(TestAcknowledger)
+			b := new(TestResponseBuilder)
+			d := new(TestDelivery)
+			p := New(b, a, l)
+
+			d.On("Body").Return([]byte(t.Name()))
+			d.On("Properties").Return(properties)
+			d.On("Info").Return(info)
+			b.On("GetCommandWithResponse", properties, info, []byte(t.Name())).Return(cmd, r, nil)
+			a.On("AckResponse", d, 0, test.exp).Return(nil)
+
+			assert.Nil(t, p.Process(d))
+			assert.Equal(t, test.output, l.Buf().String())
+			a.AssertExpectations(t)
+			b.AssertExpectations(t)
+			d.AssertExpectations(t)
+		})
+	}
+}
+
+type TestPublisher struct {
+	mock.Mock
+}
+
+func (p *TestPublisher) Publish(exchange, key string, msg amqp.Publishing) error {
+	return p.Called(exchange, key, msg).Error(0)
+}
+
+var rpcTests = []struct {
+	name    string
+	cmd     *exec.Cmd
+	replyTo string
+	setup   func(a *TestAcknowledger, p *TestPublisher, d *TestDelivery)
+}{
+	{
+		"reply",
+		testCommand("echo", false, "pong"),
+		"replies",
+		func(a *TestAcknowledger, p *TestPublisher, d *TestDelivery) {
+			p.On("Publish", "", "replies", mock.MatchedBy(func(msg amqp.Publishing) bool {
+				return msg.CorrelationId == "42" && msg.ContentType == "application/json" && string(msg.Body) == "pong\n"
+			})).Return(nil)
+			a.On("Ack", d, 0).Return(nil)
+		},
+	},
+	{
+		"replyCaptured",
+		testCommand("echo", true, "pong"),
+		"replies",
+		func(a *TestAcknowledger, p *TestPublisher, d *TestDelivery) {
+			p.On("Publish", "", "replies", mock.MatchedBy(func(msg amqp.Publishing) bool {
+				return string(msg.Body) == "pong\n"
+			})).Return(nil)
+			a.On("Ack", d, 0).Return(nil)
+		},
+	},
+	{
+		"publishError",
+		testCommand("echo", false, "pong"),
+		"replies",
+		func(a *TestAcknowledger, p *TestPublisher, d *TestDelivery) {
+			p.On("Publish", "", "replies", mock.Anything).Return(errors.New("channel closed"))
+			d.On("Nack", true).Return(nil)
+		},
+	},
+	{
+		"noReplyTo",
+		testCommand("echo", false, "pong"),
+		"",
+		func(a *TestAcknowledger, p *TestPublisher, d *TestDelivery) {
+			a.On("Ack", d, 0).Return(nil)
+		},
+	},
+	{
+		"failed",
+		testCommand("error", false, "pong"),
+		"replies",
+		func(a *TestAcknowledger, p *TestPublisher, d *TestDelivery) {
+			a.On("Ack", d, 1).Return(nil)
+		},
+	},
+}
+
+func TestProcessor_Process_Rpc(t *testing.T) {
+	for _, test := range rpcTests {
+		t.Run(test.name, func(t *testing.T) {
+			a := new(TestAcknowledger)
+			b := new(TestBuilder)
+			d := new(TestDelivery)
+			pub := new(TestPublisher)
+			p := &processor{builder: b, ack: a, log: log.New(0), publisher: pub, rpc: true, replyType: "application/json"}
+
+			pr := delivery.Properties{ReplyTo: test.replyTo, CorrelationID: "42"}
+			d.On("Body").Return([]byte(t.Name()))
+			d.On("Properties").Return(pr)
+			d.On("Info").Return(info)
+			b.On("GetCommand", pr, info, []byte(t.Name())).Return(test.cmd, nil)
+			test.setup(a, pub, d)
+
+			assert.Nil(t, p.Process(d))
+			a.AssertExpectations(t)
+			b.AssertExpectations(t)
+			d.AssertExpectations(t)
+			pub.AssertExpectations(t)
+		})
+	}
+}
+
+var publishTests = []struct {
+	name   string
+	source string
+	cmd    *exec.Cmd
+	setup  func(a *TestAcknowledger, p *TestPublisher, d *TestDelivery)
+}{
+	{
+		"stdout",
+		PublishStdout,
+		testCommand("echo", false, `{"exchange":"images","routing_key":"thumbnail","body":"a.png"}`, `{"routing_key":"log","body":"ZG9uZQ==","base64":true}`),
+		func(a *TestAcknowledger, p *TestPublisher, d *TestDelivery) {
+			p.On("Publish", "images", "thumbnail", mock.MatchedBy(func(msg amqp.Publishing) bool {
+				return string(msg.Body) == "a.png" && msg.DeliveryMode == amqp.Persistent
+			})).Return(nil).Once()
+			p.On("Publish", "", "log", mock.MatchedBy(func(msg amqp.Publishing) bool {
+				return string(msg.Body) == "done"
+			})).Return(nil).Once()
+			a.On("Ack", d, 0).Return(nil)
+		},
+	},
+	{
+		"fd",
+		PublishFd,
+		testCommand("publish", false, `{"exchange":"images","headers":{"x-size":64},"content_type":"image/png","body":"a.png"}`, "not a message"),
+		func(a *TestAcknowledger, p *TestPublisher, d *TestDelivery) {
+			p.On("Publish", "images", "", mock.MatchedBy(func(msg amqp.Publishing) bool {
+				return string(msg.Body) == "a.png" && msg.ContentType == "image/png" && msg.Headers["x-size"] == int64(64)
+			})).Return(nil).Once()
+			a.On("Ack", d, 0).Return(nil)
+		},
+	},
+	{
+		"nothing",
+		PublishFd,
+		testCommand("echo", false, "lorem"),
+		func(a *TestAcknowledger, p *TestPublisher, d *TestDelivery) {
+			a.On("Ack", d, 0).Return(nil)
+		},
+	},
+	{
+		"invalid",
+		PublishStdout,
+		testCommand("echo", false, "lorem"),
+		func(a *TestAcknowledger, p *TestPublisher, d *TestDelivery) {
+			d.On("Nack", true).Return(nil)
+		},
+	},
+	{
+		"publishError",
+		PublishStdout,
+		testCommand("echo", false, `{"body":"a.png"}`),
+		func(a *TestAcknowledger, p *TestPublisher, d *TestDelivery) {
+			p.On("Publish", "", "", mock.Anything).Return(errors.New("rejected by broker"))
+			d.On("Nack", true).Return(nil)
+		},
+	},
+	{
+		"invalidBody",
+		PublishStdout,
+		testCommand("echo", false, `{"body":"a.png"}`, `{"body":"not base64","base64":true}`),
+		func(a *TestAcknowledger, p *TestPublisher, d *TestDelivery) {
+			d.On("Nack", true).Return(nil)
+		},
+	},
+	{
+		"partialPublishError",
+		PublishStdout,
+		testCommand("echo", false, `{"body":"a.png"}`, `{"body":"b.png"}`),
+		func(a *TestAcknowledger, p *TestPublisher, d *TestDelivery) {
+			p.On("Publish", "", "", mock.MatchedBy(func(msg amqp.Publishing) bool {
+				return string(msg.Body) == "a.png"
+			})).Return(nil).Once()
+			p.On("Publish", "", "", mock.MatchedBy(func(msg amqp.Publishing) bool {
+				return string(msg.Body) == "b.png"
+			})).Return(errors.New("rejected by broker")).Once()
+			d.On("Nack", true).Return(nil)
+		},
+	},
+	{
+		"failed",
+		PublishFd,
+		testCommand("error", false, `{"body":"a.png"}`),
+		func(a *TestAcknowledger, p *TestPublisher, d *TestDelivery) {
+			a.On("Ack", d, 1).Return(nil)
+		},
+	},
+}
+
+func TestProcessor_Process_Publish(t *testing.T) {
+	for _, test := range publishTests {
+		t.Run(test.name, func(t *testing.T) {
+			a := new(TestAcknowledger)
+			b := new(TestBuilder)
+			d := new(TestDelivery)
+			pub := new(TestPublisher)
+			p := &processor{builder: b, ack: a, log: log.New(0), publisher: pub, publish: test.source}
+
+			d.On("Body").Return([]byte(t.Name()))
+			d.On("Properties").Return(properties)
+			d.On("Info").Return(info)
+			b.On("GetCommand", properties, info, []byte(t.Name())).Return(test.cmd, nil)
+			test.setup(a, pub, d)
+
+			assert.Nil(t, p.Process(d))
+			a.AssertExpectations(t)
+			b.AssertExpectations(t)
+			d.AssertExpectations(t)
+			pub.AssertExpectations(t)
+		})
+	}
+}
+
+var localRetryTests = []struct {
+	name     string
+	attempts int
+	codes    []acknowledger.CodeRange
+	runs     int
+	code     int
+}{
+	{"succeeds", 2, nil, 3, 0},
+	{"exhausted", 1, nil, 2, 75},
+	{"retryableCode", 2, []acknowledger.CodeRange{{From: 64, To: 78}}, 3, 0},
+	{"notRetryableCode", 2, []acknowledger.CodeRange{{From: 1, To: 1}}, 1, 75},
+	{"disabled", 0, nil, 1, 75},
+}
+
+func TestProcessor_Process_LocalRetry(t *testing.T) {
+	for _, test := range localRetryTests {
+		t.Run(test.name, func(t *testing.T) {
+			l := log.New(0)
+			a := new(TestAcknowledger)
+			b := new(TestBuilder)
+			d := new(TestDelivery)
+			p := &processor{
+				builder:    b,
+				ack:        a,
+				log:        l,
+				retry:      backoff.Policy{Attempts: test.attempts, Initial: time.Millisecond},
+				retryCodes: test.codes,
+			}
+
+			d.On("Body").Return([]byte(t.Name()))
+			d.On("Properties").Return(properties)
+			d.On("Info").Return(info)
+			for i := 0; i < test.runs; i++ {
+				b.On("GetCommand", properties, info, []byte(t.Name())).Return(testCommand("failUntil", true, "3"), nil).Once()
+			}
+			a.On("Ack", d, test.code).Return(nil)
+
+			assert.Nil(t, p.Process(d))
+			assert.Equal(t, test.runs-1, strings.Count(l.Buf().String(), "retrying in"))
+			a.AssertExpectations(t)
+			b.AssertExpectations(t)
+			d.AssertExpectations(t)
+		})
+	}
+}
+
+var republishTests = []struct {
+	name     string
+	exchange string
+	setup    func(p *TestPublisher, d *TestDelivery)
+}{
+	{
+		"republish",
+		"failed",
+		func(p *TestPublisher, d *TestDelivery) {
+			hostname, _ := os.Hostname()
+			p.On("Publish", "failed", "mail", mock.MatchedBy(func(msg amqp.Publishing) bool {
+				_, ok := msg.Headers[FailedAtHeader].(time.Time)
+				return ok &&
+					msg.Headers[ExitCodeHeader] == int64(3) &&
+					msg.Headers[StderrHeader] == "invalid address" &&
+					msg.Headers[ConsumerTagHeader] == "ctag" &&
+					msg.Headers[HostnameHeader] == hostname &&
+					msg.Headers["foo"] == "bar" &&
+					string(msg.Body) == "TestProcessor_Process_Republish/republish"
+			})).Return(nil)
+			d.On("Ack").Return(nil)
+		},
+	},
+	{
+		"publishError",
+		"failed",
+		func(p *TestPublisher, d *TestDelivery) {
+			p.On("Publish", "failed", "mail", mock.Anything).Return(errors.New("channel closed"))
+			d.On("Reject", false).Return(nil)
+		},
+	},
+	{
+		"noTarget",
+		"",
+		func(p *TestPublisher, d *TestDelivery) {
+			d.On("Reject", false).Return(nil)
+		},
+	},
+}
+
+func TestProcessor_Process_Republish(t *testing.T) {
+	for _, test := range republishTests {
+		t.Run(test.name, func(t *testing.T) {
+			b := new(TestBuilder)
+			d := new(TestDelivery)
+			pub := new(TestPublisher)
+			a, err := acknowledger.NewMapping([]string{"3:republish"}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := &processor{builder: b, ack: a, log: log.New(0), publisher: pub, republishExchange: test.exchange}
+
+			pr := delivery.Properties{Headers: amqp.Table{"foo": "bar"}}
+			in := delivery.Info{ConsumerTag: "ctag", RoutingKey: "mail"}
+			d.On("Body").Return([]byte(t.Name()))
+			d.On("Properties").Return(pr)
+			d.On("Info").Return(in)
+			b.On("GetCommand", pr, in, []byte(t.Name())).Return(testCommand("fail", true, "3", "invalid address"), nil)
+			test.setup(pub, d)
+
+			assert.Nil(t, p.Process(d))
+			b.AssertExpectations(t)
+			d.AssertExpectations(t)
+			pub.AssertExpectations(t)
+		})
+	}
+}
+
+func TestProcessor_Stop(t *testing.T) {
+	l := log.New(0)
+	a := new(TestAcknowledger)
+	b := new(TestBuilder)
+	d := new(TestDelivery)
+	p := &processor{builder: b, ack: a, log: l, grace: 100 * time.Millisecond, stop: make(chan struct{})}
+
+	d.On("Body").Return([]byte(t.Name()))
+	d.On("Properties").Return(properties)
+	d.On("Info").Return(info)
+	d.On("Nack", true).Return(nil).Twice()
+	b.On("GetCommand", properties, info, []byte(t.Name())).Return(testCommand("sleep", true), nil).Once()
+
+	time.AfterFunc(200*time.Millisecond, p.Stop)
+	assert.Nil(t, p.Process(d))
+	assert.Nil(t, p.Process(d))
+	assert.Equal(t, "INFO Processing message...\nINFO Shutting down, terminating process...\nINFO Failed. Check error log for details.\nERROR Error: signal: terminated\nINFO Processed!\nINFO Requeueing message of interrupted process.\n", l.Buf().String())
+	a.AssertExpectations(t)
+	b.AssertExpectations(t)
+	d.AssertExpectations(t)
+}
+
+func TestProcessor_Stop_Children(t *testing.T) {
+	a := new(TestAcknowledger)
+	b := new(TestBuilder)
+	d := new(TestDelivery)
+	p := &processor{builder: b, ack: a, log: log.New(0), grace: time.Minute, stop: make(chan struct{})}
+
+	d.On("Body").Return([]byte(t.Name()))
+	d.On("Properties").Return(properties)
+	d.On("Info").Return(info)
+	d.On("Nack", true).Return(nil).Once()
+	b.On("GetCommand", properties, info, []byte(t.Name())).Return(testCommand("spawn", true), nil).Once()
+
+	time.AfterFunc(200*time.Millisecond, p.Stop)
+	start := time.Now()
+	assert.Nil(t, p.Process(d))
+	assert.True(t, time.Since(start) < 10*time.Second)
+	a.AssertExpectations(t)
+	b.AssertExpectations(t)
+	d.AssertExpectations(t)
+}
+
+func TestProcessor_Kill(t *testing.T) {
+	a := new(TestAcknowledger)
+	b := new(TestBuilder)
+	d := new(TestDelivery)
+	p := &processor{builder: b, ack: a, log: log.New(0), grace: time.Minute, stop: make(chan struct{})}
+
+	d.On("Body").Return([]byte(t.Name()))
+	d.On("Properties").Return(properties)
+	d.On("Info").Return(info)
+	d.On("Nack", true).Return(nil).Once()
+	b.On("GetCommand", properties, info, []byte(t.Name())).Return(testCommand("ignoreTerm", true), nil).Once()
+
+	time.AfterFunc(200*time.Millisecond, p.Kill)
+	start := time.Now()
+	assert.Nil(t, p.Process(d))
+	assert.True(t, time.Since(start) < 10*time.Second)
+	a.AssertExpectations(t)
+	b.AssertExpectations(t)
+	d.AssertExpectations(t)
+}
@@ -0,0 +1,110 @@
+package processor
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+)
+
+// Headers added to messages republished by the republish action.
+const (
+	// ExitCodeHeader holds the exit code of the executable.
+	ExitCodeHeader = "x-exit-code"
+	// StderrHeader holds the tail of what the executable wrote to STDERR.
+	StderrHeader = "x-stderr"
+	// DurationHeader holds the time in milliseconds the executable was running.
+	DurationHeader = "x-duration"
+	// ConsumerTagHeader holds the tag of the consumer which received the message.
+	ConsumerTagHeader = "x-consumer-tag"
+	// HostnameHeader holds the name of the host the consumer runs on.
+	HostnameHeader = "x-hostname"
+	// FailedAtHeader holds the time the processing of the message failed.
+	FailedAtHeader = "x-failed-at"
+)
+
+// maxStderrSize limits the tail of STDERR added to republished messages.
+const maxStderrSize = 4 * 1024
+
+// failedDelivery is a delivery able to republish itself, enriched with details about the last execution.
+type failedDelivery struct {
+	delivery.Delivery
+	p   *processor
+	res *execution
+}
+
+// Republish publishes a copy of the message to the republish target and acknowledges the original. If publishing
+// fails, the message is rejected instead.
+func (d *failedDelivery) Republish() error {
+	if err := d.p.republish(d.Delivery, d.res); err != nil {
+		d.p.log.Errorf("Failed to republish message: %v", err)
+		return d.Delivery.Reject(false)
+	}
+
+	return d.Delivery.Ack()
+}
+
+func (p *processor) republish(d delivery.Delivery, res *execution) error {
+	if p.publisher == nil {
+		return fmt.Errorf("no publisher available")
+	}
+
+	hostname, _ := os.Hostname()
+
+	msg := d.Properties().Publishing(d.Body())
+	msg.Headers[ExitCodeHeader] = int64(res.code)
+	msg.Headers[StderrHeader] = res.stderr
+	msg.Headers[DurationHeader] = int64(res.duration / time.Millisecond)
+	msg.Headers[ConsumerTagHeader] = d.Info().ConsumerTag
+	msg.Headers[HostnameHeader] = hostname
+	msg.Headers[FailedAtHeader] = time.Now()
+
+	key := p.republishKey
+	if key == "" {
+		key = d.Info().RoutingKey
+	}
+
+	return p.publisher.Publish(p.republishExchange, key, msg)
+}
+
+// syncWriter serialises writes to the underlying writer.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.w.Write(b)
+}
+
+// tail keeps the last bytes written to it. It is safe for concurrent use.
+type tail struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+}
+
+func (t *tail) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buf = append(t.buf, b...)
+	if len(t.buf) > t.max {
+		t.buf = append(t.buf[:0:0], t.buf[len(t.buf)-t.max:]...)
+	}
+
+	return len(b), nil
+}
+
+func (t *tail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return string(t.buf)
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package processor
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// newProcessGroup makes the command run in a process group of its own, so signals reach the processes it spawns too.
+func newProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// signalGroup sends the signal to the process group of the started command.
+func signalGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	return syscall.Kill(-cmd.Process.Pid, sig)
+}
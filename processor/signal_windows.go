@@ -0,0 +1,19 @@
+package processor
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// newProcessGroup does nothing, as there are no process groups to signal on Windows.
+func newProcessGroup(cmd *exec.Cmd) {}
+
+// signalGroup sends the signal to the process of the started command. Other than killing, signals are not supported on
+// Windows.
+func signalGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	if sig == syscall.SIGKILL {
+		return cmd.Process.Kill()
+	}
+
+	return cmd.Process.Signal(sig)
+}
@@ -0,0 +1,238 @@
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/bketelsen/logr"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/acknowledger"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/backoff"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+)
+
+// SocketConfig defines the interface to present configurations to the socket processor.
+type SocketConfig interface {
+	Concurrency() int
+	ConsumerName() string
+	SocketPath() string
+	SocketReconnectPolicy() backoff.Policy
+	SocketTimeout() time.Duration
+	TimeoutAction() string
+}
+
+// NewSocketFromConfig creates a processor passing the messages to a daemon listening on a unix domain socket instead
+// of running an executable, see socket.Process.
+func NewSocketFromConfig(cfg SocketConfig, a acknowledger.Acknowledger, l logr.Logger) (Processor, error) {
+	b, err := newBackend(cfg.ConsumerName(), cfg.SocketTimeout(), cfg.TimeoutAction(), a, l)
+	if err != nil {
+		return nil, err
+	}
+
+	size := cfg.Concurrency()
+	if size < 1 {
+		size = 1
+	}
+
+	return &socket{
+		backend:   b,
+		path:      cfg.SocketPath(),
+		reconnect: cfg.SocketReconnectPolicy(),
+		idle:      make(chan net.Conn, size),
+	}, nil
+}
+
+type socket struct {
+	backend
+	path      string
+	reconnect backoff.Policy
+	// idle holds the connections not used by any message.
+	idle chan net.Conn
+}
+
+// Process writes the message to a connection with the daemon and acknowledges it according to the reply. The message
+// is written as frame holding a JSON envelope with the properties, the delivery info and the body encoded as base64.
+// The reply is a frame holding a result like the one of a worker. Connections are reused for the following messages.
+//
+// If writing to a reused connection fails, the message is passed once more using a new connection. Once the message got
+// written, it is not passed again, as the daemon may have processed it already; if reading the reply fails, the
+// message is put back into the queue, so it may still be processed twice. A reply failing to parse means the frames
+// are out of sync, the connection is closed and the message is put back into the queue. Connecting is retried
+// according to the reconnect policy. If the timeout is exceeded, the connection is closed and the message is
+// acknowledged according to the timeout action.
+//
+// Once the processor got stopped, the connections in use are closed and their messages, like all further messages,
+// are put back into the queue.
+func (p *socket) Process(d delivery.Delivery) error {
+	if p.ctx.Err() != nil {
+		d.Nack(true)
+		return nil
+	}
+
+	msg, err := json.Marshal(&struct {
+		Properties   delivery.Properties `json:"properties"`
+		DeliveryInfo delivery.Info       `json:"delivery_info"`
+		Body         []byte              `json:"body"`
+	}{
+		Properties:   d.Properties(),
+		DeliveryInfo: d.Info(),
+		Body:         d.Body(),
+	})
+	if err != nil {
+		d.Nack(true)
+		return NewCreateCommandError(fmt.Errorf("failed to marshall message: %v", err))
+	}
+
+	p.log.Info("Passing message to socket...")
+	start := time.Now()
+	code, res, err := p.exchange(msg)
+	observe(p.name, d, start)
+
+	if err != nil {
+		return p.failed(d, err)
+	}
+
+	if res != nil && res.Reason != "" {
+		p.log.Infof("Reason: %s", res.Reason)
+	}
+	p.log.Info("Processed!")
+
+	return p.respond(d, code, res)
+}
+
+// exchange writes the message to a connection and reads the reply. If writing to a reused connection fails, the
+// exchange is repeated once using a new connection.
+func (p *socket) exchange(msg []byte) (int, *acknowledger.Response, error) {
+	select {
+	case conn := <-p.idle:
+		code, res, err := p.exchangeOn(conn, msg)
+		var werr *writeError
+		if err == nil || p.ctx.Err() != nil || isTimeout(err) || !errors.As(err, &werr) {
+			return code, res, err
+		}
+		p.log.Errorf("Lost connection, reconnecting: %v", err)
+
+	default:
+	}
+
+	conn, err := p.dial()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return p.exchangeOn(conn, msg)
+}
+
+// exchangeOn writes the message to the connection, reads the reply and parses it. The connection is put back into the
+// pool on success and closed otherwise.
+func (p *socket) exchangeOn(conn net.Conn, msg []byte) (int, *acknowledger.Response, error) {
+	if p.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(p.timeout))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-p.ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	defer close(done)
+
+	if err := writeFrame(conn, msg); err != nil {
+		conn.Close()
+		return 0, nil, &writeError{err}
+	}
+
+	reply, err := readFrame(conn, maxFrameSize)
+	var code int
+	var res *acknowledger.Response
+	if err == nil {
+		code, res, err = parseResult(reply)
+	}
+	if err != nil {
+		conn.Close()
+		return 0, nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	select {
+	case p.idle <- conn:
+	default:
+		conn.Close()
+	}
+
+	return code, res, nil
+}
+
+// dial connects to the socket, retrying according to the reconnect policy.
+func (p *socket) dial() (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(p.ctx, "unix", p.path)
+	for attempt := 1; err != nil && !p.reconnect.Exhausted(attempt); attempt++ {
+		wait := p.reconnect.Duration(attempt)
+		p.log.Errorf("Failed to connect to %s: %v", p.path, err)
+		p.log.Infof("Reconnecting in %v (attempt %d)...", wait, attempt)
+
+		select {
+		case <-p.ctx.Done():
+			return nil, p.ctx.Err()
+		case <-time.After(wait):
+		}
+
+		conn, err = d.DialContext(p.ctx, "unix", p.path)
+	}
+	if err != nil {
+		return nil, &dialError{err}
+	}
+
+	return conn, nil
+}
+
+// failed acknowledges the message whose exchange failed. Failing to connect is reported as error creating the command.
+func (p *socket) failed(d delivery.Delivery, err error) error {
+	var derr *dialError
+	if p.ctx.Err() == nil && errors.As(err, &derr) {
+		d.Nack(true)
+		return NewCreateCommandError(fmt.Errorf("failed to connect to %s: %v", p.path, derr.err))
+	}
+
+	return p.backend.failed(d, err)
+}
+
+// Close closes the idle connections. It must only be called once no more messages are passed to the processor.
+func (p *socket) Close() error {
+	for {
+		select {
+		case conn := <-p.idle:
+			conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// dialError is the error of a failed attempt to connect.
+type dialError struct {
+	err error
+}
+
+func (e *dialError) Error() string {
+	return e.err.Error()
+}
+
+// writeError is the error of writing a message to a connection. The daemon has not got the message in full.
+type writeError struct {
+	err error
+}
+
+func (e *writeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *writeError) Unwrap() error {
+	return e.err
+}
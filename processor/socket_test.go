@@ -0,0 +1,293 @@
+package processor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/corvus-ch/logr/buffered"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/acknowledger"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/backoff"
+	"github.com/stretchr/testify/assert"
+)
+
+type testSocketConfig struct {
+	path    string
+	timeout time.Duration
+}
+
+func (c testSocketConfig) Concurrency() int                      { return 1 }
+func (c testSocketConfig) ConsumerName() string                  { return "" }
+func (c testSocketConfig) SocketReconnectPolicy() backoff.Policy { return backoff.Policy{} }
+func (c testSocketConfig) SocketPath() string                    { return c.path }
+func (c testSocketConfig) SocketTimeout() time.Duration          { return c.timeout }
+func (c testSocketConfig) TimeoutAction() string                 { return "reject" }
+
+// testDaemon answers the messages passed via a unix socket according to their body.
+type testDaemon struct {
+	net.Listener
+	dir   string
+	conns int32
+}
+
+func serveSocket(t *testing.T) *testDaemon {
+	dir, err := ioutil.TempDir("", "socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("unix", filepath.Join(dir, "consumer.sock"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	s := &testDaemon{Listener: l, dir: dir}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&s.conns, 1)
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *testDaemon) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		msg, err := readFrame(conn, maxFrameSize)
+		if err != nil {
+			return
+		}
+
+		var env struct {
+			Body []byte `json:"body"`
+		}
+		if err := json.Unmarshal(msg, &env); err != nil {
+			return
+		}
+
+		var result string
+		switch string(env.Body) {
+		case "ack", "close":
+			result = `{"action":"ack"}`
+		case "fail":
+			result = `{"exit_code":3}`
+		case "garbled":
+			result = `{"exit_code":1,"action":"bogus"}`
+		case "drop":
+			return
+		case "sleep":
+			readFrame(conn, maxFrameSize)
+			return
+		}
+		if err := writeFrame(conn, []byte(result)); err != nil || string(env.Body) == "close" {
+			return
+		}
+	}
+}
+
+func (s *testDaemon) Path() string {
+	return s.Addr().String()
+}
+
+func (s *testDaemon) Close() error {
+	defer os.RemoveAll(s.dir)
+	return s.Listener.Close()
+}
+
+var socketTests = []struct {
+	name   string
+	body   string
+	setup  func(*TestAcknowledger, *TestDelivery)
+	output string
+}{
+	{
+		"response",
+		"ack",
+		func(a *TestAcknowledger, d *TestDelivery) {
+			a.On("AckResponse", d, 0, &acknowledger.Response{Action: acknowledger.ActionAck}).Return(nil)
+		},
+		"INFO Passing message to socket...\nINFO Processed!\n",
+	},
+	{
+		"exitCode",
+		"fail",
+		func(a *TestAcknowledger, d *TestDelivery) {
+			a.On("AckResponse", d, 3, &acknowledger.Response{}).Return(nil)
+		},
+		"INFO Passing message to socket...\nINFO Processed!\n",
+	},
+	{
+		"empty",
+		"",
+		func(a *TestAcknowledger, d *TestDelivery) {
+			a.On("Ack", d, 0).Return(nil)
+		},
+		"INFO Passing message to socket...\nINFO Processed!\n",
+	},
+	{
+		"timeout",
+		"sleep",
+		func(a *TestAcknowledger, d *TestDelivery) {
+			d.On("Reject", false).Return(nil)
+		},
+		"INFO Passing message to socket...\nERROR Timeout of 100ms exceeded: ",
+	},
+}
+
+func TestSocket_Process(t *testing.T) {
+	srv := serveSocket(t)
+	defer srv.Close()
+
+	for _, test := range socketTests {
+		t.Run(test.name, func(t *testing.T) {
+			l := log.New(0)
+			a := new(TestAcknowledger)
+			d := new(TestDelivery)
+			p, err := NewSocketFromConfig(testSocketConfig{path: srv.Path(), timeout: 100 * time.Millisecond}, a, l)
+			if err != nil {
+				t.Fatalf("failed to create processor: %v", err)
+			}
+			defer p.(*socket).Close()
+
+			d.On("Body").Return([]byte(test.body))
+			d.On("Properties").Return(properties)
+			d.On("Info").Return(info)
+			test.setup(a, d)
+
+			assert.Nil(t, p.Process(d))
+			assert.Contains(t, l.Buf().String(), test.output)
+			a.AssertExpectations(t)
+			d.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSocket_Reconnect(t *testing.T) {
+	srv := serveSocket(t)
+	defer srv.Close()
+
+	l := log.New(0)
+	a := new(TestAcknowledger)
+	p, err := NewSocketFromConfig(testSocketConfig{path: srv.Path(), timeout: time.Second}, a, l)
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+	defer p.(*socket).Close()
+
+	for _, body := range []string{"ack", "close", "ack"} {
+		d := new(TestDelivery)
+		d.On("Body").Return([]byte(body))
+		d.On("Properties").Return(properties)
+		d.On("Info").Return(info)
+		a.On("AckResponse", d, 0, &acknowledger.Response{Action: acknowledger.ActionAck}).Return(nil)
+
+		assert.Nil(t, p.Process(d))
+		d.AssertExpectations(t)
+	}
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&srv.conns))
+	assert.Contains(t, l.Buf().String(), "ERROR Lost connection, reconnecting: ")
+	a.AssertExpectations(t)
+}
+
+var socketFailureTests = []struct {
+	name   string
+	body   string
+	output string
+}{
+	{"garbled", "garbled", "ERROR Request failed: invalid response: unknown action \"bogus\"\n"},
+	{"dropped", "drop", "ERROR Request failed: EOF\n"},
+}
+
+func TestSocket_Failure(t *testing.T) {
+	for _, test := range socketFailureTests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := serveSocket(t)
+			defer srv.Close()
+
+			l := log.New(0)
+			a := new(TestAcknowledger)
+			p, err := NewSocketFromConfig(testSocketConfig{path: srv.Path(), timeout: time.Second}, a, l)
+			if err != nil {
+				t.Fatalf("failed to create processor: %v", err)
+			}
+			defer p.(*socket).Close()
+
+			ok := new(TestDelivery)
+			ok.On("Body").Return([]byte("ack"))
+			ok.On("Properties").Return(properties)
+			ok.On("Info").Return(info)
+			a.On("AckResponse", ok, 0, &acknowledger.Response{Action: acknowledger.ActionAck}).Return(nil)
+			assert.Nil(t, p.Process(ok))
+
+			// The message is neither acknowledged nor passed again on a new connection.
+			d := new(TestDelivery)
+			d.On("Body").Return([]byte(test.body))
+			d.On("Properties").Return(properties)
+			d.On("Info").Return(info)
+			d.On("Nack", true).Return(nil)
+			assert.Nil(t, p.Process(d))
+
+			assert.Equal(t, int32(1), atomic.LoadInt32(&srv.conns))
+			assert.Contains(t, l.Buf().String(), test.output)
+			assert.Len(t, p.(*socket).idle, 0)
+			a.AssertExpectations(t)
+			d.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSocket_Unavailable(t *testing.T) {
+	l := log.New(0)
+	a := new(TestAcknowledger)
+	d := new(TestDelivery)
+	p, err := NewSocketFromConfig(testSocketConfig{path: filepath.Join(os.TempDir(), "missing.sock")}, a, l)
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+
+	d.On("Body").Return([]byte("ack"))
+	d.On("Properties").Return(properties)
+	d.On("Info").Return(info)
+	d.On("Nack", true).Return(nil)
+
+	err = p.Process(d)
+	assert.IsType(t, &CreateCommandError{}, err)
+	d.AssertExpectations(t)
+}
+
+func TestSocket_Stop(t *testing.T) {
+	srv := serveSocket(t)
+	defer srv.Close()
+
+	l := log.New(0)
+	a := new(TestAcknowledger)
+	d := new(TestDelivery)
+	p, err := NewSocketFromConfig(testSocketConfig{path: srv.Path(), timeout: time.Minute}, a, l)
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+
+	d.On("Body").Return([]byte("sleep"))
+	d.On("Properties").Return(properties)
+	d.On("Info").Return(info)
+	d.On("Nack", true).Return(nil).Twice()
+
+	time.AfterFunc(100*time.Millisecond, p.(Stopper).Stop)
+	assert.Nil(t, p.Process(d))
+	assert.Nil(t, p.Process(d))
+	assert.Equal(t, "INFO Passing message to socket...\nINFO Requeueing message of interrupted request.\n", l.Buf().String())
+	d.AssertExpectations(t)
+}
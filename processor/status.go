@@ -0,0 +1,73 @@
+package processor
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/acknowledger"
+)
+
+// defaultStatusMap maps the HTTP status codes not covered by the configured rules to actions.
+var defaultStatusMap = []string{"200-299:ack", "429:requeue", "400-499:reject", "500-599:requeue"}
+
+// statusMap maps HTTP status codes to the actions acknowledging the messages. The first rule matching wins.
+type statusMap []acknowledger.Rule
+
+// newStatusMap creates a status map from the rules, followed by the default rules.
+func newStatusMap(rules []string) (statusMap, error) {
+	var m statusMap
+	for _, s := range append(rules, defaultStatusMap...) {
+		r, err := acknowledger.ParseRule(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid status map: %v", err)
+		}
+		m = append(m, r)
+	}
+
+	return m, nil
+}
+
+// action looks up the action mapped to the HTTP status code. Status codes not matched by any rule put the message back
+// into the queue.
+func (m statusMap) action(status int) acknowledger.Action {
+	for _, r := range m {
+		if r.Matches(status) {
+			return r.Action
+		}
+	}
+
+	return acknowledger.ActionRequeue
+}
+
+// response creates the response acknowledging a message according to the HTTP status code. A Retry-After header
+// delays requeueing the message.
+func (m statusMap) response(status int, h http.Header) *acknowledger.Response {
+	r := &acknowledger.Response{Action: m.action(status)}
+	if delay, ok := retryAfter(h.Get("Retry-After")); ok {
+		r.Delay = delay
+	}
+
+	return r
+}
+
+// retryAfter parses the value of a Retry-After header, either a number of seconds or an HTTP date.
+func retryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+
+	if s, err := strconv.Atoi(v); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
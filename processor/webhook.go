@@ -0,0 +1,173 @@
+package processor
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/bketelsen/logr"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/acknowledger"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+)
+
+// Headers passing the metadata of a message to the webhook, unless the message is posted as JSON envelope.
+const (
+	PropertiesHeader   = "X-Rabbitmq-Properties"
+	DeliveryInfoHeader = "X-Rabbitmq-Delivery-Info"
+)
+
+// WebhookConfig defines the interface to present configurations to the webhook processor.
+type WebhookConfig interface {
+	Concurrency() int
+	ConsumerName() string
+	TimeoutAction() string
+	WebhookEnvelope() bool
+	WebhookStatusMap() []string
+	WebhookTimeout() time.Duration
+	WebhookTLSConfig() (*tls.Config, error)
+	WebhookURL() string
+}
+
+// NewWebhookFromConfig creates a processor posting the messages to the configured URL instead of running an
+// executable, see webhook.Process.
+func NewWebhookFromConfig(cfg WebhookConfig, a acknowledger.Acknowledger, l logr.Logger) (Processor, error) {
+	b, err := newBackend(cfg.ConsumerName(), cfg.WebhookTimeout(), cfg.TimeoutAction(), a, l)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses, err := newStatusMap(cfg.WebhookStatusMap())
+	if err != nil {
+		return nil, err
+	}
+
+	tlsCfg, err := cfg.WebhookTLSConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook TLS settings: %v", err)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsCfg
+	if n := cfg.Concurrency(); n > transport.MaxIdleConnsPerHost {
+		transport.MaxIdleConnsPerHost = n
+	}
+
+	return &webhook{
+		backend:  b,
+		url:      cfg.WebhookURL(),
+		envelope: cfg.WebhookEnvelope(),
+		client:   &http.Client{Transport: transport, Timeout: b.timeout},
+		statuses: statuses,
+	}, nil
+}
+
+type webhook struct {
+	backend
+	url      string
+	envelope bool
+	client   *http.Client
+	statuses statusMap
+}
+
+// Process posts the message to the webhook and acknowledges it according to the HTTP status of the response. By
+// default, 2xx acknowledges the message, 4xx rejects it and 5xx as well as 429 put it back into the queue. If the
+// response has a Retry-After header, requeueing the message is delayed accordingly. Requests exceeding the timeout
+// are handled according to the timeout action. Requests failing for other reasons put the message back into the
+// queue.
+//
+// Once the processor got stopped, the running requests are aborted and their messages, like all further messages, are
+// put back into the queue.
+func (p *webhook) Process(d delivery.Delivery) error {
+	if p.ctx.Err() != nil {
+		d.Nack(true)
+		return nil
+	}
+
+	req, err := p.request(d)
+	if err != nil {
+		d.Nack(true)
+		return NewCreateCommandError(err)
+	}
+
+	p.log.Info("Posting message...")
+	start := time.Now()
+	res, err := p.client.Do(req)
+	var body []byte
+	if err == nil {
+		body, err = ioutil.ReadAll(io.LimitReader(res.Body, maxResponseSize))
+		res.Body.Close()
+	}
+	observe(p.name, d, start)
+
+	if err != nil {
+		return p.failed(d, err)
+	}
+
+	r := p.statuses.response(res.StatusCode, res.Header)
+	if r.Action != acknowledger.ActionAck {
+		p.log.Errorf("Webhook responded with %s: %s", res.Status, bytes.TrimSpace(body))
+	} else {
+		p.log.Info("Processed!")
+	}
+
+	return p.respond(d, res.StatusCode, r)
+}
+
+// request creates the request posting the message. Either the properties and delivery info are passed as headers, or
+// the message is wrapped by a JSON envelope holding the body encoded as base64.
+func (p *webhook) request(d delivery.Delivery) (*http.Request, error) {
+	meta := struct {
+		Properties   delivery.Properties `json:"properties"`
+		DeliveryInfo delivery.Info       `json:"delivery_info"`
+		Body         []byte              `json:"body"`
+	}{
+		Properties:   d.Properties(),
+		DeliveryInfo: d.Info(),
+		Body:         d.Body(),
+	}
+
+	if p.envelope {
+		b, err := json.Marshal(&meta)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshall message: %v", err)
+		}
+
+		req, err := http.NewRequestWithContext(p.ctx, http.MethodPost, p.url, bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		return req, nil
+	}
+
+	props, err := json.Marshal(meta.Properties)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshall properties: %v", err)
+	}
+	info, err := json.Marshal(meta.DeliveryInfo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshall delivery info: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(p.ctx, http.MethodPost, p.url, bytes.NewReader(d.Body()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(PropertiesHeader, string(props))
+	req.Header.Set(DeliveryInfoHeader, string(info))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if ct := d.Properties().ContentType; ct != "" {
+		req.Header.Set("Content-Type", ct)
+	}
+	if ce := d.Properties().ContentEncoding; ce != "" {
+		req.Header.Set("Content-Encoding", ce)
+	}
+
+	return req, nil
+}
@@ -0,0 +1,208 @@
+package processor
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	log "github.com/corvus-ch/logr/buffered"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/acknowledger"
+	"github.com/stretchr/testify/assert"
+)
+
+type testWebhookConfig struct {
+	url       string
+	envelope  bool
+	timeout   time.Duration
+	statusMap []string
+}
+
+func (c testWebhookConfig) Concurrency() int                       { return 1 }
+func (c testWebhookConfig) ConsumerName() string                   { return "" }
+func (c testWebhookConfig) TimeoutAction() string                  { return "reject" }
+func (c testWebhookConfig) WebhookEnvelope() bool                  { return c.envelope }
+func (c testWebhookConfig) WebhookStatusMap() []string             { return c.statusMap }
+func (c testWebhookConfig) WebhookTimeout() time.Duration          { return c.timeout }
+func (c testWebhookConfig) WebhookTLSConfig() (*tls.Config, error) { return nil, nil }
+func (c testWebhookConfig) WebhookURL() string                     { return c.url }
+
+// webhookHandler responds with the status code given as body. A body of "sleep" delays the response.
+func webhookHandler(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) == "sleep" {
+			time.Sleep(time.Second)
+			return
+		}
+
+		status, _ := strconv.Atoi(string(body))
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "30")
+		}
+		w.WriteHeader(status)
+		if status >= 300 {
+			w.Write([]byte("nope"))
+		}
+	}
+}
+
+var webhookTests = []struct {
+	name      string
+	body      string
+	statusMap []string
+	response  *acknowledger.Response
+	code      int
+	output    string
+}{
+	{
+		"ok",
+		"204",
+		nil,
+		&acknowledger.Response{Action: acknowledger.ActionAck},
+		204,
+		"INFO Posting message...\nINFO Processed!\n",
+	},
+	{
+		"clientError",
+		"400",
+		nil,
+		&acknowledger.Response{Action: acknowledger.ActionReject},
+		400,
+		"INFO Posting message...\nERROR Webhook responded with 400 Bad Request: nope\n",
+	},
+	{
+		"serverError",
+		"503",
+		nil,
+		&acknowledger.Response{Action: acknowledger.ActionRequeue},
+		503,
+		"INFO Posting message...\nERROR Webhook responded with 503 Service Unavailable: nope\n",
+	},
+	{
+		"tooManyRequests",
+		"429",
+		nil,
+		&acknowledger.Response{Action: acknowledger.ActionRequeue, Delay: 30 * time.Second},
+		429,
+		"INFO Posting message...\nERROR Webhook responded with 429 Too Many Requests: nope\n",
+	},
+	{
+		"statusMap",
+		"409",
+		[]string{"409:ack", "400-499:deadletter"},
+		&acknowledger.Response{Action: acknowledger.ActionAck},
+		409,
+		"INFO Posting message...\nINFO Processed!\n",
+	},
+}
+
+func TestWebhook_Process(t *testing.T) {
+	srv := httptest.NewServer(webhookHandler(t))
+	defer srv.Close()
+
+	for _, test := range webhookTests {
+		t.Run(test.name, func(t *testing.T) {
+			l := log.New(0)
+			a := new(TestAcknowledger)
+			d := new(TestDelivery)
+			p, err := NewWebhookFromConfig(testWebhookConfig{url: srv.URL, timeout: time.Second, statusMap: test.statusMap}, a, l)
+			if err != nil {
+				t.Fatalf("failed to create processor: %v", err)
+			}
+
+			d.On("Body").Return([]byte(test.body))
+			d.On("Properties").Return(properties)
+			d.On("Info").Return(info)
+			a.On("AckResponse", d, test.code, test.response).Return(nil)
+
+			assert.Nil(t, p.Process(d))
+			assert.Equal(t, test.output, l.Buf().String())
+			a.AssertExpectations(t)
+			d.AssertExpectations(t)
+		})
+	}
+}
+
+func TestWebhook_Envelope(t *testing.T) {
+	var envelope map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Empty(t, r.Header.Get(PropertiesHeader))
+		json.NewDecoder(r.Body).Decode(&envelope)
+	}))
+	defer srv.Close()
+
+	l := log.New(0)
+	a := new(TestAcknowledger)
+	d := new(TestDelivery)
+	p, err := NewWebhookFromConfig(testWebhookConfig{url: srv.URL, envelope: true, timeout: time.Second}, a, l)
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+
+	d.On("Body").Return([]byte(t.Name()))
+	d.On("Properties").Return(properties)
+	d.On("Info").Return(info)
+	a.On("AckResponse", d, 200, &acknowledger.Response{Action: acknowledger.ActionAck}).Return(nil)
+
+	assert.Nil(t, p.Process(d))
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte(t.Name())), envelope["body"])
+	assert.Contains(t, envelope, "properties")
+	assert.Contains(t, envelope, "delivery_info")
+	a.AssertExpectations(t)
+}
+
+func TestWebhook_Timeout(t *testing.T) {
+	srv := httptest.NewServer(webhookHandler(t))
+	defer srv.Close()
+
+	l := log.New(0)
+	a := new(TestAcknowledger)
+	d := new(TestDelivery)
+	p, err := NewWebhookFromConfig(testWebhookConfig{url: srv.URL, timeout: 100 * time.Millisecond}, a, l)
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+
+	d.On("Body").Return([]byte("sleep"))
+	d.On("Properties").Return(properties)
+	d.On("Info").Return(info)
+	d.On("Reject", false).Return(nil)
+
+	assert.Nil(t, p.Process(d))
+	assert.Contains(t, l.Buf().String(), "ERROR Timeout of 100ms exceeded: ")
+	a.AssertExpectations(t)
+	d.AssertExpectations(t)
+}
+
+func TestWebhook_Stop(t *testing.T) {
+	srv := httptest.NewServer(webhookHandler(t))
+	defer srv.Close()
+
+	l := log.New(0)
+	a := new(TestAcknowledger)
+	d := new(TestDelivery)
+	p, err := NewWebhookFromConfig(testWebhookConfig{url: srv.URL, timeout: time.Minute}, a, l)
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+
+	d.On("Body").Return([]byte("sleep"))
+	d.On("Properties").Return(properties)
+	d.On("Info").Return(info)
+	d.On("Nack", true).Return(nil).Twice()
+
+	time.AfterFunc(100*time.Millisecond, p.(Stopper).Stop)
+	assert.Nil(t, p.Process(d))
+	assert.Nil(t, p.Process(d))
+	assert.Equal(t, "INFO Posting message...\nINFO Requeueing message of interrupted request.\n", l.Buf().String())
+	a.AssertExpectations(t)
+	d.AssertExpectations(t)
+}
@@ -0,0 +1,234 @@
+package processor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/corvus-ch/rabbitmq-cli-consumer/acknowledger"
+	"github.com/corvus-ch/rabbitmq-cli-consumer/delivery"
+)
+
+// WorkerEnv is the environment variable passing the number of the worker to the executable. Workers are numbered in
+// the order they got started, counting from one.
+const WorkerEnv = "RABBITMQ_CLI_CONSUMER_WORKER"
+
+// maxFrameSize limits the size of the frames read from a worker.
+const maxFrameSize = maxResponseSize
+
+// worker is a long-lived process of the executable, processing one message after the other. Each message is written
+// to its STDIN as two frames, the metadata as JSON followed by the body. The worker answers with a single frame
+// holding the result. A frame is the length of its payload as 32 bit unsigned integer in big endian byte order,
+// followed by the payload.
+type worker struct {
+	id       int
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	stdout   *bufio.Reader
+	stderr   *tail
+	done     chan struct{}
+	err      error
+	messages int
+}
+
+// startWorker starts the executable as worker.
+func startWorker(id int, name string, args []string, stderr io.Writer) (*worker, error) {
+	w := &worker{id: id, stderr: &tail{max: maxStderrSize}, done: make(chan struct{})}
+
+	cmd := exec.Command(name, args...)
+	newProcessGroup(cmd)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%d", WorkerEnv, id))
+	cmd.Stderr = w.stderr
+	if stderr != nil {
+		cmd.Stderr = io.MultiWriter(stderr, w.stderr)
+	}
+
+	// A pipe of our own, unlike cmd.StdoutPipe, may still be read from once the process exited. It is created first, as
+	// the STDIN pipe is only released by starting the command, be it successful or not.
+	r, stdout, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout pipe: %v", err)
+	}
+	cmd.Stdout = stdout
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		r.Close()
+		stdout.Close()
+		return nil, fmt.Errorf("failed to create stdin pipe: %v", err)
+	}
+
+	err = cmd.Start()
+	stdout.Close()
+	if err != nil {
+		stdin.Close()
+		r.Close()
+		return nil, err
+	}
+
+	w.cmd = cmd
+	w.stdin = stdin
+	w.stdout = bufio.NewReader(r)
+
+	go func() {
+		w.err = cmd.Wait()
+		// Unblock reads of a result a child of the executable might still keep open.
+		r.Close()
+		close(w.done)
+	}()
+
+	return w, nil
+}
+
+// exchange passes the message to the worker and reads its result.
+func (w *worker) exchange(d delivery.Delivery) ([]byte, error) {
+	meta, err := json.Marshal(&struct {
+		Properties   delivery.Properties `json:"properties"`
+		DeliveryInfo delivery.Info       `json:"delivery_info"`
+	}{
+		Properties:   d.Properties(),
+		DeliveryInfo: d.Info(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshall metadata: %v", err)
+	}
+
+	w.messages++
+	if err := writeFrame(w.stdin, meta); err != nil {
+		return nil, err
+	}
+	if err := writeFrame(w.stdin, d.Body()); err != nil {
+		return nil, err
+	}
+
+	return readFrame(w.stdout, maxFrameSize)
+}
+
+// exited checks if the process of the worker has exited.
+func (w *worker) exited() bool {
+	select {
+	case <-w.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop asks the worker to exit by closing its STDIN. Once the grace period has passed, it gets killed.
+func (w *worker) stop(grace time.Duration) {
+	w.stdin.Close()
+	w.wait(grace)
+}
+
+// terminate sends SIGTERM to the process group of the worker, reaching the processes it spawned as well. Once the grace
+// period has passed, they get killed.
+func (w *worker) terminate(grace time.Duration) {
+	signalGroup(w.cmd, syscall.SIGTERM)
+	w.wait(grace)
+}
+
+// kill kills the process group of the worker right away.
+func (w *worker) kill() {
+	signalGroup(w.cmd, syscall.SIGKILL)
+	<-w.done
+}
+
+func (w *worker) wait(grace time.Duration) {
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	select {
+	case <-w.done:
+	case <-timer.C:
+		w.kill()
+	}
+}
+
+// memory returns the resident set size of the worker process in bytes. It is only supported on Linux.
+func (w *worker) memory() (int64, error) {
+	status, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/status", w.cmd.Process.Pid))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range bytes.Split(status, []byte("\n")) {
+		if !bytes.HasPrefix(line, []byte("VmRSS:")) {
+			continue
+		}
+
+		fields := bytes.Fields(line)
+		if len(fields) < 2 {
+			break
+		}
+		kb, err := strconv.ParseInt(string(fields[1]), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return kb * 1024, nil
+	}
+
+	return 0, fmt.Errorf("no resident set size found")
+}
+
+// writeFrame writes the payload prefixed by its length.
+func writeFrame(w io.Writer, p []byte) error {
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(p)))
+	if _, err := w.Write(size[:]); err != nil {
+		return err
+	}
+
+	_, err := w.Write(p)
+	return err
+}
+
+// readFrame reads a payload prefixed by its length, which must not exceed max.
+func readFrame(r io.Reader, max int) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(size[:])
+	if int64(n) > int64(max) {
+		return nil, fmt.Errorf("frame of %d bytes exceeds the maximum of %d bytes", n, max)
+	}
+
+	p := make([]byte, n)
+	if _, err := io.ReadFull(r, p); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// parseResult parses the result of a worker. Besides the fields of a response, it may hold an exit code, which is zero
+// unless set. An empty result is a success without response.
+func parseResult(b []byte) (int, *acknowledger.Response, error) {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return 0, nil, nil
+	}
+
+	var raw struct {
+		ExitCode int `json:"exit_code"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return 0, nil, fmt.Errorf("failed to parse result: %v", err)
+	}
+
+	r, err := acknowledger.ParseResponse(b)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return raw.ExitCode, r, nil
+}